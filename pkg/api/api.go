@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	lgit "github.com/adevinta/maiao/pkg/git"
 	"github.com/adevinta/maiao/pkg/log"
 )
 
@@ -33,7 +34,7 @@ func NewRepoFromGitRemote(remoteName string) (*Repo, error) {
 		return nil, err
 	}
 	r := &Repo{
-		Domain:     endpoint.Host,
+		Domain:     lgit.ResolveSSHEndpoint(endpoint).Host,
 		Repository: strings.TrimSuffix(strings.TrimPrefix(endpoint.Path, "/"), ".git"),
 	}
 	// The API endpoint is exposed over HTTP, those are the only credentials interesting
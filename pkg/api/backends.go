@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// BackendFactory instanciates a PullRequester for a given remote endpoint
+type BackendFactory func(context.Context, *transport.Endpoint, BackendOptions) (PullRequester, error)
+
+// Backend describes a forge pull requests can be created on
+type Backend struct {
+	// Name is the name used to select the backend in the maiao.<host>.forge git configuration
+	Name string
+	// New instanciates the backend for a remote endpoint
+	New BackendFactory
+	// Detect reports whether the host name looks like an instance of this forge.
+	// Detected backends are tried first when no forge is configured for the host
+	Detect func(host string) bool
+	// Explicit backends are never probed and must be selected by name
+	Explicit bool
+}
+
+var (
+	// backends holds the registered backends, by name
+	backends = map[string]Backend{}
+	// backendNames keeps the registration order, which is the order backends are probed in
+	backendNames = []string{}
+)
+
+// RegisterBackend adds a backend to the registry, replacing any existing backend with the same name
+func RegisterBackend(backend Backend) {
+	if _, ok := backends[backend.Name]; !ok {
+		backendNames = append(backendNames, backend.Name)
+	}
+	backends[backend.Name] = backend
+}
+
+// Backends returns the names of the registered backends, in probing order
+func Backends() []string {
+	return append([]string{}, backendNames...)
+}
+
+// hostContains returns a detection function matching hosts having one of the given names in their labels
+func hostContains(names ...string) func(string) bool {
+	return func(host string) bool {
+		for _, label := range strings.Split(strings.ToLower(host), ".") {
+			for _, name := range names {
+				if strings.Contains(label, name) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+func init() {
+	RegisterBackend(Backend{
+		Name: "github",
+		New: func(ctx context.Context, endpoint *transport.Endpoint, options BackendOptions) (PullRequester, error) {
+			return newGitHubUpserter(ctx, endpoint, gitHubAPIEndpoints(options, endpoint.Host), options.Credentials)
+		},
+		Detect: hostContains("github"),
+	})
+	RegisterBackend(Backend{
+		Name: "gitlab",
+		New: func(ctx context.Context, endpoint *transport.Endpoint, options BackendOptions) (PullRequester, error) {
+			return newGitLabUpserter(ctx, endpoint, options.Credentials)
+		},
+		Detect: hostContains("gitlab"),
+	})
+	RegisterBackend(Backend{
+		Name: "gitea",
+		New: func(ctx context.Context, endpoint *transport.Endpoint, options BackendOptions) (PullRequester, error) {
+			return newGiteaUpserter(ctx, endpoint, options.Credentials)
+		},
+		Detect: hostContains("gitea", "forgejo", "codeberg"),
+	})
+	RegisterBackend(Backend{
+		Name: "bitbucket",
+		New: func(ctx context.Context, endpoint *transport.Endpoint, options BackendOptions) (PullRequester, error) {
+			return newBitbucketUpserter(ctx, endpoint, options.Credentials)
+		},
+		Detect: hostContains("bitbucket"),
+	})
+	RegisterBackend(Backend{
+		Name: "gerrit",
+		New: func(ctx context.Context, endpoint *transport.Endpoint, options BackendOptions) (PullRequester, error) {
+			return newGerritUpserter(ctx, endpoint, options.Credentials)
+		},
+		Detect: hostContains("gerrit"),
+	})
+	RegisterBackend(Backend{
+		Name: "local",
+		New: func(ctx context.Context, endpoint *transport.Endpoint, options BackendOptions) (PullRequester, error) {
+			local, err := NewLocalPullRequester(ctx, options.GitDir)
+			if err != nil {
+				return nil, err
+			}
+			// rehearsals only write to the remote when explicitly asked to
+			local.PushBranches, _ = strconv.ParseBool(lgit.ConfigOption(options.Config, "maiao", "", "localPush"))
+			return local, nil
+		},
+		Explicit: true,
+	})
+}
+
+// HostOption returns the value of the maiao.<host>.<key> git configuration.
+// The repository configuration takes precedence over the global one
+func HostOption(cfg *config.Config, host, key string) string {
+	return lgit.ConfigOption(cfg, "maiao", host, key)
+}
+
+// configuredHosts returns the host names the maiao.<host>.* git configuration of the remote is looked up for,
+// in order: its ssh host alias, if any, then its host
+func configuredHosts(options BackendOptions, host string) []string {
+	if options.HostAlias != "" && options.HostAlias != host {
+		return []string{options.HostAlias, host}
+	}
+	return []string{host}
+}
+
+// candidateBackends returns the backends to try, in order, for host.
+// When a backend is forced or a forge is configured for the ssh host alias of the remote or for host,
+// only this one is returned, falling back to the git-review-backend-<name> plugin when no built-in backend has this name
+func candidateBackends(options BackendOptions, host string) ([]Backend, error) {
+	name := options.Backend
+	for _, h := range configuredHosts(options, host) {
+		if name != "" {
+			break
+		}
+		name = HostOption(options.Config, h, "forge")
+	}
+	if name != "" {
+		backend, ok := backends[name]
+		if !ok {
+			backend, ok = pluginBackend(name)
+		}
+		if !ok {
+			return nil, &UnknownBackendError{Host: host, Name: name}
+		}
+		return []Backend{backend}, nil
+	}
+	detected := []Backend{}
+	others := []Backend{}
+	for _, name := range backendNames {
+		backend := backends[name]
+		if backend.Explicit {
+			continue
+		}
+		if backend.Detect != nil && backend.Detect(host) {
+			detected = append(detected, backend)
+		} else {
+			others = append(others, backend)
+		}
+	}
+	return append(detected, others...), nil
+}
+
+// UnknownBackendError is returned when the forge configured for a host is not registered
+type UnknownBackendError struct {
+	Host string
+	Name string
+}
+
+// Error implements the error interface
+func (e *UnknownBackendError) Error() string {
+	return fmt.Sprintf("unknown forge %s configured in maiao.%s.forge and no %s%s plugin found, available forges: %s", e.Name, e.Host, PluginPrefix, e.Name, strings.Join(backendNames, ", "))
+}
+
+// NoBackendError is returned when none of the candidate backends could be instanciated for a host
+type NoBackendError struct {
+	Host  string
+	Tried []string
+}
+
+// Error implements the error interface
+func (e *NoBackendError) Error() string {
+	return fmt.Sprintf("unable to find a pull request backend for %s, tried: %s. Set the maiao.%s.forge git configuration to select one", e.Host, strings.Join(e.Tried, ", "), e.Host)
+}
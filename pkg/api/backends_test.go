@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type namedPullRequester struct {
+	PullRequester
+	name string
+}
+
+// withTestBackends replaces the registered backends for the duration of the test
+func withTestBackends(t *testing.T, available map[string]bool, detected map[string]string) *[]string {
+	t.Helper()
+	originalBackends, originalNames := backends, backendNames
+	t.Cleanup(func() {
+		backends, backendNames = originalBackends, originalNames
+	})
+	backends, backendNames = map[string]Backend{}, []string{}
+	tried := []string{}
+	for _, name := range []string{"github", "gitlab", "gitea"} {
+		name := name
+		RegisterBackend(Backend{
+			Name: name,
+			New: func(ctx context.Context, endpoint *transport.Endpoint, options BackendOptions) (PullRequester, error) {
+				tried = append(tried, name)
+				if !available[name] {
+					return nil, errors.New(name + " not available")
+				}
+				return &namedPullRequester{name: name}, nil
+			},
+			Detect: func(host string) bool {
+				return detected[host] == name
+			},
+		})
+	}
+	return &tried
+}
+
+func testRemote(t *testing.T, urls ...string) *git.Remote {
+	return git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: urls})
+}
+
+func testConfig(t *testing.T, host, forge string) *config.Config {
+	cfg := config.NewConfig()
+	cfg.Raw.Section("maiao").Subsection(host).SetOption("forge", forge)
+	return cfg
+}
+
+func TestNewPullRequester(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	t.Run("backends are probed in registration order", func(t *testing.T) {
+		tried := withTestBackends(t, map[string]bool{"gitlab": true, "gitea": true}, nil)
+		pr, err := NewPullRequester(context.Background(), testRemote(t, "git@git.example.com:org/repo.git"), BackendOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "gitlab", pr.(*namedPullRequester).name)
+		assert.Equal(t, []string{"github", "gitlab"}, *tried)
+	})
+
+	t.Run("backends detected from the host name are tried first", func(t *testing.T) {
+		tried := withTestBackends(t, map[string]bool{"gitlab": true, "gitea": true}, map[string]string{"git.example.com": "gitea"})
+		pr, err := NewPullRequester(context.Background(), testRemote(t, "git@git.example.com:org/repo.git"), BackendOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "gitea", pr.(*namedPullRequester).name)
+		assert.Equal(t, []string{"gitea"}, *tried)
+	})
+
+	t.Run("the configured forge is used", func(t *testing.T) {
+		tried := withTestBackends(t, map[string]bool{"gitlab": true, "gitea": true}, map[string]string{"git.example.com": "gitlab"})
+		pr, err := NewPullRequester(context.Background(), testRemote(t, "git@git.example.com:org/repo.git"), BackendOptions{Config: testConfig(t, "git.example.com", "gitea")})
+		require.NoError(t, err)
+		assert.Equal(t, "gitea", pr.(*namedPullRequester).name)
+		assert.Equal(t, []string{"gitea"}, *tried)
+	})
+
+	t.Run("the configured forge is not probed against others", func(t *testing.T) {
+		tried := withTestBackends(t, map[string]bool{"gitlab": true}, nil)
+		_, err := NewPullRequester(context.Background(), testRemote(t, "git@git.example.com:org/repo.git"), BackendOptions{Config: testConfig(t, "git.example.com", "gitea")})
+		require.Error(t, err)
+		assert.Equal(t, []string{"gitea"}, *tried)
+		assert.Equal(t, "unable to find a pull request backend for git.example.com, tried: gitea. Set the maiao.git.example.com.forge git configuration to select one", err.Error())
+	})
+
+	t.Run("the global configuration is used when the repository one does not configure the host", func(t *testing.T) {
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".gitconfig"), []byte("[maiao \"git.example.com\"]\n\tforge = gitlab\n"), 0o644))
+		withTestBackends(t, map[string]bool{"github": true, "gitlab": true}, nil)
+		pr, err := NewPullRequester(context.Background(), testRemote(t, "git@git.example.com:org/repo.git"), BackendOptions{Config: testConfig(t, "other.example.com", "gitea")})
+		require.NoError(t, err)
+		assert.Equal(t, "gitlab", pr.(*namedPullRequester).name)
+	})
+
+	t.Run("an unknown configured forge is reported", func(t *testing.T) {
+		withTestBackends(t, nil, nil)
+		_, err := NewPullRequester(context.Background(), testRemote(t, "git@git.example.com:org/repo.git"), BackendOptions{Config: testConfig(t, "git.example.com", "unknown")})
+		require.Error(t, err)
+		assert.IsType(t, &UnknownBackendError{}, err)
+		assert.Equal(t, "unknown forge unknown configured in maiao.git.example.com.forge and no git-review-backend-unknown plugin found, available forges: github, gitlab, gitea", err.Error())
+	})
+
+	t.Run("ssh host aliases are resolved before selecting the backend", func(t *testing.T) {
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		require.NoError(t, os.MkdirAll(filepath.Join(home, ".ssh"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte("Host github-work\n\tHostName git.example.com\n"), 0o644))
+		withTestBackends(t, map[string]bool{"gitlab": true, "gitea": true}, map[string]string{"git.example.com": "gitea"})
+		hosts := []string{}
+		RegisterBackend(Backend{
+			Name: "recorder",
+			New: func(ctx context.Context, endpoint *transport.Endpoint, options BackendOptions) (PullRequester, error) {
+				hosts = append(hosts, endpoint.Host)
+				return &namedPullRequester{name: "recorder"}, nil
+			},
+		})
+
+		pr, err := NewPullRequester(context.Background(), testRemote(t, "git@github-work:org/repo.git"), BackendOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "gitea", pr.(*namedPullRequester).name)
+
+		pr, err = NewPullRequester(context.Background(), testRemote(t, "git@github-work:org/repo.git"), BackendOptions{Config: testConfig(t, "github-work", "recorder")})
+		require.NoError(t, err)
+		assert.Equal(t, "recorder", pr.(*namedPullRequester).name)
+		assert.Equal(t, []string{"git.example.com"}, hosts)
+	})
+
+	t.Run("the error names the host and the tried backends", func(t *testing.T) {
+		withTestBackends(t, nil, nil)
+		_, err := NewPullRequester(context.Background(), testRemote(t, "https://git.example.com/org/repo.git"), BackendOptions{})
+		require.Error(t, err)
+		noBackend := &NoBackendError{}
+		require.ErrorAs(t, err, &noBackend)
+		assert.Equal(t, "git.example.com", noBackend.Host)
+		assert.Equal(t, []string{"github", "gitlab", "gitea"}, noBackend.Tried)
+	})
+}
+
+func TestHostContains(t *testing.T) {
+	detect := hostContains("gitea", "codeberg")
+	assert.True(t, detect("codeberg.org"))
+	assert.True(t, detect("gitea.company.example.com"))
+	assert.True(t, detect("my-gitea.example.com"))
+	assert.False(t, detect("github.com"))
+}
+
+func TestDefaultBackends(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	assert.Equal(t, []string{"github", "gitlab", "gitea", "bitbucket", "gerrit", "local"}, Backends())
+	candidates, err := candidateBackends(BackendOptions{}, "gitlab.company.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "gitlab", candidates[0].Name)
+	assert.Len(t, candidates, 5)
+}
+
+func TestForkSupport(t *testing.T) {
+	for _, prAPI := range []PullRequester{&GitHub{}, &Gitea{}} {
+		require.Implements(t, (*ForkSupporter)(nil), prAPI)
+		assert.True(t, prAPI.(ForkSupporter).SupportsForks())
+	}
+	for _, prAPI := range []PullRequester{&GitLab{}, &Bitbucket{}, &Gerrit{}, &Local{}, &Plugin{}} {
+		assert.NotImplements(t, (*ForkSupporter)(nil), prAPI, "<owner>:<branch> heads must not be sent to %T", prAPI)
+	}
+}
+
+func TestLocalBackendKeepsBranchesLocal(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: []string{"https://github.com/adevinta/maiao.git"}})
+	cfg := config.NewConfig()
+	prAPI, err := NewPullRequester(context.Background(), remote, BackendOptions{Config: cfg, Backend: "local", GitDir: t.TempDir()})
+	require.NoError(t, err)
+	require.Implements(t, (*BranchKeeper)(nil), prAPI)
+	assert.True(t, prAPI.(BranchKeeper).KeepsBranchesLocal(), "rehearsals must not write to the remote by default")
+
+	cfg.Raw.Section("maiao").SetOption("localPush", "true")
+	prAPI, err = NewPullRequester(context.Background(), remote, BackendOptions{Config: cfg, Backend: "local", GitDir: t.TempDir()})
+	require.NoError(t, err)
+	assert.False(t, prAPI.(BranchKeeper).KeepsBranchesLocal())
+}
@@ -0,0 +1,489 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/adevinta/maiao/pkg/credentials"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sirupsen/logrus"
+)
+
+const bitbucketBranchPrefix = "refs/heads/"
+
+// Bitbucket implements the PullRequester interface for Bitbucket Server and Data Center
+type Bitbucket struct {
+	restClient
+	Host string
+	// ContextPath is the path Bitbucket is served under, if any
+	ContextPath string
+	// Project is the key of the Bitbucket project
+	Project string
+	// Repository is the slug of the repository
+	Repository string
+}
+
+type bitbucketRepository struct {
+	Slug    string `json:"slug"`
+	Project struct {
+		Key string `json:"key"`
+	} `json:"project"`
+}
+
+type bitbucketRef struct {
+	ID           string               `json:"id"`
+	DisplayID    string               `json:"displayId,omitempty"`
+	LatestCommit string               `json:"latestCommit,omitempty"`
+	Repository   *bitbucketRepository `json:"repository,omitempty"`
+}
+
+type bitbucketPullRequest struct {
+	ID          int          `json:"id"`
+	Version     int          `json:"version"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	State       string       `json:"state"`
+	Draft       bool         `json:"draft"`
+	FromRef     bitbucketRef `json:"fromRef"`
+	ToRef       bitbucketRef `json:"toRef"`
+	Reviewers   []struct {
+		Status string `json:"status"`
+	} `json:"reviewers,omitempty"`
+	Links struct {
+		Self []struct {
+			Href string `json:"href"`
+		} `json:"self"`
+	} `json:"links"`
+}
+
+type bitbucketPullRequestPage struct {
+	Values        []bitbucketPullRequest `json:"values"`
+	IsLastPage    bool                   `json:"isLastPage"`
+	NextPageStart int                    `json:"nextPageStart"`
+}
+
+func (pr *bitbucketPullRequest) pullRequest() *PullRequest {
+	p := &PullRequest{
+		ID: strconv.Itoa(pr.ID),
+	}
+	if len(pr.Links.Self) > 0 {
+		p.URL = pr.Links.Self[0].Href
+	}
+	return p
+}
+
+func (b *Bitbucket) repoPath(elements ...string) string {
+	return strings.Join(append([]string{"projects", url.PathEscape(b.Project), "repos", url.PathEscape(b.Repository)}, elements...), "/")
+}
+
+func (b *Bitbucket) ref(branch string) bitbucketRef {
+	r := bitbucketRef{
+		ID:         bitbucketBranchPrefix + branch,
+		Repository: &bitbucketRepository{Slug: b.Repository},
+	}
+	r.Repository.Project.Key = b.Project
+	return r
+}
+
+// pullRequests lists all pull requests in state (OPEN, MERGED, DECLINED or ALL) from the head branch, the most recent first
+func (b *Bitbucket) pullRequests(ctx context.Context, head, state string) ([]bitbucketPullRequest, error) {
+	return b.branchPullRequests(ctx, head, state, "OUTGOING")
+}
+
+// branchPullRequests lists all pull requests in state from (OUTGOING direction) or to (INCOMING direction) branch,
+// the most recent first
+func (b *Bitbucket) branchPullRequests(ctx context.Context, branch, state, direction string) ([]bitbucketPullRequest, error) {
+	matching := []bitbucketPullRequest{}
+	start := 0
+	for {
+		page := bitbucketPullRequestPage{}
+		err := b.do(ctx, http.MethodGet, b.repoPath("pull-requests"), url.Values{
+			"state":     {state},
+			"direction": {direction},
+			"at":        {bitbucketBranchPrefix + branch},
+			"order":     {"NEWEST"},
+			"start":     {strconv.Itoa(start)},
+		}, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range page.Values {
+			// at is not strictly filtering on the branch in all Bitbucket versions,
+			// ensure the pull request actually comes from or goes to the expected branch
+			ref := pr.FromRef
+			if direction == "INCOMING" {
+				ref = pr.ToRef
+			}
+			if ref.ID == bitbucketBranchPrefix+branch {
+				matching = append(matching, pr)
+			}
+		}
+		if page.IsLastPage || len(page.Values) == 0 {
+			return matching, nil
+		}
+		start = page.NextPageStart
+	}
+}
+
+// Ensure ensures a PR is opened for the head branch
+func (b *Bitbucket) Ensure(ctx context.Context, options PullRequestOptions) (*PullRequest, bool, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "ensuring existing pull request",
+		"project":    b.Project,
+		"repository": b.Repository,
+		"prOptions":  options,
+	})
+	prs, err := b.pullRequests(ctx, options.Head, "OPEN")
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to list existing pull requests")
+		return nil, false, err
+	}
+	switch len(prs) {
+	case 0:
+		if options.WIP {
+			log.ForContext(ctx).Info("adding draft marker")
+		}
+		pr := bitbucketPullRequest{}
+		err := b.do(ctx, http.MethodPost, b.repoPath("pull-requests"), nil, map[string]interface{}{
+			"title":       options.Title,
+			"description": options.Body,
+			"draft":       options.WIP,
+			"fromRef":     b.ref(options.Head),
+			"toRef":       b.ref(options.Base),
+		}, &pr)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to create new pull request")
+			return nil, false, err
+		}
+		log.ForContext(ctx).Debug("new PR has been created")
+		return pr.pullRequest(), true, nil
+	case 1:
+		log.ForContext(ctx).Trace("PR already existed")
+		return prs[0].pullRequest(), false, nil
+	}
+	log.ForContext(ctx).Error("too many matching pull requests")
+	return nil, false, errors.New("Too many matching pull requests")
+}
+
+// Update implements the Update interface to update an existing pull request
+func (b *Bitbucket) Update(ctx context.Context, pr *PullRequest, options PullRequestOptions) (*PullRequest, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "updating existing pull request",
+		"project":    b.Project,
+		"repository": b.Repository,
+		"prOptions":  options,
+		"prID":       pr.ID,
+	})
+	// Bitbucket uses optimistic locking, the current version of the pull request
+	// must be provided to update it
+	current := bitbucketPullRequest{}
+	err := b.do(ctx, http.MethodGet, b.repoPath("pull-requests", pr.ID), nil, nil, &current)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get pull request")
+		return nil, err
+	}
+	draft := current.Draft
+	if options.Ready {
+		log.ForContext(ctx).Info("marking pull request as ready")
+		draft = false
+	}
+	updated := bitbucketPullRequest{}
+	err = b.do(ctx, http.MethodPut, b.repoPath("pull-requests", pr.ID), nil, map[string]interface{}{
+		"version":     current.Version,
+		"title":       options.Title,
+		"description": options.Body,
+		"draft":       draft,
+		"toRef":       b.ref(options.Base),
+	}, &updated)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to edit pull request")
+		return nil, err
+	}
+	log.ForContext(ctx).Info("edit pull request")
+	return updated.pullRequest(), nil
+}
+
+// Find returns the status of the most recent pull request opened for head, or nil when none exists
+func (b *Bitbucket) Find(ctx context.Context, head string) (*PullRequestStatus, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "finding pull request",
+		"project":    b.Project,
+		"repository": b.Repository,
+		"head":       head,
+	})
+	_, branch := splitHead(head)
+	prs, err := b.pullRequests(ctx, branch, "ALL")
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to list pull requests")
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	return b.reviewStatus(ctx, &prs[0]), nil
+}
+
+func (pr *bitbucketPullRequest) status() *PullRequestStatus {
+	status := &PullRequestStatus{
+		PullRequest: *pr.pullRequest(),
+		Base:        strings.TrimPrefix(pr.ToRef.ID, bitbucketBranchPrefix),
+		Head:        strings.TrimPrefix(pr.FromRef.ID, bitbucketBranchPrefix),
+		Title:       pr.Title,
+		State:       PullRequestStateOpen,
+		Body:        pr.Description,
+	}
+	switch {
+	case pr.State == "MERGED":
+		status.State = PullRequestStateMerged
+	case pr.State == "DECLINED":
+		status.State = PullRequestStateClosed
+	case pr.Draft:
+		status.State = PullRequestStateDraft
+	}
+	return status
+}
+
+// reviewStatus returns the status of the pull request, including its review decision and checks
+func (b *Bitbucket) reviewStatus(ctx context.Context, pr *bitbucketPullRequest) *PullRequestStatus {
+	status := pr.status()
+	status.ReviewDecision = ReviewDecisionRequired
+	for _, reviewer := range pr.Reviewers {
+		switch reviewer.Status {
+		case "NEEDS_WORK":
+			status.ReviewDecision = ReviewDecisionChangesRequested
+		case "APPROVED":
+			if status.ReviewDecision != ReviewDecisionChangesRequested {
+				status.ReviewDecision = ReviewDecisionApproved
+			}
+		}
+	}
+
+	if pr.FromRef.LatestCommit != "" {
+		// build statuses are served by a separate API, next to the core one
+		stats := struct {
+			Successful int `json:"successful"`
+			Failed     int `json:"failed"`
+			InProgress int `json:"inProgress"`
+		}{}
+		err := b.do(ctx, http.MethodGet, "../../build-status/1.0/commits/stats/"+url.PathEscape(pr.FromRef.LatestCommit), nil, nil, &stats)
+		switch {
+		case err != nil:
+			log.ForContext(ctx).WithError(err).Warn("failed to get pull request build statuses")
+		case stats.Failed > 0:
+			status.Checks = ChecksFailure
+		case stats.InProgress > 0:
+			status.Checks = ChecksPending
+		case stats.Successful > 0:
+			status.Checks = ChecksSuccess
+		}
+	}
+	return status
+}
+
+// Get returns the status of the pull request identified by id
+func (b *Bitbucket) Get(ctx context.Context, id string) (*PullRequestStatus, error) {
+	pr := bitbucketPullRequest{}
+	err := b.do(ctx, http.MethodGet, b.repoPath("pull-requests", id), nil, nil, &pr)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", id).Error("failed to get pull request")
+		return nil, err
+	}
+	return b.reviewStatus(ctx, &pr), nil
+}
+
+// ListOpen returns the open pull requests targeting the base branch
+func (b *Bitbucket) ListOpen(ctx context.Context, base string) ([]*PullRequestStatus, error) {
+	prs, err := b.branchPullRequests(ctx, base, "OPEN", "INCOMING")
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("base", base).Error("failed to list pull requests")
+		return nil, err
+	}
+	statuses := []*PullRequestStatus{}
+	for i := range prs {
+		statuses = append(statuses, prs[i].status())
+	}
+	return statuses, nil
+}
+
+// Close declines the pull request, commenting it first when comment is not empty
+func (b *Bitbucket) Close(ctx context.Context, pr *PullRequest, comment string) error {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "declining pull request",
+		"project":    b.Project,
+		"repository": b.Repository,
+		"prID":       pr.ID,
+	})
+	if comment != "" {
+		err := b.do(ctx, http.MethodPost, b.repoPath("pull-requests", pr.ID, "comments"), nil, map[string]string{"text": comment}, nil)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to comment pull request")
+			return err
+		}
+	}
+	current := bitbucketPullRequest{}
+	err := b.do(ctx, http.MethodGet, b.repoPath("pull-requests", pr.ID), nil, nil, &current)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get pull request")
+		return err
+	}
+	err = b.do(ctx, http.MethodPost, b.repoPath("pull-requests", pr.ID, "decline"), url.Values{"version": {strconv.Itoa(current.Version)}}, nil, nil)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to decline pull request")
+		return err
+	}
+	return nil
+}
+
+// Retarget changes the base branch of the pull request
+func (b *Bitbucket) Retarget(ctx context.Context, pr *PullRequest, base string) error {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "retargeting pull request",
+		"project":    b.Project,
+		"repository": b.Repository,
+		"prID":       pr.ID,
+		"base":       base,
+	})
+	current := bitbucketPullRequest{}
+	err := b.do(ctx, http.MethodGet, b.repoPath("pull-requests", pr.ID), nil, nil, &current)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get pull request")
+		return err
+	}
+	err = b.do(ctx, http.MethodPut, b.repoPath("pull-requests", pr.ID), nil, map[string]interface{}{
+		"version":     current.Version,
+		"title":       current.Title,
+		"description": current.Description,
+		"draft":       current.Draft,
+		"toRef":       b.ref(base),
+	}, nil)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to change pull request base")
+		return err
+	}
+	return nil
+}
+
+// bitbucketMergeStrategies maps merge methods to the Bitbucket merge strategies implementing them
+var bitbucketMergeStrategies = map[string]string{
+	MergeMethodMerge:  "no-ff",
+	MergeMethodSquash: "squash",
+	MergeMethodRebase: "rebase-no-ff",
+}
+
+// Merge merges the pull request into its base branch, with the strategy matching method
+func (b *Bitbucket) Merge(ctx context.Context, pr *PullRequest, method string) error {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "merging pull request",
+		"project":    b.Project,
+		"repository": b.Repository,
+		"prID":       pr.ID,
+		"method":     method,
+	})
+	strategy, ok := bitbucketMergeStrategies[method]
+	if !ok {
+		return fmt.Errorf("unsupported merge method %s", method)
+	}
+	current := bitbucketPullRequest{}
+	err := b.do(ctx, http.MethodGet, b.repoPath("pull-requests", pr.ID), nil, nil, &current)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get pull request")
+		return err
+	}
+	err = b.do(ctx, http.MethodPost, b.repoPath("pull-requests", pr.ID, "merge"), url.Values{"version": {strconv.Itoa(current.Version)}}, map[string]string{"strategyId": strategy}, nil)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to merge pull request")
+		return err
+	}
+	return nil
+}
+
+// DefaultBranch returns the default branch of the remote repository
+func (b *Bitbucket) DefaultBranch(ctx context.Context) string {
+	branch := bitbucketRef{}
+	err := b.do(ctx, http.MethodGet, b.repoPath("default-branch"), nil, nil, &branch)
+	if isNotFound(err) {
+		// Bitbucket versions prior to 7.5 only expose the deprecated endpoint
+		err = b.do(ctx, http.MethodGet, b.repoPath("branches", "default"), nil, nil, &branch)
+	}
+	if err != nil {
+		return ""
+	}
+	if branch.DisplayID != "" {
+		return branch.DisplayID
+	}
+	return strings.TrimPrefix(branch.ID, bitbucketBranchPrefix)
+}
+
+// LinkedTopicIssues returns the URL to follow linked pull requests.
+// Bitbucket does not provide a cross repository full text search on pull requests,
+// point to the pull requests dashboard instead
+func (b *Bitbucket) LinkedTopicIssues(topicSearchString string) string {
+	u := url.URL{Scheme: "https", Host: b.Host, Path: "/" + strings.Trim(b.ContextPath+"/dashboard", "/")}
+	return u.String()
+}
+
+// NewBitbucketUpserter instanciates an upserter that uses the Bitbucket Server/Data Center REST API
+// to create and update pull requests
+//
+// Credentials are taken from the BITBUCKET_TOKEN environment variable or from
+// the default credential store
+func NewBitbucketUpserter(ctx context.Context, endpoint *transport.Endpoint) (*Bitbucket, error) {
+	return newBitbucketUpserter(ctx, endpoint, nil)
+}
+
+// splitBitbucketPath splits a remote path into the Bitbucket context path, if any, and the repository name.
+// Bitbucket Server and Data Center expose their http remotes as [/<context-path>]/scm/<project>/<repo>
+func splitBitbucketPath(path string) (string, string) {
+	parts := strings.Split(strings.TrimSuffix(strings.Trim(path, "/"), ".git"), "/")
+	if len(parts) >= 3 && parts[len(parts)-3] == "scm" {
+		return strings.Join(parts[:len(parts)-3], "/"), strings.Join(parts[len(parts)-2:], "/")
+	}
+	return "", strings.Join(parts, "/")
+}
+
+// newBitbucketUpserter instanciates the Bitbucket client, authenticating with getter, the default credential store when nil
+func newBitbucketUpserter(ctx context.Context, endpoint *transport.Endpoint, getter credentials.CredentialGetter) (*Bitbucket, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":  "initializing Bitbucket client",
+		"endpoint": endpoint,
+	})
+	contextPath, repository := splitBitbucketPath(endpoint.Path)
+	projectRepo := strings.Split(repository, "/")
+	if len(projectRepo) != 2 {
+		log.ForContext(ctx).WithField("repository", endpoint.Path).Error("invalid repository, expecting [scm/]<project>/<repo>")
+		return nil, fmt.Errorf("invalid repository, expecting [scm/]<project>/<repo>")
+	}
+	httpClient, err := newTokenHTTPClient(ctx, getter, endpoint.Host, "BITBUCKET_TOKEN")
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Errorf("failed to create a new http client: %s", err.Error())
+		return nil, err
+	}
+	b := &Bitbucket{
+		restClient: restClient{
+			Client:  httpClient,
+			BaseURL: &url.URL{Scheme: "https", Host: endpoint.Host, Path: "/" + strings.TrimPrefix(contextPath+"/rest/api/1.0/", "/")},
+		},
+		Host:        endpoint.Host,
+		ContextPath: contextPath,
+		Project:     projectRepo[0],
+		Repository:  projectRepo[1],
+	}
+	repo := bitbucketRepository{}
+	err = b.do(ctx, http.MethodGet, b.repoPath(), nil, nil, &repo)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get Bitbucket repository")
+		return nil, err
+	}
+	if repo.Slug != "" && repo.Project.Key != "" {
+		b.Project = repo.Project.Key
+		b.Repository = repo.Slug
+	}
+	log.ForContext(ctx).Trace("initialized bitbucket client")
+	return b, nil
+}
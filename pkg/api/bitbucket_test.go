@@ -0,0 +1,366 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// fakeBitbucket is a minimal in-memory stand-in of the Bitbucket Data Center pull requests API
+type fakeBitbucket struct {
+	sync.Mutex
+	t             *testing.T
+	contextPath   string
+	project       string
+	repository    string
+	defaultBranch string
+	legacy        bool
+	pageSize      int
+	prs           []*bitbucketPullRequest
+	builds        map[string]map[string]int
+	comments      map[int][]string
+	strategies    map[int]string
+}
+
+func (f *fakeBitbucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	prefix := f.contextPath + "/rest/api/1.0/projects/" + f.project + "/repos/" + f.repository
+	if buildStats := f.contextPath + "/rest/build-status/1.0/commits/stats/"; strings.HasPrefix(r.URL.Path, buildStats) {
+		stats, ok := f.builds[strings.TrimPrefix(r.URL.Path, buildStats)]
+		if !ok {
+			stats = map[string]int{}
+		}
+		json.NewEncoder(w).Encode(stats)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		repo := bitbucketRepository{Slug: f.repository}
+		repo.Project.Key = f.project
+		json.NewEncoder(w).Encode(repo)
+	case path == "/default-branch" && !f.legacy, path == "/branches/default" && f.legacy:
+		json.NewEncoder(w).Encode(bitbucketRef{ID: "refs/heads/" + f.defaultBranch, DisplayID: f.defaultBranch})
+	case path == "/pull-requests" && r.Method == http.MethodGet:
+		assert.Contains(f.t, []string{"OUTGOING", "INCOMING"}, r.URL.Query().Get("direction"))
+		start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+		open := []bitbucketPullRequest{}
+		for i := len(f.prs) - 1; i >= 0; i-- {
+			// mimic the at parameter matching either side of the pull request
+			at := r.URL.Query().Get("at")
+			if state := r.URL.Query().Get("state"); (state == "ALL" || f.prs[i].State == state) && (f.prs[i].FromRef.ID == at || f.prs[i].ToRef.ID == at) {
+				open = append(open, *f.prs[i])
+			}
+		}
+		end := start + f.pageSize
+		if end > len(open) {
+			end = len(open)
+		}
+		json.NewEncoder(w).Encode(bitbucketPullRequestPage{Values: open[start:end], IsLastPage: end == len(open), NextPageStart: end})
+	case path == "/pull-requests" && r.Method == http.MethodPost:
+		in := bitbucketPullRequest{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+		assert.Equal(f.t, f.repository, in.FromRef.Repository.Slug)
+		assert.Equal(f.t, f.project, in.ToRef.Repository.Project.Key)
+		in.ID = len(f.prs) + 1
+		in.State = "OPEN"
+		in.Links.Self = append(in.Links.Self, struct {
+			Href string `json:"href"`
+		}{Href: fmt.Sprintf("https://bitbucket.example.com/projects/%s/repos/%s/pull-requests/%d", f.project, f.repository, in.ID)})
+		f.prs = append(f.prs, &in)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(in)
+	case strings.HasPrefix(path, "/pull-requests/") && strings.HasSuffix(path, "/comments") && r.Method == http.MethodPost:
+		id, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/pull-requests/"), "/comments"))
+		in := map[string]string{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+		if f.comments == nil {
+			f.comments = map[int][]string{}
+		}
+		f.comments[id] = append(f.comments[id], in["text"])
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(in)
+	case strings.HasPrefix(path, "/pull-requests/") && strings.HasSuffix(path, "/decline") && r.Method == http.MethodPost:
+		id, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/pull-requests/"), "/decline"))
+		pr := f.prs[id-1]
+		if r.URL.Query().Get("version") != strconv.Itoa(pr.Version) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		pr.Version++
+		pr.State = "DECLINED"
+		json.NewEncoder(w).Encode(pr)
+	case strings.HasPrefix(path, "/pull-requests/") && strings.HasSuffix(path, "/merge") && r.Method == http.MethodPost:
+		id, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/pull-requests/"), "/merge"))
+		pr := f.prs[id-1]
+		if r.URL.Query().Get("version") != strconv.Itoa(pr.Version) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		in := map[string]string{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+		if f.strategies == nil {
+			f.strategies = map[int]string{}
+		}
+		f.strategies[id] = in["strategyId"]
+		pr.Version++
+		pr.State = "MERGED"
+		json.NewEncoder(w).Encode(pr)
+	case strings.HasPrefix(path, "/pull-requests/"):
+		id, err := strconv.Atoi(strings.TrimPrefix(path, "/pull-requests/"))
+		if err != nil || id < 1 || id > len(f.prs) {
+			http.NotFound(w, r)
+			return
+		}
+		pr := f.prs[id-1]
+		if r.Method == http.MethodPut {
+			in := bitbucketPullRequest{}
+			require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+			if in.Version != pr.Version {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			pr.Version++
+			pr.Title = in.Title
+			pr.Description = in.Description
+			pr.Draft = in.Draft
+			pr.ToRef.ID = in.ToRef.ID
+		}
+		json.NewEncoder(w).Encode(pr)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestBitbucket(t *testing.T, fake *fakeBitbucket) *Bitbucket {
+	fake.t = t
+	if fake.pageSize == 0 {
+		fake.pageSize = 25
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL + fake.contextPath + "/rest/api/1.0/")
+	require.NoError(t, err)
+	return &Bitbucket{
+		restClient: restClient{Client: server.Client(), BaseURL: u},
+		Host:       "bitbucket.example.com",
+		Project:    fake.project,
+		Repository: fake.repository,
+	}
+}
+
+func TestBitbucketEnsure(t *testing.T) {
+	fake := &fakeBitbucket{project: "PROJ", repository: "repo", pageSize: 1}
+	b := newTestBitbucket(t, fake)
+
+	pr, created, err := b.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first change", Body: "body", WIP: true})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "1", pr.ID)
+	assert.Equal(t, "https://bitbucket.example.com/projects/PROJ/repos/repo/pull-requests/1", pr.URL)
+	assert.Equal(t, "refs/heads/maiao.I1", fake.prs[0].FromRef.ID)
+	assert.Equal(t, "refs/heads/main", fake.prs[0].ToRef.ID)
+	assert.True(t, fake.prs[0].Draft)
+
+	t.Run("stacked pull requests target the parent branch", func(t *testing.T) {
+		pr, created, err := b.Ensure(context.Background(), PullRequestOptions{Base: "maiao.I1", Head: "maiao.I2", Title: "second change"})
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.Equal(t, "2", pr.ID)
+		assert.Equal(t, "refs/heads/maiao.I1", fake.prs[1].ToRef.ID)
+		assert.False(t, fake.prs[1].Draft)
+	})
+
+	t.Run("existing pull requests are found by their source branch", func(t *testing.T) {
+		// the stacked pull request also matches maiao.I1 as its target, and is listed first
+		pr, created, err := b.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first change"})
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, "1", pr.ID)
+		assert.Len(t, fake.prs, 2)
+	})
+}
+
+func TestBitbucketFind(t *testing.T) {
+	fake := &fakeBitbucket{
+		contextPath: "/bitbucket",
+		project:     "PROJ",
+		repository:  "repo",
+		builds:      map[string]map[string]int{"abc123": {"successful": 2, "inProgress": 1}},
+	}
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"id": 1, "state": "DECLINED", "fromRef": {"id": "refs/heads/maiao.I1"}, "toRef": {"id": "refs/heads/main"}},
+		{"id": 2, "state": "OPEN", "draft": true, "fromRef": {"id": "refs/heads/maiao.I1", "latestCommit": "abc123"}, "toRef": {"id": "refs/heads/main"},
+		 "reviewers": [{"status": "APPROVED"}, {"status": "UNAPPROVED"}],
+		 "links": {"self": [{"href": "https://bitbucket.example.com/projects/PROJ/repos/repo/pull-requests/2"}]}},
+		{"id": 3, "state": "MERGED", "fromRef": {"id": "refs/heads/maiao.I2", "latestCommit": "def456"}, "toRef": {"id": "refs/heads/maiao.I1"},
+		 "reviewers": [{"status": "NEEDS_WORK"}, {"status": "APPROVED"}]}
+	]`), &fake.prs))
+	b := newTestBitbucket(t, fake)
+
+	status, err := b.Find(context.Background(), "maiao.I1")
+	require.NoError(t, err)
+	assert.Equal(t, &PullRequestStatus{
+		PullRequest:    PullRequest{ID: "2", URL: "https://bitbucket.example.com/projects/PROJ/repos/repo/pull-requests/2"},
+		Base:           "main",
+		Head:           "maiao.I1",
+		State:          PullRequestStateDraft,
+		ReviewDecision: ReviewDecisionApproved,
+		Checks:         ChecksPending,
+	}, status)
+
+	status, err = b.Find(context.Background(), "maiao.I2")
+	require.NoError(t, err)
+	assert.Equal(t, PullRequestStateMerged, status.State)
+	assert.Equal(t, ReviewDecisionChangesRequested, status.ReviewDecision)
+	assert.Empty(t, status.Checks)
+
+	status, err = b.Find(context.Background(), "maiao.I3")
+	require.NoError(t, err)
+	assert.Nil(t, status)
+}
+
+func TestBitbucketUpdate(t *testing.T) {
+	fake := &fakeBitbucket{project: "PROJ", repository: "repo"}
+	b := newTestBitbucket(t, fake)
+
+	pr, _, err := b.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "change", WIP: true})
+	require.NoError(t, err)
+
+	t.Run("draft status is preserved when the pull request is not marked ready", func(t *testing.T) {
+		_, err := b.Update(context.Background(), pr, PullRequestOptions{Base: "maiao.I0", Head: "maiao.I1", Title: "new title", Body: "new body"})
+		require.NoError(t, err)
+		assert.Equal(t, "new title", fake.prs[0].Title)
+		assert.Equal(t, "new body", fake.prs[0].Description)
+		assert.Equal(t, "refs/heads/maiao.I0", fake.prs[0].ToRef.ID)
+		assert.True(t, fake.prs[0].Draft)
+	})
+
+	t.Run("draft status is removed when the pull request is marked ready", func(t *testing.T) {
+		updated, err := b.Update(context.Background(), pr, PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "new title", Ready: true})
+		require.NoError(t, err)
+		assert.Equal(t, "1", updated.ID)
+		assert.False(t, fake.prs[0].Draft)
+		assert.Equal(t, 2, fake.prs[0].Version)
+	})
+}
+
+func TestBitbucketManagePullRequests(t *testing.T) {
+	fake := &fakeBitbucket{project: "PROJ", repository: "repo"}
+	b := newTestBitbucket(t, fake)
+	_, _, err := b.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first change"})
+	require.NoError(t, err)
+	_, _, err = b.Ensure(context.Background(), PullRequestOptions{Base: "maiao.I1", Head: "maiao.I2", Title: "second change", Body: "body", WIP: true})
+	require.NoError(t, err)
+
+	status, err := b.Get(context.Background(), "1")
+	require.NoError(t, err)
+	assert.Equal(t, "maiao.I1", status.Head)
+	assert.Equal(t, "main", status.Base)
+
+	children, err := b.ListOpen(context.Background(), "maiao.I1")
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	assert.Equal(t, "2", children[0].ID)
+
+	require.NoError(t, b.Retarget(context.Background(), &children[0].PullRequest, "main"))
+	assert.Equal(t, "refs/heads/main", fake.prs[1].ToRef.ID)
+	assert.Equal(t, "second change", fake.prs[1].Title)
+	assert.Equal(t, "body", fake.prs[1].Description)
+	assert.True(t, fake.prs[1].Draft)
+
+	require.NoError(t, b.Close(context.Background(), &status.PullRequest, "superseded"))
+	assert.Equal(t, "DECLINED", fake.prs[0].State)
+	assert.Equal(t, []string{"superseded"}, fake.comments[1])
+
+	children, err = b.ListOpen(context.Background(), "main")
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	assert.Equal(t, "2", children[0].ID)
+
+	require.NoError(t, b.Merge(context.Background(), &children[0].PullRequest, MergeMethodRebase))
+	assert.Equal(t, "MERGED", fake.prs[1].State)
+	assert.Equal(t, "rebase-no-ff", fake.strategies[2])
+	assert.Error(t, b.Merge(context.Background(), &children[0].PullRequest, "octopus"))
+}
+
+func TestBitbucketDefaultBranch(t *testing.T) {
+	t.Run("with recent Bitbucket versions", func(t *testing.T) {
+		b := newTestBitbucket(t, &fakeBitbucket{project: "PROJ", repository: "repo", defaultBranch: "main"})
+		assert.Equal(t, "main", b.DefaultBranch(context.Background()))
+	})
+	t.Run("with legacy Bitbucket versions", func(t *testing.T) {
+		b := newTestBitbucket(t, &fakeBitbucket{project: "PROJ", repository: "repo", defaultBranch: "master", legacy: true})
+		assert.Equal(t, "master", b.DefaultBranch(context.Background()))
+	})
+	t.Run("when the repository is not found", func(t *testing.T) {
+		b := newTestBitbucket(t, &fakeBitbucket{project: "PROJ", repository: "repo"})
+		b.Repository = "missing"
+		assert.Equal(t, "", b.DefaultBranch(context.Background()))
+	})
+}
+
+func TestBitbucketLinkedTopicIssues(t *testing.T) {
+	assert.Equal(t, "https://bitbucket.example.com/dashboard", (&Bitbucket{Host: "bitbucket.example.com"}).LinkedTopicIssues("topic-sha"))
+	assert.Equal(t, "https://bitbucket.example.com/bitbucket/dashboard", (&Bitbucket{Host: "bitbucket.example.com", ContextPath: "bitbucket"}).LinkedTopicIssues("topic-sha"))
+}
+
+func TestSplitBitbucketPath(t *testing.T) {
+	for path, expected := range map[string][2]string{
+		"/scm/PROJ/repo.git":           {"", "PROJ/repo"},
+		"/bitbucket/scm/PROJ/repo.git": {"bitbucket", "PROJ/repo"},
+		"/PROJ/repo.git":               {"", "PROJ/repo"},
+		"/bitbucket/scm/repo.git":      {"", "bitbucket/scm/repo"},
+	} {
+		contextPath, repository := splitBitbucketPath(path)
+		assert.Equal(t, expected, [2]string{contextPath, repository}, path)
+	}
+}
+
+func TestNewBitbucketUpserter(t *testing.T) {
+	defer tempEnv("BITBUCKET_TOKEN", "some-token")()
+	fake := &fakeBitbucket{contextPath: "/bitbucket", project: "PROJ", repository: "repo", t: t}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer some-token", r.Header.Get("Authorization"))
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "https://")
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, server.Client())
+
+	t.Run("when the repository is not <project>/<repo>, constructor fails", func(t *testing.T) {
+		b, err := NewBitbucketUpserter(ctx, &transport.Endpoint{Host: host, Path: "/bitbucket/scm/repo.git"})
+		assert.Error(t, err)
+		assert.Nil(t, b)
+	})
+	t.Run("when the repository does not exist, constructor fails", func(t *testing.T) {
+		b, err := NewBitbucketUpserter(ctx, &transport.Endpoint{Host: host, Path: "/bitbucket/scm/PROJ/missing.git"})
+		assert.Error(t, err)
+		assert.Nil(t, b)
+	})
+	t.Run("when using an scm http remote, the context path is used", func(t *testing.T) {
+		b, err := NewBitbucketUpserter(ctx, &transport.Endpoint{Host: host, Path: "/bitbucket/scm/PROJ/repo.git"})
+		require.NoError(t, err)
+		assert.Equal(t, "PROJ", b.Project)
+		assert.Equal(t, "repo", b.Repository)
+		assert.Equal(t, "bitbucket", b.ContextPath)
+		assert.Equal(t, "https://"+host+"/bitbucket/rest/api/1.0/", b.BaseURL.String())
+	})
+}
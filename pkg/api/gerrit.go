@@ -0,0 +1,303 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/adevinta/maiao/pkg/credentials"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// gerritXSSIPrefix is the prefix of all Gerrit REST API JSON responses
+	gerritXSSIPrefix = ")]}'"
+	// gerritBranchPrefix is the prefix of the branches created by maiao for each change
+	gerritBranchPrefix = "maiao."
+)
+
+// Gerrit implements the PullRequester and ChangePusher interfaces for Gerrit Code Review
+type Gerrit struct {
+	restClient
+	Host    string
+	Project string
+}
+
+type gerritChange struct {
+	Number         int    `json:"_number"`
+	ChangeID       string `json:"change_id"`
+	Project        string `json:"project"`
+	Branch         string `json:"branch"`
+	Subject        string `json:"subject"`
+	Status         string `json:"status"`
+	Topic          string `json:"topic"`
+	WorkInProgress bool   `json:"work_in_progress"`
+	// Labels are only returned when querying with the LABELS option
+	Labels map[string]gerritLabel `json:"labels,omitempty"`
+}
+
+type gerritLabel struct {
+	Approved *struct{} `json:"approved,omitempty"`
+	Rejected *struct{} `json:"rejected,omitempty"`
+}
+
+func (g *Gerrit) pullRequest(c *gerritChange) *PullRequest {
+	u := url.URL{Scheme: "https", Host: g.Host, Path: fmt.Sprintf("/c/%s/+/%d", c.Project, c.Number)}
+	if g.BaseURL != nil {
+		u.Path = strings.TrimSuffix(strings.TrimSuffix(g.BaseURL.Path, "/"), "/a") + u.Path
+	}
+	return &PullRequest{
+		ID:  strconv.Itoa(c.Number),
+		URL: u.String(),
+	}
+}
+
+// PushRef returns the refs/for/<branch> ref, with the push options matching options
+func (g *Gerrit) PushRef(options PushOptions) string {
+	pushOptions := []string{}
+	if options.Topic != "" {
+		pushOptions = append(pushOptions, "topic="+url.QueryEscape(options.Topic))
+	}
+	if options.WIP {
+		pushOptions = append(pushOptions, "wip")
+	}
+	if options.Ready {
+		pushOptions = append(pushOptions, "ready")
+	}
+	ref := "refs/for/" + options.Branch
+	if len(pushOptions) > 0 {
+		ref += "%" + strings.Join(pushOptions, ",")
+	}
+	return ref
+}
+
+// FindChange returns the change matching changeID on branch, or nil if it does not exist
+func (g *Gerrit) FindChange(ctx context.Context, changeID, branch string) (*PullRequest, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":  "finding gerrit change",
+		"project":  g.Project,
+		"changeID": changeID,
+		"branch":   branch,
+	})
+	changes := []gerritChange{}
+	err := g.do(ctx, http.MethodGet, "changes/", url.Values{
+		"q": {fmt.Sprintf("change:%s project:%s branch:%s", changeID, g.Project, branch)},
+	}, nil, &changes)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to query changes")
+		return nil, err
+	}
+	switch len(changes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return g.pullRequest(&changes[0]), nil
+	}
+	return nil, errors.New("Too many matching changes")
+}
+
+// Find returns the status of the most recently updated change pushed for the head branch, or nil when none exists.
+// The Code-Review label provides the review decision and the Verified label the checks state
+func (g *Gerrit) Find(ctx context.Context, head string) (*PullRequestStatus, error) {
+	_, branch := splitHead(head)
+	return g.queryStatus(ctx, strings.TrimPrefix(branch, gerritBranchPrefix))
+}
+
+// queryStatus returns the status of the most recently updated change matching id, either a Change-Id or a change number
+func (g *Gerrit) queryStatus(ctx context.Context, id string) (*PullRequestStatus, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":  "finding gerrit change",
+		"project":  g.Project,
+		"changeID": id,
+	})
+	changes := []gerritChange{}
+	err := g.do(ctx, http.MethodGet, "changes/", url.Values{
+		"q": {fmt.Sprintf("change:%s project:%s", id, g.Project)},
+		"o": {"LABELS"},
+	}, nil, &changes)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to query changes")
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	change := changes[0]
+	status := &PullRequestStatus{
+		PullRequest: *g.pullRequest(&change),
+		Base:        change.Branch,
+		Head:        gerritBranchPrefix + change.ChangeID,
+		Title:       change.Subject,
+		State:       PullRequestStateOpen,
+	}
+	switch {
+	case change.Status == "MERGED":
+		status.State = PullRequestStateMerged
+	case change.Status == "ABANDONED":
+		status.State = PullRequestStateClosed
+	case change.WorkInProgress:
+		status.State = PullRequestStateDraft
+	}
+	if label, ok := change.Labels["Code-Review"]; ok {
+		switch {
+		case label.Rejected != nil:
+			status.ReviewDecision = ReviewDecisionChangesRequested
+		case label.Approved != nil:
+			status.ReviewDecision = ReviewDecisionApproved
+		default:
+			status.ReviewDecision = ReviewDecisionRequired
+		}
+	}
+	if label, ok := change.Labels["Verified"]; ok {
+		switch {
+		case label.Rejected != nil:
+			status.Checks = ChecksFailure
+		case label.Approved != nil:
+			status.Checks = ChecksSuccess
+		default:
+			status.Checks = ChecksPending
+		}
+	}
+	return status, nil
+}
+
+// Get returns the status of the change identified by its number
+func (g *Gerrit) Get(ctx context.Context, id string) (*PullRequestStatus, error) {
+	status, err := g.queryStatus(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, fmt.Errorf("change %s not found", id)
+	}
+	return status, nil
+}
+
+// ListOpen returns no pull request.
+// Gerrit changes all target the branch they are pushed for, no change is stacked on top of another change branch
+func (g *Gerrit) ListOpen(ctx context.Context, base string) ([]*PullRequestStatus, error) {
+	return []*PullRequestStatus{}, nil
+}
+
+// Close abandons the change, with comment as the abandon message
+func (g *Gerrit) Close(ctx context.Context, pr *PullRequest, comment string) error {
+	input := map[string]string{}
+	if comment != "" {
+		input["message"] = comment
+	}
+	err := g.do(ctx, http.MethodPost, "changes/"+pr.ID+"/abandon", nil, input, nil)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", pr.ID).Error("failed to abandon change")
+		return err
+	}
+	return nil
+}
+
+// Retarget implements the PullRequestManager interface.
+// Gerrit changes are stacked through their parent commit, there is no base to change
+func (g *Gerrit) Retarget(ctx context.Context, pr *PullRequest, base string) error {
+	return nil
+}
+
+// Merge submits the change.
+// Gerrit merges changes with the submit type configured for the project, method is ignored
+func (g *Gerrit) Merge(ctx context.Context, pr *PullRequest, method string) error {
+	err := g.do(ctx, http.MethodPost, "changes/"+pr.ID+"/submit", nil, map[string]string{}, nil)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", pr.ID).Error("failed to submit change")
+		return err
+	}
+	return nil
+}
+
+// Ensure ensures the change pushed for the head branch exists.
+// Gerrit changes are created when pushing to refs/for/<branch> and can't be created through the API
+func (g *Gerrit) Ensure(ctx context.Context, options PullRequestOptions) (*PullRequest, bool, error) {
+	changeID := strings.TrimPrefix(options.Head, gerritBranchPrefix)
+	pr, err := g.FindChange(ctx, changeID, options.Base)
+	if err != nil {
+		return nil, false, err
+	}
+	if pr == nil {
+		return nil, false, fmt.Errorf("change %s not found, it must be pushed to %s first", changeID, g.PushRef(PushOptions{Branch: options.Base}))
+	}
+	return pr, false, nil
+}
+
+// Update implements the Update interface.
+// Gerrit changes are updated by pushing new patch sets, there is nothing left to update
+func (g *Gerrit) Update(ctx context.Context, pr *PullRequest, options PullRequestOptions) (*PullRequest, error) {
+	return pr, nil
+}
+
+// DefaultBranch returns the branch HEAD of the project points to
+func (g *Gerrit) DefaultBranch(ctx context.Context) string {
+	head := ""
+	err := g.do(ctx, http.MethodGet, "projects/"+url.PathEscape(g.Project)+"/HEAD", nil, nil, &head)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(head, "refs/heads/")
+}
+
+// LinkedTopicIssues returns the search URL for changes mentioning the topic
+func (g *Gerrit) LinkedTopicIssues(topicSearchString string) string {
+	return `https://` + g.Host + `/q/` + url.PathEscape(fmt.Sprintf(`status:open message:"%s"`, topicSearchString))
+}
+
+// NewGerritUpserter instanciates a client for Gerrit Code Review
+//
+// Credentials (username and HTTP password) are taken from the default credential store.
+// When none are found, the REST API is accessed anonymously
+func NewGerritUpserter(ctx context.Context, endpoint *transport.Endpoint) (*Gerrit, error) {
+	return newGerritUpserter(ctx, endpoint, nil)
+}
+
+// newGerritUpserter instanciates the Gerrit client, authenticating with getter, the default credential store when nil
+func newGerritUpserter(ctx context.Context, endpoint *transport.Endpoint, getter credentials.CredentialGetter) (*Gerrit, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":  "initializing Gerrit client",
+		"endpoint": endpoint,
+	})
+	project := strings.TrimSuffix(strings.Trim(endpoint.Path, "/"), ".git")
+	if strings.HasPrefix(endpoint.Protocol, "http") {
+		// authenticated http remotes are exposed under the /a/ prefix
+		project = strings.TrimPrefix(project, "a/")
+	}
+	if project == "" {
+		log.ForContext(ctx).WithField("repository", endpoint.Path).Error("invalid repository, expecting a project name")
+		return nil, fmt.Errorf("invalid repository, expecting a project name")
+	}
+	g := &Gerrit{
+		restClient: restClient{
+			BaseURL:        &url.URL{Scheme: "https", Host: endpoint.Host, Path: "/"},
+			ResponsePrefix: gerritXSSIPrefix,
+		},
+		Host:    endpoint.Host,
+		Project: project,
+	}
+	if client := newBasicAuthHTTPClient(ctx, getter, endpoint.Host); client != nil {
+		g.Client = client
+		g.BaseURL.Path = "/a/"
+	}
+	version := ""
+	err := g.do(ctx, http.MethodGet, "config/server/version", nil, nil, &version)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get Gerrit version")
+		return nil, err
+	}
+	log.ForContext(ctx).WithField("version", version).Debug("found Gerrit server")
+	err = g.do(ctx, http.MethodGet, "projects/"+url.PathEscape(project), nil, nil, &struct{}{})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get Gerrit project")
+		return nil, err
+	}
+	log.ForContext(ctx).Trace("initialized gerrit client")
+	return g, nil
+}
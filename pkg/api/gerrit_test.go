@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/adevinta/maiao/pkg/credentials"
+	gh "github.com/adevinta/maiao/pkg/github"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGerrit is a minimal stand-in of the Gerrit REST API
+type fakeGerrit struct {
+	t       *testing.T
+	project string
+	head    string
+	changes []gerritChange
+	// abandoned records the abandon messages by change number
+	abandoned map[string]string
+	submitted []string
+}
+
+func (f *fakeGerrit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/a")
+	respond := func(v interface{}) {
+		w.Write([]byte(gerritXSSIPrefix + "\n"))
+		json.NewEncoder(w).Encode(v)
+	}
+	switch path {
+	case "/config/server/version":
+		respond("3.9.1")
+	case "/projects/" + url.PathEscape(f.project):
+		respond(map[string]string{"name": f.project})
+	case "/projects/" + url.PathEscape(f.project) + "/HEAD":
+		respond(f.head)
+	case "/changes/":
+		matching := []gerritChange{}
+		for _, c := range f.changes {
+			q := r.URL.Query().Get("q")
+			if q == fmt.Sprintf("change:%s project:%s branch:%s", c.ChangeID, c.Project, c.Branch) || q == fmt.Sprintf("change:%s project:%s", c.ChangeID, c.Project) || q == fmt.Sprintf("change:%d project:%s", c.Number, c.Project) {
+				matching = append(matching, c)
+			}
+		}
+		respond(matching)
+	case "/changes/1234/abandon":
+		in := map[string]string{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+		f.abandoned["1234"] = in["message"]
+		respond(map[string]string{"status": "ABANDONED"})
+	case "/changes/1234/submit":
+		f.submitted = append(f.submitted, "1234")
+		respond(map[string]string{"status": "MERGED"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestGerrit(t *testing.T, fake *fakeGerrit) *Gerrit {
+	fake.t = t
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL + "/a/")
+	require.NoError(t, err)
+	return &Gerrit{
+		restClient: restClient{Client: server.Client(), BaseURL: u, ResponsePrefix: gerritXSSIPrefix},
+		Host:       "gerrit.example.com",
+		Project:    fake.project,
+	}
+}
+
+func TestGerritPushRef(t *testing.T) {
+	g := &Gerrit{}
+	assert.Equal(t, "refs/for/main", g.PushRef(PushOptions{Branch: "main"}))
+	assert.Equal(t, "refs/for/main%topic=my+topic,wip", g.PushRef(PushOptions{Branch: "main", Topic: "my topic", WIP: true}))
+	assert.Equal(t, "refs/for/release/1.0%ready", g.PushRef(PushOptions{Branch: "release/1.0", Ready: true}))
+}
+
+func TestGerritFindChange(t *testing.T) {
+	g := newTestGerrit(t, &fakeGerrit{
+		project: "team/project",
+		changes: []gerritChange{
+			{Number: 1234, ChangeID: "I1", Project: "team/project", Branch: "main"},
+			{Number: 1235, ChangeID: "I1", Project: "team/project", Branch: "release"},
+		},
+	})
+
+	pr, err := g.FindChange(context.Background(), "I1", "main")
+	require.NoError(t, err)
+	require.NotNil(t, pr)
+	assert.Equal(t, "1234", pr.ID)
+	assert.Equal(t, "https://gerrit.example.com/c/team/project/+/1234", pr.URL)
+
+	pr, err = g.FindChange(context.Background(), "I2", "main")
+	require.NoError(t, err)
+	assert.Nil(t, pr)
+
+	t.Run("Ensure returns the pushed change", func(t *testing.T) {
+		pr, created, err := g.Ensure(context.Background(), PullRequestOptions{Base: "release", Head: "maiao.I1"})
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, "1235", pr.ID)
+	})
+	t.Run("Ensure fails when the change was not pushed", func(t *testing.T) {
+		pr, _, err := g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I2"})
+		assert.Error(t, err)
+		assert.Nil(t, pr)
+	})
+}
+
+func TestGerritFind(t *testing.T) {
+	fake := &fakeGerrit{project: "team/project"}
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"_number": 1234, "change_id": "I1", "project": "team/project", "branch": "main", "status": "NEW", "work_in_progress": true,
+		 "labels": {"Code-Review": {"approved": {}}, "Verified": {"rejected": {}}}},
+		{"_number": 1235, "change_id": "I2", "project": "team/project", "branch": "main", "status": "MERGED",
+		 "labels": {"Code-Review": {}}},
+		{"_number": 1236, "change_id": "I3", "project": "team/project", "branch": "main", "status": "ABANDONED"}
+	]`), &fake.changes))
+	g := newTestGerrit(t, fake)
+
+	status, err := g.Find(context.Background(), "maiao.I1")
+	require.NoError(t, err)
+	assert.Equal(t, &PullRequestStatus{
+		PullRequest:    PullRequest{ID: "1234", URL: "https://gerrit.example.com/c/team/project/+/1234"},
+		Base:           "main",
+		Head:           "maiao.I1",
+		State:          PullRequestStateDraft,
+		ReviewDecision: ReviewDecisionApproved,
+		Checks:         ChecksFailure,
+	}, status)
+
+	status, err = g.Find(context.Background(), "maiao.I2")
+	require.NoError(t, err)
+	assert.Equal(t, PullRequestStateMerged, status.State)
+	assert.Equal(t, ReviewDecisionRequired, status.ReviewDecision)
+	assert.Empty(t, status.Checks)
+
+	status, err = g.Find(context.Background(), "maiao.I3")
+	require.NoError(t, err)
+	assert.Equal(t, PullRequestStateClosed, status.State)
+	assert.Empty(t, status.ReviewDecision)
+
+	status, err = g.Find(context.Background(), "maiao.I4")
+	require.NoError(t, err)
+	assert.Nil(t, status)
+}
+
+func TestGerritDefaultBranch(t *testing.T) {
+	g := newTestGerrit(t, &fakeGerrit{project: "team/project", head: "refs/heads/develop"})
+	assert.Equal(t, "develop", g.DefaultBranch(context.Background()))
+}
+
+func TestGerritLinkedTopicIssues(t *testing.T) {
+	g := Gerrit{Host: "gerrit.example.com"}
+	assert.Equal(t, `https://gerrit.example.com/q/status:open%20message:%22topic-sha%22`, g.LinkedTopicIssues("topic-sha"))
+}
+
+func TestNewGerritUpserter(t *testing.T) {
+	originalTransport := http.DefaultTransport
+	t.Cleanup(func() {
+		http.DefaultTransport = originalTransport
+	})
+	defer setDefaultCredentials(gh.DefaultCredentialGetter)
+
+	fake := &fakeGerrit{project: "team/project", t: t}
+	authenticated := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, ok := r.BasicAuth()
+		assert.Equal(t, authenticated, ok)
+		assert.Equal(t, authenticated, strings.HasPrefix(r.URL.Path, "/a/"))
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	http.DefaultTransport = server.Client().Transport
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	t.Run("without credentials, the API is accessed anonymously", func(t *testing.T) {
+		setDefaultCredentials(fakeCredentials{fail: true})
+		authenticated = false
+		g, err := NewGerritUpserter(context.Background(), &transport.Endpoint{Protocol: "ssh", Host: host, Port: 29418, Path: "/team/project"})
+		require.NoError(t, err)
+		assert.Equal(t, "team/project", g.Project)
+	})
+	t.Run("with credentials, the authenticated API is used", func(t *testing.T) {
+		setDefaultCredentials(fakeCredentials{c: credentials.Credentials{Username: "user", Password: "http-password"}})
+		authenticated = true
+		g, err := NewGerritUpserter(context.Background(), &transport.Endpoint{Protocol: "https", Host: host, Path: "/a/team/project.git"})
+		require.NoError(t, err)
+		assert.Equal(t, "team/project", g.Project)
+		assert.Equal(t, "/a/", g.BaseURL.Path)
+	})
+	t.Run("when the project does not exist, constructor fails", func(t *testing.T) {
+		setDefaultCredentials(fakeCredentials{fail: true})
+		authenticated = false
+		g, err := NewGerritUpserter(context.Background(), &transport.Endpoint{Protocol: "ssh", Host: host, Path: "/missing"})
+		assert.Error(t, err)
+		assert.Nil(t, g)
+	})
+}
+
+func TestGerritManagePullRequests(t *testing.T) {
+	fake := &fakeGerrit{
+		project:   "team/project",
+		abandoned: map[string]string{},
+		changes: []gerritChange{
+			{Number: 1234, ChangeID: "I1", Project: "team/project", Branch: "main", Status: "NEW"},
+		},
+	}
+	g := newTestGerrit(t, fake)
+
+	status, err := g.Get(context.Background(), "1234")
+	require.NoError(t, err)
+	assert.Equal(t, "maiao.I1", status.Head)
+	assert.Equal(t, "main", status.Base)
+	assert.Equal(t, PullRequestStateOpen, status.State)
+
+	_, err = g.Get(context.Background(), "42")
+	assert.Error(t, err)
+
+	children, err := g.ListOpen(context.Background(), "maiao.I1")
+	require.NoError(t, err)
+	assert.Empty(t, children)
+	assert.NoError(t, g.Retarget(context.Background(), &status.PullRequest, "main"))
+
+	require.NoError(t, g.Close(context.Background(), &status.PullRequest, "superseded"))
+	assert.Equal(t, map[string]string{"1234": "superseded"}, fake.abandoned)
+
+	require.NoError(t, g.Merge(context.Background(), &status.PullRequest, MergeMethodSquash))
+	assert.Equal(t, []string{"1234"}, fake.submitted)
+}
@@ -0,0 +1,429 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/adevinta/maiao/pkg/credentials"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	giteaWIPPrefix = "WIP: "
+	giteaPageSize  = 50
+)
+
+// Gitea implements the PullRequester interface for Gitea and Forgejo instances
+type Gitea struct {
+	restClient
+	Host       string
+	Owner      string
+	Repository string
+}
+
+type giteaBranch struct {
+	Ref  string `json:"ref"`
+	Sha  string `json:"sha,omitempty"`
+	Repo *struct {
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repo,omitempty"`
+}
+
+type giteaPullRequest struct {
+	Number  int         `json:"number"`
+	HTMLURL string      `json:"html_url"`
+	Title   string      `json:"title"`
+	Body    string      `json:"body"`
+	State   string      `json:"state"`
+	Merged  bool        `json:"merged"`
+	Head    giteaBranch `json:"head"`
+	Base    giteaBranch `json:"base"`
+}
+
+func (pr *giteaPullRequest) pullRequest() *PullRequest {
+	return &PullRequest{
+		ID:  strconv.Itoa(pr.Number),
+		URL: pr.HTMLURL,
+	}
+}
+
+// SupportsForks implements the ForkSupporter interface, pull requests being opened from <owner>:<branch> heads
+func (g *Gitea) SupportsForks() bool {
+	return true
+}
+
+func (g *Gitea) repoPath(elements ...string) string {
+	return strings.Join(append([]string{"repos", url.PathEscape(g.Owner), url.PathEscape(g.Repository)}, elements...), "/")
+}
+
+// pullRequests lists all pull requests in state (open, closed or all) matching, the most recent first.
+// The Gitea API does not allow to filter on branches, go through all pull requests instead.
+func (g *Gitea) pullRequests(ctx context.Context, state string, match func(giteaPullRequest) bool) ([]giteaPullRequest, error) {
+	matching := []giteaPullRequest{}
+	for page := 1; ; page++ {
+		prs := []giteaPullRequest{}
+		err := g.do(ctx, http.MethodGet, g.repoPath("pulls"), url.Values{
+			"state": {state},
+			"sort":  {"newest"},
+			"page":  {strconv.Itoa(page)},
+			"limit": {strconv.Itoa(giteaPageSize)},
+		}, nil, &prs)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range prs {
+			if match(pr) {
+				matching = append(matching, pr)
+			}
+		}
+		if len(prs) < giteaPageSize {
+			return matching, nil
+		}
+	}
+}
+
+// headPullRequests lists all pull requests in state having head as source branch, head being <owner>:<branch> for forks
+func (g *Gitea) headPullRequests(ctx context.Context, head, state string) ([]giteaPullRequest, error) {
+	owner, branch := splitHead(head)
+	return g.pullRequests(ctx, state, func(pr giteaPullRequest) bool {
+		return pr.Head.Ref == branch && (owner == "" || (pr.Head.Repo != nil && pr.Head.Repo.Owner.Login == owner))
+	})
+}
+
+// Ensure ensures a PR is opened for the head branch
+func (g *Gitea) Ensure(ctx context.Context, options PullRequestOptions) (*PullRequest, bool, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "ensuring existing pull request",
+		"owner":      g.Owner,
+		"repository": g.Repository,
+		"prOptions":  options,
+	})
+	prs, err := g.headPullRequests(ctx, options.Head, "open")
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to list existing pull requests")
+		return nil, false, err
+	}
+	switch len(prs) {
+	case 0:
+		title := options.Title
+		if options.WIP {
+			log.ForContext(ctx).Info("adding draft marker")
+			title = giteaWIPTitle(title)
+		}
+		pr := giteaPullRequest{}
+		err := g.do(ctx, http.MethodPost, g.repoPath("pulls"), nil, map[string]interface{}{
+			"head":  options.Head,
+			"base":  options.Base,
+			"title": title,
+			"body":  options.Body,
+		}, &pr)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to create new pull request")
+			return nil, false, err
+		}
+		log.ForContext(ctx).Debug("new PR has been created")
+		return pr.pullRequest(), true, nil
+	case 1:
+		log.ForContext(ctx).Trace("PR already existed")
+		return prs[0].pullRequest(), false, nil
+	}
+	log.ForContext(ctx).Error("too many matching pull requests")
+	return nil, false, errors.New("Too many matching pull requests")
+}
+
+// Update implements the Update interface to update an existing pull request
+func (g *Gitea) Update(ctx context.Context, pr *PullRequest, options PullRequestOptions) (*PullRequest, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "updating existing pull request",
+		"owner":      g.Owner,
+		"repository": g.Repository,
+		"prOptions":  options,
+		"prID":       pr.ID,
+	})
+	current := giteaPullRequest{}
+	err := g.do(ctx, http.MethodGet, g.repoPath("pulls", pr.ID), nil, nil, &current)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get pull request")
+		return nil, err
+	}
+	title := options.Title
+	// Gitea and Forgejo consider pull requests with a WIP prefix in the title as drafts.
+	// Keep it unless the pull request is explicitly marked as ready.
+	if isGiteaWIPTitle(current.Title) && !options.Ready {
+		title = giteaWIPTitle(title)
+	}
+	if options.Ready {
+		log.ForContext(ctx).Info("marking pull request as ready")
+	}
+	updated := giteaPullRequest{}
+	err = g.do(ctx, http.MethodPatch, g.repoPath("pulls", pr.ID), nil, map[string]interface{}{
+		"base":  options.Base,
+		"title": title,
+		"body":  options.Body,
+	}, &updated)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to edit pull request")
+		return nil, err
+	}
+	log.ForContext(ctx).Info("edit pull request")
+	return updated.pullRequest(), nil
+}
+
+// Find returns the status of the most recent pull request opened for head, or nil when none exists
+func (g *Gitea) Find(ctx context.Context, head string) (*PullRequestStatus, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "finding pull request",
+		"owner":      g.Owner,
+		"repository": g.Repository,
+		"head":       head,
+	})
+	prs, err := g.headPullRequests(ctx, head, "all")
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to list pull requests")
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	return g.reviewStatus(ctx, &prs[0]), nil
+}
+
+func (pr *giteaPullRequest) status() *PullRequestStatus {
+	status := &PullRequestStatus{
+		PullRequest: *pr.pullRequest(),
+		Base:        pr.Base.Ref,
+		Head:        pr.Head.Ref,
+		Title:       pr.Title,
+		State:       PullRequestStateOpen,
+		Body:        pr.Body,
+	}
+	switch {
+	case pr.Merged:
+		status.State = PullRequestStateMerged
+	case pr.State == "closed":
+		status.State = PullRequestStateClosed
+	case isGiteaWIPTitle(pr.Title):
+		status.State = PullRequestStateDraft
+	}
+	return status
+}
+
+// reviewStatus returns the status of the pull request, including its review decision and checks
+func (g *Gitea) reviewStatus(ctx context.Context, pr *giteaPullRequest) *PullRequestStatus {
+	status := pr.status()
+	reviews := []struct {
+		State     string `json:"state"`
+		Dismissed bool   `json:"dismissed"`
+		Stale     bool   `json:"stale"`
+	}{}
+	err := g.do(ctx, http.MethodGet, g.repoPath("pulls", status.ID, "reviews"), nil, nil, &reviews)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Warn("failed to get pull request reviews")
+	} else {
+		status.ReviewDecision = ReviewDecisionRequired
+		for _, review := range reviews {
+			if review.Dismissed || review.Stale {
+				continue
+			}
+			switch review.State {
+			case "REQUEST_CHANGES":
+				status.ReviewDecision = ReviewDecisionChangesRequested
+			case "APPROVED":
+				if status.ReviewDecision != ReviewDecisionChangesRequested {
+					status.ReviewDecision = ReviewDecisionApproved
+				}
+			}
+		}
+	}
+
+	if pr.Head.Sha != "" {
+		combined := struct {
+			State      string `json:"state"`
+			TotalCount int    `json:"total_count"`
+		}{}
+		err = g.do(ctx, http.MethodGet, g.repoPath("commits", pr.Head.Sha, "status"), nil, nil, &combined)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Warn("failed to get pull request checks")
+		} else if combined.TotalCount > 0 {
+			status.Checks = giteaChecks(combined.State)
+		}
+	}
+	return status
+}
+
+// Get returns the status of the pull request identified by id
+func (g *Gitea) Get(ctx context.Context, id string) (*PullRequestStatus, error) {
+	pr := giteaPullRequest{}
+	err := g.do(ctx, http.MethodGet, g.repoPath("pulls", id), nil, nil, &pr)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", id).Error("failed to get pull request")
+		return nil, err
+	}
+	return g.reviewStatus(ctx, &pr), nil
+}
+
+// ListOpen returns the open pull requests targeting the base branch
+func (g *Gitea) ListOpen(ctx context.Context, base string) ([]*PullRequestStatus, error) {
+	prs, err := g.pullRequests(ctx, "open", func(pr giteaPullRequest) bool {
+		return pr.Base.Ref == base
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("base", base).Error("failed to list pull requests")
+		return nil, err
+	}
+	statuses := []*PullRequestStatus{}
+	for i := range prs {
+		statuses = append(statuses, prs[i].status())
+	}
+	return statuses, nil
+}
+
+// Close closes the pull request, commenting it first when comment is not empty
+func (g *Gitea) Close(ctx context.Context, pr *PullRequest, comment string) error {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "closing pull request",
+		"owner":      g.Owner,
+		"repository": g.Repository,
+		"prID":       pr.ID,
+	})
+	if comment != "" {
+		// pull requests share their comments with issues
+		err := g.do(ctx, http.MethodPost, g.repoPath("issues", pr.ID, "comments"), nil, map[string]string{"body": comment}, nil)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to comment pull request")
+			return err
+		}
+	}
+	err := g.do(ctx, http.MethodPatch, g.repoPath("pulls", pr.ID), nil, map[string]string{"state": "closed"}, nil)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to close pull request")
+		return err
+	}
+	return nil
+}
+
+// Retarget changes the base branch of the pull request
+func (g *Gitea) Retarget(ctx context.Context, pr *PullRequest, base string) error {
+	err := g.do(ctx, http.MethodPatch, g.repoPath("pulls", pr.ID), nil, map[string]string{"base": base}, nil)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", pr.ID).WithField("base", base).Error("failed to change pull request base")
+		return err
+	}
+	return nil
+}
+
+// Merge merges the pull request into its base branch
+func (g *Gitea) Merge(ctx context.Context, pr *PullRequest, method string) error {
+	err := g.do(ctx, http.MethodPost, g.repoPath("pulls", pr.ID, "merge"), nil, map[string]string{"Do": method}, nil)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", pr.ID).WithField("method", method).Error("failed to merge pull request")
+		return err
+	}
+	return nil
+}
+
+func giteaChecks(state string) string {
+	switch state {
+	case "success", "warning":
+		return ChecksSuccess
+	case "failure", "error":
+		return ChecksFailure
+	case "pending":
+		return ChecksPending
+	}
+	return ""
+}
+
+// DefaultBranch returns the default branch of the remote repository
+func (g *Gitea) DefaultBranch(ctx context.Context) string {
+	repo := struct {
+		DefaultBranch string `json:"default_branch"`
+	}{}
+	err := g.do(ctx, http.MethodGet, g.repoPath(), nil, nil, &repo)
+	if err != nil {
+		return ""
+	}
+	return repo.DefaultBranch
+}
+
+// LinkedTopicIssues returns the search URL for linked pull requests
+func (g *Gitea) LinkedTopicIssues(topicSearchString string) string {
+	values := url.Values{}
+	values.Add("type", "your_repositories")
+	values.Add("state", "open")
+	values.Add("q", topicSearchString)
+	return `https://` + g.Host + `/pulls?` + values.Encode()
+}
+
+func isGiteaWIPTitle(title string) bool {
+	upper := strings.ToUpper(title)
+	return strings.HasPrefix(upper, "WIP:") || strings.HasPrefix(upper, "[WIP]")
+}
+
+func giteaWIPTitle(title string) string {
+	if isGiteaWIPTitle(title) {
+		return title
+	}
+	return giteaWIPPrefix + title
+}
+
+// NewGiteaUpserter instanciates an upserter that uses the Gitea (or Forgejo) API to create and update pull requests
+//
+// Credentials are taken from the GITEA_TOKEN environment variable or from
+// the default credential store
+func NewGiteaUpserter(ctx context.Context, endpoint *transport.Endpoint) (*Gitea, error) {
+	return newGiteaUpserter(ctx, endpoint, nil)
+}
+
+// newGiteaUpserter instanciates the Gitea client, authenticating with getter, the default credential store when nil
+func newGiteaUpserter(ctx context.Context, endpoint *transport.Endpoint, getter credentials.CredentialGetter) (*Gitea, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":  "initializing Gitea client",
+		"endpoint": endpoint,
+	})
+	orgRepo := strings.Split(strings.Trim(endpoint.Path, "/"), "/")
+	if len(orgRepo) != 2 {
+		log.ForContext(ctx).WithField("repository", endpoint.Path).Error("invalid repository, expecting <owner>/<repo>")
+		return nil, fmt.Errorf("invalid repository, expecting <owner>/<repo>")
+	}
+	httpClient, err := newTokenHTTPClient(ctx, getter, endpoint.Host, "GITEA_TOKEN")
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Errorf("failed to create a new http client: %s", err.Error())
+		return nil, err
+	}
+	g := &Gitea{
+		restClient: restClient{
+			Client:  httpClient,
+			BaseURL: &url.URL{Scheme: "https", Host: endpoint.Host, Path: "/api/v1/"},
+		},
+		Host:       endpoint.Host,
+		Owner:      orgRepo[0],
+		Repository: strings.TrimSuffix(orgRepo[1], ".git"),
+	}
+	repo := struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	}{}
+	err = g.do(ctx, http.MethodGet, g.repoPath(), nil, nil, &repo)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get Gitea repository")
+		return nil, err
+	}
+	if repo.Owner.Login != "" && repo.Name != "" {
+		g.Owner = repo.Owner.Login
+		g.Repository = repo.Name
+	}
+	log.ForContext(ctx).Trace("initialized gitea client")
+	return g, nil
+}
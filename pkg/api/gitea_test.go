@@ -0,0 +1,384 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// fakeGitea is a minimal in-memory stand-in of the Gitea/Forgejo pull requests API
+type fakeGitea struct {
+	sync.Mutex
+	t             *testing.T
+	owner         string
+	repository    string
+	defaultBranch string
+	prs           []*giteaPullRequest
+	reviews       map[int][]string
+	statuses      map[string]string
+	comments      map[int][]string
+	mergedWith    map[int]string
+}
+
+func (f *fakeGitea) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	prefix := "/api/v1/repos/" + f.owner + "/" + f.repository
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":           f.repository,
+			"owner":          map[string]string{"login": f.owner},
+			"default_branch": f.defaultBranch,
+		})
+	case path == "/pulls" && r.Method == http.MethodGet:
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		open := []*giteaPullRequest{}
+		for i := len(f.prs) - 1; i >= 0; i-- {
+			if state := r.URL.Query().Get("state"); state == "all" || f.prs[i].State == state {
+				open = append(open, f.prs[i])
+			}
+		}
+		start := (page - 1) * limit
+		end := start + limit
+		if start > len(open) {
+			start = len(open)
+		}
+		if end > len(open) {
+			end = len(open)
+		}
+		json.NewEncoder(w).Encode(open[start:end])
+	case path == "/pulls" && r.Method == http.MethodPost:
+		in := map[string]string{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+		pr := &giteaPullRequest{
+			Number: len(f.prs) + 1,
+			Title:  in["title"],
+			Body:   in["body"],
+			Head:   giteaBranch{Ref: in["head"]},
+			Base:   giteaBranch{Ref: in["base"]},
+			State:  "open",
+		}
+		// mimic cross repository pull requests, opened from <owner>:<branch>
+		owner, branch := splitHead(in["head"])
+		if owner == "" {
+			owner = f.owner
+		}
+		pr.Head.Ref = branch
+		pr.Head.Repo = &struct {
+			Owner struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+		}{}
+		pr.Head.Repo.Owner.Login = owner
+		pr.HTMLURL = fmt.Sprintf("https://gitea.example.com/%s/%s/pulls/%d", f.owner, f.repository, pr.Number)
+		f.prs = append(f.prs, pr)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(pr)
+	case strings.HasPrefix(path, "/pulls/") && strings.HasSuffix(path, "/reviews"):
+		number, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/pulls/"), "/reviews"))
+		reviews := []map[string]string{}
+		for _, state := range f.reviews[number] {
+			reviews = append(reviews, map[string]string{"state": state})
+		}
+		json.NewEncoder(w).Encode(reviews)
+	case strings.HasPrefix(path, "/issues/") && strings.HasSuffix(path, "/comments") && r.Method == http.MethodPost:
+		number, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/issues/"), "/comments"))
+		in := map[string]string{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+		if f.comments == nil {
+			f.comments = map[int][]string{}
+		}
+		f.comments[number] = append(f.comments[number], in["body"])
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(in)
+	case strings.HasPrefix(path, "/pulls/") && strings.HasSuffix(path, "/merge") && r.Method == http.MethodPost:
+		number, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/pulls/"), "/merge"))
+		in := map[string]string{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+		if f.mergedWith == nil {
+			f.mergedWith = map[int]string{}
+		}
+		f.mergedWith[number] = in["Do"]
+		f.prs[number-1].State = "closed"
+		f.prs[number-1].Merged = true
+	case strings.HasPrefix(path, "/commits/") && strings.HasSuffix(path, "/status"):
+		state, ok := f.statuses[strings.TrimSuffix(strings.TrimPrefix(path, "/commits/"), "/status")]
+		if !ok {
+			json.NewEncoder(w).Encode(map[string]interface{}{"state": "", "total_count": 0})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"state": state, "total_count": 1})
+	case strings.HasPrefix(path, "/pulls/"):
+		number, err := strconv.Atoi(strings.TrimPrefix(path, "/pulls/"))
+		if err != nil || number < 1 || number > len(f.prs) {
+			http.NotFound(w, r)
+			return
+		}
+		pr := f.prs[number-1]
+		if r.Method == http.MethodPatch {
+			in := map[string]string{}
+			require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+			if title, ok := in["title"]; ok {
+				pr.Title = title
+			}
+			if body, ok := in["body"]; ok {
+				pr.Body = body
+			}
+			if base, ok := in["base"]; ok {
+				pr.Base.Ref = base
+			}
+			if state, ok := in["state"]; ok {
+				pr.State = state
+			}
+		}
+		json.NewEncoder(w).Encode(pr)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestGitea(t *testing.T, fake *fakeGitea) *Gitea {
+	fake.t = t
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL + "/api/v1/")
+	require.NoError(t, err)
+	return &Gitea{
+		restClient: restClient{Client: server.Client(), BaseURL: u},
+		Host:       "gitea.example.com",
+		Owner:      fake.owner,
+		Repository: fake.repository,
+	}
+}
+
+func TestGiteaEnsure(t *testing.T) {
+	fake := &fakeGitea{owner: "owner", repository: "repo"}
+	g := newTestGitea(t, fake)
+
+	pr, created, err := g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first change", Body: "body", WIP: true})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "1", pr.ID)
+	assert.Equal(t, "https://gitea.example.com/owner/repo/pulls/1", pr.URL)
+	assert.Equal(t, "WIP: first change", fake.prs[0].Title)
+
+	pr, created, err = g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first change"})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "1", pr.ID)
+
+	t.Run("existing pull requests are found beyond the first page", func(t *testing.T) {
+		for i := 0; i < giteaPageSize+1; i++ {
+			_, _, err := g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: fmt.Sprintf("other-%d", i)})
+			require.NoError(t, err)
+		}
+		pr, created, err := g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first change"})
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, "1", pr.ID)
+	})
+}
+
+func TestGiteaEnsureFromFork(t *testing.T) {
+	fake := &fakeGitea{owner: "owner", repository: "repo"}
+	g := newTestGitea(t, fake)
+
+	_, created, err := g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "upstream change"})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	pr, created, err := g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "contributor:maiao.I1", Title: "fork change"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "2", pr.ID)
+	assert.Equal(t, "contributor", fake.prs[1].Head.Repo.Owner.Login)
+
+	pr, created, err = g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "contributor:maiao.I1", Title: "fork change"})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "2", pr.ID)
+}
+
+func TestGiteaFind(t *testing.T) {
+	fake := &fakeGitea{
+		owner:      "owner",
+		repository: "repo",
+		reviews:    map[int][]string{2: {"APPROVED", "REQUEST_CHANGES"}, 3: {"COMMENT", "APPROVED"}},
+		statuses:   map[string]string{"abc123": "failure"},
+	}
+	g := newTestGitea(t, fake)
+	_, _, err := g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "closed change"})
+	require.NoError(t, err)
+	fake.prs[0].State = "closed"
+	_, _, err = g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first change", WIP: true})
+	require.NoError(t, err)
+	fake.prs[1].Head.Sha = "abc123"
+	_, _, err = g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I2", Title: "second change"})
+	require.NoError(t, err)
+	fake.prs[2].State = "closed"
+	fake.prs[2].Merged = true
+
+	status, err := g.Find(context.Background(), "maiao.I1")
+	require.NoError(t, err)
+	assert.Equal(t, &PullRequestStatus{
+		PullRequest:    PullRequest{ID: "2", URL: "https://gitea.example.com/owner/repo/pulls/2"},
+		Base:           "main",
+		Head:           "maiao.I1",
+		Title:          "WIP: first change",
+		State:          PullRequestStateDraft,
+		ReviewDecision: ReviewDecisionChangesRequested,
+		Checks:         ChecksFailure,
+	}, status)
+
+	status, err = g.Find(context.Background(), "maiao.I2")
+	require.NoError(t, err)
+	assert.Equal(t, PullRequestStateMerged, status.State)
+	assert.Equal(t, ReviewDecisionApproved, status.ReviewDecision)
+	assert.Empty(t, status.Checks)
+
+	status, err = g.Find(context.Background(), "other:maiao.I2")
+	require.NoError(t, err)
+	assert.Nil(t, status)
+}
+
+func TestGiteaManagePullRequests(t *testing.T) {
+	fake := &fakeGitea{owner: "owner", repository: "repo"}
+	g := newTestGitea(t, fake)
+	_, _, err := g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first change"})
+	require.NoError(t, err)
+	_, _, err = g.Ensure(context.Background(), PullRequestOptions{Base: "maiao.I1", Head: "maiao.I2", Title: "second change"})
+	require.NoError(t, err)
+	_, _, err = g.Ensure(context.Background(), PullRequestOptions{Base: "maiao.I1", Head: "maiao.I3", Title: "third change"})
+	require.NoError(t, err)
+	fake.prs[2].State = "closed"
+
+	status, err := g.Get(context.Background(), "1")
+	require.NoError(t, err)
+	assert.Equal(t, "maiao.I1", status.Head)
+	assert.Equal(t, PullRequestStateOpen, status.State)
+
+	children, err := g.ListOpen(context.Background(), "maiao.I1")
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	assert.Equal(t, "2", children[0].ID)
+
+	require.NoError(t, g.Retarget(context.Background(), &children[0].PullRequest, "main"))
+	assert.Equal(t, "main", fake.prs[1].Base.Ref)
+	assert.Equal(t, "second change", fake.prs[1].Title)
+
+	require.NoError(t, g.Close(context.Background(), &status.PullRequest, "superseded"))
+	assert.Equal(t, "closed", fake.prs[0].State)
+	assert.Equal(t, []string{"superseded"}, fake.comments[1])
+
+	require.NoError(t, g.Merge(context.Background(), &children[0].PullRequest, MergeMethodSquash))
+	assert.Equal(t, "squash", fake.mergedWith[2])
+	status, err = g.Get(context.Background(), "2")
+	require.NoError(t, err)
+	assert.Equal(t, PullRequestStateMerged, status.State)
+
+	_, err = g.Get(context.Background(), "42")
+	assert.True(t, isNotFound(err))
+}
+
+func TestGiteaUpdate(t *testing.T) {
+	fake := &fakeGitea{owner: "owner", repository: "repo"}
+	g := newTestGitea(t, fake)
+
+	pr, _, err := g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "change", WIP: true})
+	require.NoError(t, err)
+
+	t.Run("WIP prefix is preserved when the pull request is not marked ready", func(t *testing.T) {
+		_, err := g.Update(context.Background(), pr, PullRequestOptions{Base: "maiao.I0", Head: "maiao.I1", Title: "new title", Body: "new body"})
+		require.NoError(t, err)
+		assert.Equal(t, "WIP: new title", fake.prs[0].Title)
+		assert.Equal(t, "new body", fake.prs[0].Body)
+		assert.Equal(t, "maiao.I0", fake.prs[0].Base.Ref)
+	})
+
+	t.Run("WIP prefix is removed when the pull request is marked ready", func(t *testing.T) {
+		updated, err := g.Update(context.Background(), pr, PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "new title", Ready: true})
+		require.NoError(t, err)
+		assert.Equal(t, "1", updated.ID)
+		assert.Equal(t, "new title", fake.prs[0].Title)
+		assert.Equal(t, "main", fake.prs[0].Base.Ref)
+	})
+
+	t.Run("updating a missing pull request fails", func(t *testing.T) {
+		_, err := g.Update(context.Background(), &PullRequest{ID: "42"}, PullRequestOptions{Base: "main", Head: "maiao.I1"})
+		assert.Error(t, err)
+	})
+}
+
+func TestGiteaDefaultBranch(t *testing.T) {
+	g := newTestGitea(t, &fakeGitea{owner: "owner", repository: "repo", defaultBranch: "trunk"})
+	assert.Equal(t, "trunk", g.DefaultBranch(context.Background()))
+
+	g.Repository = "missing"
+	assert.Equal(t, "", g.DefaultBranch(context.Background()))
+}
+
+func TestGiteaLinkedTopicIssues(t *testing.T) {
+	g := Gitea{Host: "gitea.example.com"}
+	assert.Equal(
+		t,
+		"https://gitea.example.com/pulls?q=topic-sha&state=open&type=your_repositories",
+		g.LinkedTopicIssues("topic-sha"),
+	)
+}
+
+func TestIsGiteaWIPTitle(t *testing.T) {
+	assert.True(t, isGiteaWIPTitle("WIP: change"))
+	assert.True(t, isGiteaWIPTitle("wip: change"))
+	assert.True(t, isGiteaWIPTitle("[WIP] change"))
+	assert.False(t, isGiteaWIPTitle("change WIP:"))
+	assert.Equal(t, "[WIP] change", giteaWIPTitle("[WIP] change"))
+}
+
+func TestNewGiteaUpserter(t *testing.T) {
+	defer tempEnv("GITEA_TOKEN", "some-token")()
+	fake := &fakeGitea{owner: "Owner", repository: "Repo", t: t}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer some-token", r.Header.Get("Authorization"))
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "https://")
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, server.Client())
+
+	t.Run("when the repository is not <owner>/<repo>, constructor fails", func(t *testing.T) {
+		g, err := NewGiteaUpserter(ctx, &transport.Endpoint{Host: host, Path: "/group/sub/repo.git"})
+		assert.Error(t, err)
+		assert.Nil(t, g)
+	})
+	t.Run("when the repository does not exist, constructor fails", func(t *testing.T) {
+		g, err := NewGiteaUpserter(ctx, &transport.Endpoint{Host: host, Path: "/Owner/missing.git"})
+		assert.Error(t, err)
+		assert.Nil(t, g)
+	})
+	t.Run("when the repository exists, the client is configured", func(t *testing.T) {
+		g, err := NewGiteaUpserter(ctx, &transport.Endpoint{Host: host, Path: "/Owner/Repo.git"})
+		require.NoError(t, err)
+		assert.Equal(t, "Owner", g.Owner)
+		assert.Equal(t, "Repo", g.Repository)
+		assert.Equal(t, "https://"+host+"/api/v1/", g.BaseURL.String())
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/adevinta/maiao/pkg/credentials"
 	gh "github.com/adevinta/maiao/pkg/github"
 	"github.com/adevinta/maiao/pkg/log"
 	"github.com/cli/go-gh/v2/pkg/api"
@@ -24,6 +25,8 @@ type GitHub struct {
 	Host       string
 	Owner      string
 	Repository string
+	// WebHost is the host serving the GitHub web interface, when it differs from Host
+	WebHost string
 }
 
 // RepoName implements the ghrepo.Interface interface required to call the github graphql API from https://github.com/cli/cli
@@ -52,8 +55,12 @@ func (g *GitHub) Ensure(ctx context.Context, options PullRequestOptions) (*PullR
 		"repository": g.Repository,
 		"prOptions":  options,
 	})
+	head := options.Head
+	if owner, _ := splitHead(head); owner == "" {
+		head = g.Owner + ":" + head
+	}
 	prs, _, err := g.PullRequests.List(context.Background(), g.Owner, g.Repository, &github.PullRequestListOptions{
-		Head:      g.Owner + ":" + options.Head,
+		Head:      head,
 		Sort:      "created",
 		Direction: "desc",
 	})
@@ -91,7 +98,7 @@ func (g *GitHub) Ensure(ctx context.Context, options PullRequestOptions) (*PullR
 		}, false, nil
 	}
 	log.ForContext(ctx).WithError(err).Error("failed to list existing pull requests")
-	return nil, false, errors.New("Too may matching pull requests")
+	return nil, false, errors.New("Too many matching pull requests")
 
 }
 
@@ -153,12 +160,324 @@ func (g *GitHub) Update(ctx context.Context, pr *PullRequest, options PullReques
 			return nil, err
 		}
 	}
+	if options.AutoMerge != "" {
+		if p.GetDraft() && !options.Ready {
+			log.ForContext(ctx).Debug("draft pull requests can't be merged automatically")
+		} else {
+			err = g.enableAutoMerge(ctx, p, options.AutoMerge)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 	return &PullRequest{
 		ID:  strconv.Itoa(*p.Number),
 		URL: *p.URL,
 	}, err
 }
 
+// SupportsForks implements the ForkSupporter interface, pull requests being opened from <owner>:<branch> heads
+func (g *GitHub) SupportsForks() bool {
+	return true
+}
+
+// SupportsAutoMerge implements the AutoMerger interface, auto-merge and merge queues being available on GitHub
+func (g *GitHub) SupportsAutoMerge() bool {
+	return true
+}
+
+// enableAutoMerge merges the pull request once its requirements are met, GitHub adding it to the merge queue
+// when the base branch requires one. Pull requests that can already be merged are added to the merge queue
+// right away instead, GitHub refusing to enable auto-merge on them
+func (g *GitHub) enableAutoMerge(ctx context.Context, p *github.PullRequest, method string) error {
+	ctx = log.WithContextFields(ctx, logrus.Fields{"autoMerge": method, "base": p.GetBase().GetRef()})
+	var state struct {
+		Repository struct {
+			PullRequest struct {
+				MergeStateStatus githubv4.String
+			} `graphql:"pullRequest(number: $number)"`
+		} `graphql:"repository(owner: $owner, name: $name)"`
+	}
+	err := g.GraphQLClient.Query("MergeStateStatus", &state, map[string]interface{}{
+		"owner":  githubv4.String(g.Owner),
+		"name":   githubv4.String(g.Repository),
+		"number": githubv4.Int(p.GetNumber()),
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get the merge state of the pull request")
+		return err
+	}
+	if state.Repository.PullRequest.MergeStateStatus == "CLEAN" {
+		return g.enqueuePullRequest(ctx, p)
+	}
+
+	log.ForContext(ctx).Info("enabling pull request auto-merge")
+	mergeMethod := githubv4.PullRequestMergeMethod(strings.ToUpper(method))
+	var mutation struct {
+		EnablePullRequestAutoMerge struct {
+			PullRequest struct {
+				ID githubv4.ID
+			}
+		} `graphql:"enablePullRequestAutoMerge(input: $input)"`
+	}
+	err = g.GraphQLClient.Mutate("EnablePullRequestAutoMerge", &mutation, map[string]interface{}{
+		"input": githubv4.EnablePullRequestAutoMergeInput{
+			PullRequestID: p.GetNodeID(),
+			MergeMethod:   &mergeMethod,
+		},
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to enable pull request auto-merge")
+		return err
+	}
+	return nil
+}
+
+// enqueuePullRequest adds the pull request, that can already be merged, to the merge queue of its base branch.
+// Without merge queue, the pull request is left for the user to merge
+func (g *GitHub) enqueuePullRequest(ctx context.Context, p *github.PullRequest) error {
+	var query struct {
+		Repository struct {
+			MergeQueue *struct {
+				ID githubv4.ID
+			} `graphql:"mergeQueue(branch: $branch)"`
+		} `graphql:"repository(owner: $owner, name: $name)"`
+	}
+	err := g.GraphQLClient.Query("MergeQueue", &query, map[string]interface{}{
+		"owner":  githubv4.String(g.Owner),
+		"name":   githubv4.String(g.Repository),
+		"branch": githubv4.String(p.GetBase().GetRef()),
+	})
+	if err != nil {
+		// older GitHub Enterprise versions do not know about merge queues
+		log.ForContext(ctx).WithError(err).Debug("failed to find the merge queue of the base branch")
+	}
+	if err != nil || query.Repository.MergeQueue == nil {
+		log.ForContext(ctx).Warn("pull request is already mergeable, auto-merge not enabled")
+		return nil
+	}
+	log.ForContext(ctx).Info("adding pull request to the merge queue")
+	var mutation struct {
+		EnqueuePullRequest struct {
+			MergeQueueEntry struct {
+				ID githubv4.ID
+			}
+		} `graphql:"enqueuePullRequest(input: $input)"`
+	}
+	err = g.GraphQLClient.Mutate("EnqueuePullRequest", &mutation, map[string]interface{}{
+		"input": githubv4.EnqueuePullRequestInput{PullRequestID: p.GetNodeID()},
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to add pull request to the merge queue")
+		return err
+	}
+	return nil
+}
+
+// Find returns the status of the most recent pull request opened for head, or nil when none exists
+func (g *GitHub) Find(ctx context.Context, head string) (*PullRequestStatus, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "finding pull request",
+		"owner":      g.Owner,
+		"repository": g.Repository,
+		"head":       head,
+	})
+	if owner, _ := splitHead(head); owner == "" {
+		head = g.Owner + ":" + head
+	}
+	prs, _, err := g.PullRequests.List(ctx, g.Owner, g.Repository, &github.PullRequestListOptions{
+		Head:      head,
+		State:     "all",
+		Sort:      "created",
+		Direction: "desc",
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to list pull requests")
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	status := gitHubStatus(prs[0])
+	g.reviewStatus(ctx, prs[0].GetNumber(), status)
+	return status, nil
+}
+
+func gitHubStatus(pr *github.PullRequest) *PullRequestStatus {
+	status := &PullRequestStatus{
+		PullRequest: PullRequest{
+			ID:  strconv.Itoa(pr.GetNumber()),
+			URL: pr.GetHTMLURL(),
+		},
+		Base:  pr.GetBase().GetRef(),
+		Head:  pr.GetHead().GetRef(),
+		Title: pr.GetTitle(),
+		State: PullRequestStateOpen,
+		Body:  pr.GetBody(),
+	}
+	switch {
+	case pr.MergedAt != nil:
+		status.State = PullRequestStateMerged
+	case pr.GetState() == "closed":
+		status.State = PullRequestStateClosed
+	case pr.GetDraft():
+		status.State = PullRequestStateDraft
+	}
+	return status
+}
+
+// reviewStatus completes status with the review decision and the checks of the pull request
+func (g *GitHub) reviewStatus(ctx context.Context, number int, status *PullRequestStatus) {
+	if g.GraphQLClient == nil {
+		return
+	}
+
+	// The review decision and the checks rollup are only exposed through the graphQL API
+	var query struct {
+		Repository struct {
+			PullRequest struct {
+				ReviewDecision string
+				Commits        struct {
+					Nodes []struct {
+						Commit struct {
+							StatusCheckRollup *struct {
+								State string
+							}
+						}
+					}
+				} `graphql:"commits(last: 1)"`
+			} `graphql:"pullRequest(number: $number)"`
+		} `graphql:"repository(owner: $owner, name: $name)"`
+	}
+	variables := map[string]interface{}{
+		"owner":  githubv4.String(g.Owner),
+		"name":   githubv4.String(g.Repository),
+		"number": githubv4.Int(number),
+	}
+	err := g.GraphQLClient.Query("PullRequestStatus", &query, variables)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Warn("failed to get pull request review decision and checks")
+		return
+	}
+	status.ReviewDecision = strings.ToLower(query.Repository.PullRequest.ReviewDecision)
+	if nodes := query.Repository.PullRequest.Commits.Nodes; len(nodes) > 0 && nodes[0].Commit.StatusCheckRollup != nil {
+		status.Checks = gitHubChecks(nodes[0].Commit.StatusCheckRollup.State)
+	}
+}
+
+// Get returns the status of the pull request identified by id
+func (g *GitHub) Get(ctx context.Context, id string) (*PullRequestStatus, error) {
+	number, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pull request number %s: %w", id, err)
+	}
+	pr, _, err := g.PullRequests.Get(ctx, g.Owner, g.Repository, number)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", id).Error("failed to get pull request")
+		return nil, err
+	}
+	status := gitHubStatus(pr)
+	g.reviewStatus(ctx, number, status)
+	return status, nil
+}
+
+// ListOpen returns the open pull requests targeting the base branch
+func (g *GitHub) ListOpen(ctx context.Context, base string) ([]*PullRequestStatus, error) {
+	statuses := []*PullRequestStatus{}
+	options := &github.PullRequestListOptions{
+		State:       "open",
+		Base:        base,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		prs, resp, err := g.PullRequests.List(ctx, g.Owner, g.Repository, options)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).WithField("base", base).Error("failed to list pull requests")
+			return nil, err
+		}
+		for _, pr := range prs {
+			statuses = append(statuses, gitHubStatus(pr))
+		}
+		if resp == nil || resp.NextPage == 0 {
+			return statuses, nil
+		}
+		options.Page = resp.NextPage
+	}
+}
+
+// Close closes the pull request, commenting it first when comment is not empty
+func (g *GitHub) Close(ctx context.Context, pr *PullRequest, comment string) error {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":    "closing pull request",
+		"owner":      g.Owner,
+		"repository": g.Repository,
+		"prID":       pr.ID,
+	})
+	number, err := strconv.Atoi(pr.ID)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to parse pull request ID")
+		return err
+	}
+	if comment != "" {
+		_, _, err = g.Issues.CreateComment(ctx, g.Owner, g.Repository, number, &github.IssueComment{Body: github.String(comment)})
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to comment pull request")
+			return err
+		}
+	}
+	_, _, err = g.PullRequests.Edit(ctx, g.Owner, g.Repository, number, &github.PullRequest{State: github.String("closed")})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to close pull request")
+		return err
+	}
+	return nil
+}
+
+// Retarget changes the base branch of the pull request
+func (g *GitHub) Retarget(ctx context.Context, pr *PullRequest, base string) error {
+	number, err := strconv.Atoi(pr.ID)
+	if err != nil {
+		return err
+	}
+	_, _, err = g.PullRequests.Edit(ctx, g.Owner, g.Repository, number, &github.PullRequest{
+		Base: &github.PullRequestBranch{Ref: github.String(base)},
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", pr.ID).WithField("base", base).Error("failed to change pull request base")
+		return err
+	}
+	return nil
+}
+
+// Merge merges the pull request into its base branch
+func (g *GitHub) Merge(ctx context.Context, pr *PullRequest, method string) error {
+	number, err := strconv.Atoi(pr.ID)
+	if err != nil {
+		return err
+	}
+	result, _, err := g.PullRequests.Merge(ctx, g.Owner, g.Repository, number, "", &github.PullRequestOptions{MergeMethod: method})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", pr.ID).WithField("method", method).Error("failed to merge pull request")
+		return err
+	}
+	if !result.GetMerged() {
+		return fmt.Errorf("pull request %s was not merged: %s", pr.URL, result.GetMessage())
+	}
+	return nil
+}
+
+func gitHubChecks(state string) string {
+	switch state {
+	case "SUCCESS":
+		return ChecksSuccess
+	case "FAILURE", "ERROR":
+		return ChecksFailure
+	case "PENDING", "EXPECTED":
+		return ChecksPending
+	}
+	return ""
+}
+
 // DefaultBranch returns the default branch of the remote repository
 func (g *GitHub) DefaultBranch(ctx context.Context) string {
 	repo, _, err := g.Repositories.Get(ctx, g.Owner, g.Repository)
@@ -174,11 +493,36 @@ func (g *GitHub) LinkedTopicIssues(topicSearchString string) string {
 	values.Add("q", fmt.Sprintf(`is:pr is:open "%s"`, topicSearchString))
 	values.Add("type", "issues")
 	values.Encode()
-	return `https://` + g.Host + `/search?` + values.Encode()
+	host := g.Host
+	if g.WebHost != "" {
+		host = g.WebHost
+	}
+	return `https://` + host + `/search?` + values.Encode()
+}
+
+// gitHubAPIEndpoints returns the API URLs configured in maiao.<host>.apiURL and maiao.<host>.graphqlURL, if any.
+// The URLs configured for the ssh host alias of the remote take precedence over the ones of host
+func gitHubAPIEndpoints(options BackendOptions, host string) gh.APIEndpoints {
+	for _, h := range configuredHosts(options, host) {
+		endpoints := gh.APIEndpoints{
+			RESTURL:    HostOption(options.Config, h, "apiURL"),
+			GraphQLURL: HostOption(options.Config, h, "graphqlURL"),
+		}
+		if endpoints.RESTURL != "" || endpoints.GraphQLURL != "" {
+			return endpoints
+		}
+	}
+	return gh.APIEndpoints{}
 }
 
 // NewGitHubUpserter instanciates an upserter that uses the github API to create and update pull requests
 func NewGitHubUpserter(ctx context.Context, endpoint *transport.Endpoint) (*GitHub, error) {
+	return newGitHubUpserter(ctx, endpoint, gh.APIEndpoints{}, nil)
+}
+
+// newGitHubUpserter instanciates the GitHub client, sending requests to apiEndpoints when configured
+// and authenticating with getter, the default credential store when nil
+func newGitHubUpserter(ctx context.Context, endpoint *transport.Endpoint, apiEndpoints gh.APIEndpoints, getter credentials.CredentialGetter) (*GitHub, error) {
 	ctx = log.WithContextFields(ctx, logrus.Fields{
 		"context":  "initializing GitHub client",
 		"endpoint": endpoint,
@@ -189,12 +533,12 @@ func NewGitHubUpserter(ctx context.Context, endpoint *transport.Endpoint) (*GitH
 		log.ForContext(ctx).WithField("repository", endpoint.Path).Error("invalid repository, expecting <org>/<repo>")
 		return nil, fmt.Errorf("invalid repository, expecting <org>/<repo>")
 	}
-	httpClient, err := gh.NewHTTPClientForDomain(ctx, endpoint.Host)
+	httpClient, err := gh.NewHTTPClientForEndpoints(ctx, endpoint.Host, apiEndpoints, getter)
 	if err != nil {
 		log.ForContext(ctx).WithError(err).Errorf("failed to create a new http client: %s", err.Error())
 		return nil, err
 	}
-	client, err := gh.NewClient(httpClient, endpoint.Host)
+	client, err := gh.NewClientForEndpoints(httpClient, endpoint.Host, apiEndpoints)
 	if err != nil {
 		log.ForContext(ctx).WithError(err).Errorf("failed to create a new github client: %s", err.Error())
 		return nil, err
@@ -204,7 +548,7 @@ func NewGitHubUpserter(ctx context.Context, endpoint *transport.Endpoint) (*GitH
 		return nil, err
 	}
 
-	graphQLClient, err := gh.NewGraphQLClient(httpClient, endpoint.Host)
+	graphQLClient, err := gh.NewGraphQLClientForEndpoints(httpClient, endpoint.Host, apiEndpoints)
 	if err != nil {
 		log.ForContext(ctx).WithError(err).Errorf("failed to create a new github graphQL client: %s", err.Error())
 		return nil, err
@@ -217,6 +561,10 @@ func NewGitHubUpserter(ctx context.Context, endpoint *transport.Endpoint) (*GitH
 		Client:        client,
 		GraphQLClient: graphQLClient,
 	}
+	// the remote host may be an alias, rely on the repository URL to link to the web interface
+	if htmlURL, err := url.Parse(repo.GetHTMLURL()); err == nil && htmlURL.Host != "" && htmlURL.Host != endpoint.Host {
+		gh.WebHost = htmlURL.Host
+	}
 	log.ForContext(ctx).Trace("initialized github client")
 	return gh, nil
 }
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -17,6 +18,7 @@ import (
 	"github.com/adevinta/maiao/pkg/credentials"
 	gh "github.com/adevinta/maiao/pkg/github"
 	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/google/go-github/v55/github"
 	"github.com/google/uuid"
@@ -146,6 +148,219 @@ func TestEnsureReturnsExisingPR(t *testing.T) {
 	assert.Equal(t, "99491", pr.ID)
 }
 
+func TestGitHubFind(t *testing.T) {
+	httpClient := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		switch r.URL.Path {
+		case "/repos/test-owner/test-repository/pulls":
+			assert.Equal(t, "all", r.URL.Query().Get("state"))
+			body := `[]`
+			switch r.URL.Query().Get("head") {
+			case "test-owner:maiao.I1":
+				body = `[
+					{"number": 2, "html_url": "https://github.com/test-owner/test-repository/pull/2", "state": "open", "draft": true, "base": {"ref": "main"}, "head": {"ref": "maiao.I1"}},
+					{"number": 1, "html_url": "https://github.com/test-owner/test-repository/pull/1", "state": "closed", "base": {"ref": "main"}, "head": {"ref": "maiao.I1"}}
+				]`
+			case "contributor:maiao.I2":
+				body = `[{"number": 3, "state": "closed", "merged_at": "2024-01-01T00:00:00Z", "base": {"ref": "main"}, "head": {"ref": "maiao.I2"}}]`
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{"Content-Type": {"application/json"}}}, nil
+		case "/graphql":
+			query := struct {
+				Variables map[string]interface{} `json:"variables"`
+			}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+			body := `{"data": {"repository": {"pullRequest": {"reviewDecision": "", "commits": {"nodes": [{"commit": {"statusCheckRollup": null}}]}}}}}`
+			if query.Variables["number"] == float64(2) {
+				body = `{"data": {"repository": {"pullRequest": {"reviewDecision": "CHANGES_REQUESTED", "commits": {"nodes": [{"commit": {"statusCheckRollup": {"state": "SUCCESS"}}}]}}}}}`
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{"Content-Type": {"application/json"}}}, nil
+		}
+		t.Errorf("unexpected request %s", r.URL.String())
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+	graphQLClient, err := gh.NewGraphQLClient(httpClient, "github.com")
+	require.NoError(t, err)
+	g := GitHub{
+		Owner:         "test-owner",
+		Repository:    "test-repository",
+		Client:        github.NewClient(httpClient),
+		GraphQLClient: graphQLClient,
+	}
+
+	status, err := g.Find(context.Background(), "maiao.I1")
+	require.NoError(t, err)
+	assert.Equal(t, &PullRequestStatus{
+		PullRequest:    PullRequest{ID: "2", URL: "https://github.com/test-owner/test-repository/pull/2"},
+		Base:           "main",
+		Head:           "maiao.I1",
+		State:          PullRequestStateDraft,
+		ReviewDecision: ReviewDecisionChangesRequested,
+		Checks:         ChecksSuccess,
+	}, status)
+
+	status, err = g.Find(context.Background(), "contributor:maiao.I2")
+	require.NoError(t, err)
+	assert.Equal(t, PullRequestStateMerged, status.State)
+	assert.Empty(t, status.ReviewDecision)
+	assert.Empty(t, status.Checks)
+
+	status, err = g.Find(context.Background(), "maiao.I3")
+	require.NoError(t, err)
+	assert.Nil(t, status)
+}
+
+func TestGitHubManagePullRequests(t *testing.T) {
+	edits := map[string]map[string]interface{}{}
+	comments := map[string][]string{}
+	merges := map[string]string{}
+	respond := func(body string) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{"Content-Type": {"application/json"}}}, nil
+	}
+	httpClient := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/test-owner/test-repository/pulls/1":
+			return respond(`{"number": 1, "html_url": "https://github.com/test-owner/test-repository/pull/1", "state": "open", "base": {"ref": "main"}, "head": {"ref": "maiao.I1"}}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/test-owner/test-repository/pulls":
+			assert.Equal(t, "open", r.URL.Query().Get("state"))
+			assert.Equal(t, "maiao.I1", r.URL.Query().Get("base"))
+			return respond(`[{"number": 2, "state": "open", "draft": true, "base": {"ref": "maiao.I1"}, "head": {"ref": "maiao.I2"}}]`)
+		case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/repos/test-owner/test-repository/pulls/"):
+			edit := map[string]interface{}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&edit))
+			edits[strings.TrimPrefix(r.URL.Path, "/repos/test-owner/test-repository/pulls/")] = edit
+			return respond(`{}`)
+		case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/merge"):
+			merge := map[string]interface{}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&merge))
+			number := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/test-owner/test-repository/pulls/"), "/merge")
+			merges[number], _ = merge["merge_method"].(string)
+			return respond(fmt.Sprintf(`{"merged": %t, "message": "not mergeable"}`, number == "2"))
+		case r.Method == http.MethodPost && r.URL.Path == "/repos/test-owner/test-repository/issues/1/comments":
+			comment := github.IssueComment{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+			comments["1"] = append(comments["1"], comment.GetBody())
+			return respond(`{}`)
+		}
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.String())
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+	g := GitHub{
+		Owner:      "test-owner",
+		Repository: "test-repository",
+		Client:     github.NewClient(httpClient),
+	}
+
+	status, err := g.Get(context.Background(), "1")
+	require.NoError(t, err)
+	assert.Equal(t, &PullRequestStatus{
+		PullRequest: PullRequest{ID: "1", URL: "https://github.com/test-owner/test-repository/pull/1"},
+		Base:        "main",
+		Head:        "maiao.I1",
+		State:       PullRequestStateOpen,
+	}, status)
+
+	children, err := g.ListOpen(context.Background(), "maiao.I1")
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	assert.Equal(t, "2", children[0].ID)
+	assert.Equal(t, PullRequestStateDraft, children[0].State)
+
+	require.NoError(t, g.Retarget(context.Background(), &children[0].PullRequest, "main"))
+	assert.Equal(t, map[string]interface{}{"base": "main"}, edits["2"])
+
+	require.NoError(t, g.Close(context.Background(), &status.PullRequest, "superseded"))
+	assert.Equal(t, map[string]interface{}{"state": "closed"}, edits["1"])
+	assert.Equal(t, []string{"superseded"}, comments["1"])
+
+	require.NoError(t, g.Merge(context.Background(), &children[0].PullRequest, MergeMethodRebase))
+	assert.Equal(t, "rebase", merges["2"])
+	err = g.Merge(context.Background(), &status.PullRequest, MergeMethodSquash)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not mergeable")
+
+	_, err = g.Get(context.Background(), "not-a-number")
+	assert.Error(t, err)
+}
+
+func TestGitHubUpdateAutoMerge(t *testing.T) {
+	mergeState := "BLOCKED"
+	mutations := []string{}
+	inputs := []map[string]interface{}{}
+	httpClient := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		respond := func(body string) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{"Content-Type": {"application/json"}}}, nil
+		}
+		switch {
+		case r.Method == http.MethodPatch && r.URL.Path == "/repos/test-owner/test-repository/pulls/1":
+			edit := map[string]interface{}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&edit))
+			return respond(fmt.Sprintf(`{"number": 1, "node_id": "PR_1", "url": "https://api.github.com/repos/test-owner/test-repository/pulls/1", "draft": %t, "base": {"ref": %q}}`, edit["title"] == "draft", edit["base"]))
+		case r.URL.Path == "/graphql":
+			query := struct {
+				Query     string                 `json:"query"`
+				Variables map[string]interface{} `json:"variables"`
+			}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+			if strings.Contains(query.Query, "mergeStateStatus") {
+				assert.Equal(t, float64(1), query.Variables["number"])
+				return respond(fmt.Sprintf(`{"data": {"repository": {"pullRequest": {"mergeStateStatus": %q}}}}`, mergeState))
+			}
+			if strings.Contains(query.Query, "mergeQueue(") {
+				if query.Variables["branch"] == "queued" {
+					return respond(`{"data": {"repository": {"mergeQueue": {"id": "MQ_1"}}}}`)
+				}
+				return respond(`{"data": {"repository": {"mergeQueue": null}}}`)
+			}
+			for _, mutation := range []string{"enablePullRequestAutoMerge", "enqueuePullRequest"} {
+				if strings.Contains(query.Query, mutation+"(") {
+					mutations = append(mutations, mutation)
+					inputs = append(inputs, query.Variables["input"].(map[string]interface{}))
+				}
+			}
+			return respond(`{"data": {}}`)
+		}
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.String())
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+	graphQLClient, err := gh.NewGraphQLClient(httpClient, "github.com")
+	require.NoError(t, err)
+	g := GitHub{
+		Owner:         "test-owner",
+		Repository:    "test-repository",
+		Client:        github.NewClient(httpClient),
+		GraphQLClient: graphQLClient,
+	}
+	pr := &PullRequest{ID: "1"}
+
+	_, err = g.Update(context.Background(), pr, PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "change"})
+	require.NoError(t, err)
+	assert.Empty(t, mutations, "auto-merge must be left untouched when not requested")
+
+	_, err = g.Update(context.Background(), pr, PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "change", AutoMerge: MergeMethodSquash})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"enablePullRequestAutoMerge"}, mutations)
+	assert.Equal(t, map[string]interface{}{"pullRequestId": "PR_1", "mergeMethod": "SQUASH"}, inputs[0])
+
+	_, err = g.Update(context.Background(), pr, PullRequestOptions{Base: "queued", Head: "maiao.I1", Title: "change", AutoMerge: MergeMethodMerge})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"enablePullRequestAutoMerge", "enablePullRequestAutoMerge"}, mutations, "pending pull requests must be queued by GitHub once their requirements are met")
+
+	mergeState = "CLEAN"
+	_, err = g.Update(context.Background(), pr, PullRequestOptions{Base: "queued", Head: "maiao.I1", Title: "change", AutoMerge: MergeMethodMerge})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"enablePullRequestAutoMerge", "enablePullRequestAutoMerge", "enqueuePullRequest"}, mutations, "mergeable pull requests must be queued right away")
+	assert.Equal(t, map[string]interface{}{"pullRequestId": "PR_1"}, inputs[2])
+
+	_, err = g.Update(context.Background(), pr, PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "change", AutoMerge: MergeMethodMerge})
+	require.NoError(t, err)
+	assert.Len(t, mutations, 3, "mergeable pull requests must be left alone without merge queue")
+	mergeState = "BLOCKED"
+
+	_, err = g.Update(context.Background(), pr, PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "draft", AutoMerge: MergeMethodMerge})
+	require.NoError(t, err)
+	assert.Len(t, mutations, 3, "draft pull requests must not be merged automatically")
+}
+
 func TestEnsureCreatesAndReturnsNewPRWhenNotExisting(t *testing.T) {
 	defer func(transport http.RoundTripper) {
 		http.DefaultTransport = transport
@@ -186,6 +401,45 @@ func TestEnsureCreatesAndReturnsNewPRWhenNotExisting(t *testing.T) {
 	assert.Equal(t, "12345", pr.ID)
 }
 
+func TestEnsureUsesForkHeadsAsIs(t *testing.T) {
+	heads := []string{}
+	created := []string{}
+	g := GitHub{
+		Owner:      "test-owner",
+		Repository: "test-repository",
+		Client: github.NewClient(&http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			switch r.Method {
+			case http.MethodGet:
+				heads = append(heads, r.URL.Query().Get("head"))
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(`[]`))}, nil
+			case http.MethodPost:
+				in := github.NewPullRequest{}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+				created = append(created, in.GetHead())
+				body, err := json.Marshal(github.PullRequest{Number: github.Int(1)})
+				require.NoError(t, err)
+				return &http.Response{StatusCode: http.StatusCreated, Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+			}
+			return nil, fmt.Errorf("unexpected %s to url '%s'", r.Method, r.URL.String())
+		})}),
+	}
+	_, _, err := g.Ensure(context.Background(), PullRequestOptions{Head: "some-ref"})
+	require.NoError(t, err)
+	_, _, err = g.Ensure(context.Background(), PullRequestOptions{Head: "contributor:some-ref"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test-owner:some-ref", "contributor:some-ref"}, heads)
+	assert.Equal(t, []string{"some-ref", "contributor:some-ref"}, created)
+}
+
+func TestSplitHead(t *testing.T) {
+	owner, branch := splitHead("maiao.I1")
+	assert.Equal(t, "", owner)
+	assert.Equal(t, "maiao.I1", branch)
+	owner, branch = splitHead("contributor:maiao.I1")
+	assert.Equal(t, "contributor", owner)
+	assert.Equal(t, "maiao.I1", branch)
+}
+
 type TransportFunc func(r *http.Request) (*http.Response, error)
 
 func (t TransportFunc) RoundTrip(r *http.Request) (*http.Response, error) {
@@ -362,6 +616,75 @@ func TestLinkedTopicIssues(t *testing.T) {
 		"https://github.com/search?q=is%3Apr+is%3Aopen+%22topic-sha%22&type=issues",
 		g.LinkedTopicIssues("topic-sha"),
 	)
+	g.Host = "github-alias"
+	g.WebHost = "github.company.example.com"
+	assert.Equal(
+		t,
+		"https://github.company.example.com/search?q=is%3Apr+is%3Aopen+%22topic-sha%22&type=issues",
+		g.LinkedTopicIssues("topic-sha"),
+	)
+}
+
+func TestNewGitHubUpserterWithConfiguredAPI(t *testing.T) {
+	originalTransport := http.DefaultTransport
+	t.Cleanup(func() {
+		http.DefaultTransport = originalTransport
+	})
+	defer tempEnv("GITHUB_TOKEN", "some-token")()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	requested := []string{}
+	http.DefaultTransport = TransportFunc(func(r *http.Request) (*http.Response, error) {
+		requested = append(requested, r.URL.Host+r.URL.Path)
+		b := bytes.Buffer{}
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(&b),
+		}
+		return resp, json.NewEncoder(&b).Encode(github.Repository{
+			Owner:   &github.User{Login: github.String("org")},
+			Name:    github.String("repo"),
+			HTMLURL: github.String("https://github.company.example.com/org/repo"),
+		})
+	})
+	cfg := config.NewConfig()
+	cfg.Raw.Section("maiao").Subsection("github-alias").SetOption("apiURL", "https://ghe-api.company.example.com/github/api/v3/")
+	pr, err := NewPullRequester(context.Background(), testRemote(t, "git@github-alias:org/repo.git"), BackendOptions{Config: cfg, Backend: "github"})
+	require.NoError(t, err)
+	g := pr.(*GitHub)
+	assert.Equal(t, "https://ghe-api.company.example.com/github/api/v3/", g.Client.BaseURL.String())
+	assert.Equal(t, "github.company.example.com", g.WebHost)
+	assert.Equal(t, "https://github.company.example.com/search?q=is%3Apr+is%3Aopen+%22sha%22&type=issues", g.LinkedTopicIssues("sha"))
+	assert.Equal(t, []string{"ghe-api.company.example.com/github/api/v3/repos/org/repo"}, requested)
+
+	t.Run("the API URLs of a repository are not used for the others", func(t *testing.T) {
+		requested = []string{}
+		pr, err := NewPullRequester(context.Background(), testRemote(t, "git@github-alias:org/repo.git"), BackendOptions{Config: config.NewConfig(), Backend: "github"})
+		require.NoError(t, err)
+		assert.Equal(t, "https://github-alias/api/v3/", pr.(*GitHub).Client.BaseURL.String())
+		assert.Equal(t, []string{"github-alias/api/v3/repos/org/repo"}, requested)
+	})
+
+	t.Run("the API URLs of ssh host aliases take precedence over the ones of the host", func(t *testing.T) {
+		home := os.Getenv("HOME")
+		require.NoError(t, os.MkdirAll(filepath.Join(home, ".ssh"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte("Host github-work\n\tHostName github.company.example.com\n"), 0o644))
+		cfg := config.NewConfig()
+		cfg.Raw.Section("maiao").Subsection("github.company.example.com").SetOption("apiURL", "https://github.company.example.com/unused/api/v3/")
+		cfg.Raw.Section("maiao").Subsection("github-work").SetOption("apiURL", "https://ghe-api.company.example.com/github/api/v3/")
+		requested = []string{}
+		pr, err := NewPullRequester(context.Background(), testRemote(t, "git@github-work:org/repo.git"), BackendOptions{Config: cfg, Backend: "github"})
+		require.NoError(t, err)
+		assert.Equal(t, "github.company.example.com", pr.(*GitHub).Host)
+		assert.Equal(t, []string{"ghe-api.company.example.com/github/api/v3/repos/org/repo"}, requested)
+
+		requested = []string{}
+		cfg.Raw.Section("maiao").RemoveSubsection("github-work")
+		_, err = NewPullRequester(context.Background(), testRemote(t, "git@github-work:org/repo.git"), BackendOptions{Config: cfg, Backend: "github"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"github.company.example.com/unused/api/v3/repos/org/repo"}, requested)
+	})
 }
 
 // get all logs when running tests
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/adevinta/maiao/pkg/credentials"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sirupsen/logrus"
+)
+
+const gitLabDraftPrefix = "Draft: "
+
+// GitLab implements the PullRequester interface using GitLab merge requests
+type GitLab struct {
+	restClient
+	Host string
+	// Project is the full path of the project, including all its groups
+	Project string
+}
+
+type gitLabMergeRequest struct {
+	IID          int    `json:"iid"`
+	WebURL       string `json:"web_url"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	State        string `json:"state"`
+	Draft        bool   `json:"draft"`
+}
+
+func (mr *gitLabMergeRequest) pullRequest() *PullRequest {
+	return &PullRequest{
+		ID:  strconv.Itoa(mr.IID),
+		URL: mr.WebURL,
+	}
+}
+
+func (g *GitLab) projectPath(elements ...string) string {
+	return strings.Join(append([]string{"projects", url.PathEscape(g.Project)}, elements...), "/")
+}
+
+// Ensure ensures a merge request is opened for the head branch
+func (g *GitLab) Ensure(ctx context.Context, options PullRequestOptions) (*PullRequest, bool, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":   "ensuring existing merge request",
+		"project":   g.Project,
+		"prOptions": options,
+	})
+	mrs := []gitLabMergeRequest{}
+	err := g.do(ctx, http.MethodGet, g.projectPath("merge_requests"), url.Values{
+		"source_branch": {options.Head},
+		"state":         {"opened"},
+		"order_by":      {"created_at"},
+		"sort":          {"desc"},
+	}, nil, &mrs)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to list existing merge requests")
+		return nil, false, err
+	}
+	switch len(mrs) {
+	case 0:
+		title := options.Title
+		if options.WIP {
+			log.ForContext(ctx).Info("adding draft marker")
+			title = gitLabDraftTitle(title)
+		}
+		mr := gitLabMergeRequest{}
+		err := g.do(ctx, http.MethodPost, g.projectPath("merge_requests"), nil, map[string]interface{}{
+			"source_branch": options.Head,
+			"target_branch": options.Base,
+			"title":         title,
+			"description":   options.Body,
+		}, &mr)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to create new merge request")
+			return nil, false, err
+		}
+		log.ForContext(ctx).Debug("new merge request has been created")
+		return mr.pullRequest(), true, nil
+	case 1:
+		log.ForContext(ctx).Trace("merge request already existed")
+		return mrs[0].pullRequest(), false, nil
+	}
+	log.ForContext(ctx).Error("too many matching merge requests")
+	return nil, false, errors.New("Too many matching merge requests")
+}
+
+// Update implements the Update interface to update an existing merge request
+func (g *GitLab) Update(ctx context.Context, pr *PullRequest, options PullRequestOptions) (*PullRequest, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":   "updating existing merge request",
+		"project":   g.Project,
+		"prOptions": options,
+		"prID":      pr.ID,
+	})
+	current := gitLabMergeRequest{}
+	err := g.do(ctx, http.MethodGet, g.projectPath("merge_requests", pr.ID), nil, nil, &current)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get merge request")
+		return nil, err
+	}
+	title := options.Title
+	// The draft status of GitLab merge requests is carried by the title.
+	// Keep it unless the merge request is explicitly marked as ready.
+	if current.Draft && !options.Ready {
+		title = gitLabDraftTitle(title)
+	}
+	if options.Ready {
+		log.ForContext(ctx).Info("marking merge request as ready")
+	}
+	mr := gitLabMergeRequest{}
+	err = g.do(ctx, http.MethodPut, g.projectPath("merge_requests", pr.ID), nil, map[string]interface{}{
+		"target_branch": options.Base,
+		"title":         title,
+		"description":   options.Body,
+	}, &mr)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to edit merge request")
+		return nil, err
+	}
+	log.ForContext(ctx).Info("edit merge request")
+	return mr.pullRequest(), nil
+}
+
+// Find returns the status of the most recent merge request opened for head, or nil when none exists
+func (g *GitLab) Find(ctx context.Context, head string) (*PullRequestStatus, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context": "finding merge request",
+		"project": g.Project,
+		"head":    head,
+	})
+	_, branch := splitHead(head)
+	mrs := []gitLabMergeRequest{}
+	err := g.do(ctx, http.MethodGet, g.projectPath("merge_requests"), url.Values{
+		"source_branch": {branch},
+		"order_by":      {"created_at"},
+		"sort":          {"desc"},
+	}, nil, &mrs)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to list merge requests")
+		return nil, err
+	}
+	if len(mrs) == 0 {
+		return nil, nil
+	}
+	return g.Get(ctx, strconv.Itoa(mrs[0].IID))
+}
+
+func (mr *gitLabMergeRequest) status() *PullRequestStatus {
+	status := &PullRequestStatus{
+		PullRequest: *mr.pullRequest(),
+		Base:        mr.TargetBranch,
+		Head:        mr.SourceBranch,
+		Title:       mr.Title,
+		State:       PullRequestStateOpen,
+		Body:        mr.Description,
+	}
+	switch {
+	case mr.State == "merged":
+		status.State = PullRequestStateMerged
+	case mr.State == "closed" || mr.State == "locked":
+		status.State = PullRequestStateClosed
+	case mr.Draft:
+		status.State = PullRequestStateDraft
+	}
+	return status
+}
+
+// Get returns the status of the merge request identified by id
+func (g *GitLab) Get(ctx context.Context, id string) (*PullRequestStatus, error) {
+	// the pipeline status is only available when getting a single merge request
+	mr := struct {
+		gitLabMergeRequest
+		HeadPipeline *struct {
+			Status string `json:"status"`
+		} `json:"head_pipeline"`
+	}{}
+	err := g.do(ctx, http.MethodGet, g.projectPath("merge_requests", id), nil, nil, &mr)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", id).Error("failed to get merge request")
+		return nil, err
+	}
+	status := mr.status()
+	if mr.HeadPipeline != nil {
+		status.Checks = gitLabChecks(mr.HeadPipeline.Status)
+	}
+	approvals := struct {
+		Approved bool `json:"approved"`
+	}{}
+	err = g.do(ctx, http.MethodGet, g.projectPath("merge_requests", status.ID, "approvals"), nil, nil, &approvals)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Warn("failed to get merge request approvals")
+	} else if approvals.Approved {
+		status.ReviewDecision = ReviewDecisionApproved
+	} else {
+		status.ReviewDecision = ReviewDecisionRequired
+	}
+	return status, nil
+}
+
+// ListOpen returns the open merge requests targeting the base branch
+func (g *GitLab) ListOpen(ctx context.Context, base string) ([]*PullRequestStatus, error) {
+	statuses := []*PullRequestStatus{}
+	for page := 1; ; page++ {
+		mrs := []gitLabMergeRequest{}
+		err := g.do(ctx, http.MethodGet, g.projectPath("merge_requests"), url.Values{
+			"target_branch": {base},
+			"state":         {"opened"},
+			"page":          {strconv.Itoa(page)},
+			"per_page":      {"100"},
+		}, nil, &mrs)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).WithField("base", base).Error("failed to list merge requests")
+			return nil, err
+		}
+		for _, mr := range mrs {
+			statuses = append(statuses, mr.status())
+		}
+		if len(mrs) < 100 {
+			return statuses, nil
+		}
+	}
+}
+
+// Close closes the merge request, commenting it first when comment is not empty
+func (g *GitLab) Close(ctx context.Context, pr *PullRequest, comment string) error {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context": "closing merge request",
+		"project": g.Project,
+		"prID":    pr.ID,
+	})
+	if comment != "" {
+		err := g.do(ctx, http.MethodPost, g.projectPath("merge_requests", pr.ID, "notes"), nil, map[string]string{"body": comment}, nil)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to comment merge request")
+			return err
+		}
+	}
+	err := g.do(ctx, http.MethodPut, g.projectPath("merge_requests", pr.ID), nil, map[string]string{"state_event": "close"}, nil)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to close merge request")
+		return err
+	}
+	return nil
+}
+
+// Retarget changes the target branch of the merge request
+func (g *GitLab) Retarget(ctx context.Context, pr *PullRequest, base string) error {
+	err := g.do(ctx, http.MethodPut, g.projectPath("merge_requests", pr.ID), nil, map[string]string{"target_branch": base}, nil)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", pr.ID).WithField("base", base).Error("failed to change merge request target branch")
+		return err
+	}
+	return nil
+}
+
+// Merge accepts the merge request.
+// GitLab merges with the merge method configured for the project, only squashing can be requested
+func (g *GitLab) Merge(ctx context.Context, pr *PullRequest, method string) error {
+	if method == MergeMethodRebase {
+		return errors.New("GitLab merge requests can't be rebased when merging, configure the merge method of the project instead")
+	}
+	err := g.do(ctx, http.MethodPut, g.projectPath("merge_requests", pr.ID, "merge"), nil, map[string]bool{"squash": method == MergeMethodSquash}, nil)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("prID", pr.ID).WithField("method", method).Error("failed to merge merge request")
+		return err
+	}
+	return nil
+}
+
+func gitLabChecks(status string) string {
+	switch status {
+	case "success":
+		return ChecksSuccess
+	case "failed", "canceled":
+		return ChecksFailure
+	case "created", "waiting_for_resource", "preparing", "pending", "running", "scheduled", "manual":
+		return ChecksPending
+	}
+	return ""
+}
+
+// DefaultBranch returns the default branch of the remote project
+func (g *GitLab) DefaultBranch(ctx context.Context) string {
+	project := struct {
+		DefaultBranch string `json:"default_branch"`
+	}{}
+	err := g.do(ctx, http.MethodGet, g.projectPath(), nil, nil, &project)
+	if err != nil {
+		return ""
+	}
+	return project.DefaultBranch
+}
+
+// LinkedTopicIssues returns the search URL for linked merge requests
+func (g *GitLab) LinkedTopicIssues(topicSearchString string) string {
+	values := url.Values{}
+	values.Add("scope", "all")
+	values.Add("state", "opened")
+	values.Add("search", topicSearchString)
+	return `https://` + g.Host + `/dashboard/merge_requests?` + values.Encode()
+}
+
+func gitLabDraftTitle(title string) string {
+	if strings.HasPrefix(strings.ToLower(title), "draft:") {
+		return title
+	}
+	return gitLabDraftPrefix + title
+}
+
+// NewGitLabUpserter instanciates an upserter that uses the GitLab v4 API to create and update merge requests
+//
+// Credentials are taken from the GITLAB_TOKEN environment variable or from
+// the default credential store
+func NewGitLabUpserter(ctx context.Context, endpoint *transport.Endpoint) (*GitLab, error) {
+	return newGitLabUpserter(ctx, endpoint, nil)
+}
+
+// newGitLabUpserter instanciates the GitLab client, authenticating with getter, the default credential store when nil
+func newGitLabUpserter(ctx context.Context, endpoint *transport.Endpoint, getter credentials.CredentialGetter) (*GitLab, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":  "initializing GitLab client",
+		"endpoint": endpoint,
+	})
+	project := strings.TrimSuffix(strings.Trim(endpoint.Path, "/"), ".git")
+	if !strings.Contains(project, "/") {
+		log.ForContext(ctx).WithField("repository", endpoint.Path).Error("invalid repository, expecting <group>/<project>")
+		return nil, fmt.Errorf("invalid repository, expecting <group>/<project>")
+	}
+	httpClient, err := newTokenHTTPClient(ctx, getter, endpoint.Host, "GITLAB_TOKEN")
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Errorf("failed to create a new http client: %s", err.Error())
+		return nil, err
+	}
+	g := &GitLab{
+		restClient: restClient{
+			Client:  httpClient,
+			BaseURL: &url.URL{Scheme: "https", Host: endpoint.Host, Path: "/api/v4/"},
+		},
+		Host:    endpoint.Host,
+		Project: project,
+	}
+	p := struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	}{}
+	err = g.do(ctx, http.MethodGet, g.projectPath(), nil, nil, &p)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to get GitLab project")
+		return nil, err
+	}
+	if p.PathWithNamespace != "" {
+		g.Project = p.PathWithNamespace
+	}
+	log.ForContext(ctx).Trace("initialized gitlab client")
+	return g, nil
+}
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// fakeGitLab is a minimal in-memory stand-in of the GitLab v4 merge requests API
+type fakeGitLab struct {
+	sync.Mutex
+	t             *testing.T
+	project       string
+	defaultBranch string
+	mrs           []*gitLabMergeRequest
+	pipelines     map[int]string
+	approved      map[int]bool
+	notes         map[int][]string
+	squashed      map[int]bool
+}
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	prefix := "/api/v4/projects/" + url.PathEscape(f.project)
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path = strings.TrimPrefix(path, prefix)
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]string{"default_branch": f.defaultBranch, "path_with_namespace": f.project})
+	case path == "/merge_requests" && r.Method == http.MethodGet:
+		mrs := []*gitLabMergeRequest{}
+		for _, mr := range f.mrs {
+			query := r.URL.Query()
+			if (query.Get("source_branch") == "" || mr.SourceBranch == query.Get("source_branch")) &&
+				(query.Get("target_branch") == "" || mr.TargetBranch == query.Get("target_branch")) &&
+				(query.Get("state") == "" || mr.State == query.Get("state")) {
+				mrs = append([]*gitLabMergeRequest{mr}, mrs...)
+			}
+		}
+		json.NewEncoder(w).Encode(mrs)
+	case path == "/merge_requests" && r.Method == http.MethodPost:
+		in := map[string]string{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+		mr := &gitLabMergeRequest{
+			IID:          len(f.mrs) + 1,
+			SourceBranch: in["source_branch"],
+			TargetBranch: in["target_branch"],
+			Description:  in["description"],
+			State:        "opened",
+		}
+		f.setTitle(mr, in["title"])
+		mr.WebURL = fmt.Sprintf("https://gitlab.example.com/%s/-/merge_requests/%d", f.project, mr.IID)
+		f.mrs = append(f.mrs, mr)
+		json.NewEncoder(w).Encode(mr)
+	case strings.HasPrefix(path, "/merge_requests/") && strings.HasSuffix(path, "/approvals"):
+		iid, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/merge_requests/"), "/approvals"))
+		json.NewEncoder(w).Encode(map[string]bool{"approved": f.approved[iid]})
+	case strings.HasPrefix(path, "/merge_requests/") && strings.HasSuffix(path, "/notes") && r.Method == http.MethodPost:
+		iid, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/merge_requests/"), "/notes"))
+		in := map[string]string{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+		if f.notes == nil {
+			f.notes = map[int][]string{}
+		}
+		f.notes[iid] = append(f.notes[iid], in["body"])
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(in)
+	case strings.HasPrefix(path, "/merge_requests/") && strings.HasSuffix(path, "/merge") && r.Method == http.MethodPut:
+		iid, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, "/merge_requests/"), "/merge"))
+		in := map[string]bool{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+		if f.squashed == nil {
+			f.squashed = map[int]bool{}
+		}
+		f.squashed[iid] = in["squash"]
+		f.mrs[iid-1].State = "merged"
+		json.NewEncoder(w).Encode(f.mrs[iid-1])
+	case strings.HasPrefix(path, "/merge_requests/"):
+		iid, err := strconv.Atoi(strings.TrimPrefix(path, "/merge_requests/"))
+		if err != nil || iid < 1 || iid > len(f.mrs) {
+			http.NotFound(w, r)
+			return
+		}
+		mr := f.mrs[iid-1]
+		if r.Method == http.MethodPut {
+			in := map[string]string{}
+			require.NoError(f.t, json.NewDecoder(r.Body).Decode(&in))
+			if target, ok := in["target_branch"]; ok {
+				mr.TargetBranch = target
+			}
+			if description, ok := in["description"]; ok {
+				mr.Description = description
+			}
+			if title, ok := in["title"]; ok {
+				f.setTitle(mr, title)
+			}
+			if in["state_event"] == "close" {
+				mr.State = "closed"
+			}
+		}
+		detailed := struct {
+			*gitLabMergeRequest
+			HeadPipeline map[string]string `json:"head_pipeline,omitempty"`
+		}{gitLabMergeRequest: mr}
+		if pipeline, ok := f.pipelines[iid]; ok {
+			detailed.HeadPipeline = map[string]string{"status": pipeline}
+		}
+		json.NewEncoder(w).Encode(detailed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeGitLab) setTitle(mr *gitLabMergeRequest, title string) {
+	mr.Title = title
+	mr.Draft = strings.HasPrefix(title, gitLabDraftPrefix)
+}
+
+func newTestGitLab(t *testing.T, fake *fakeGitLab) *GitLab {
+	fake.t = t
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL + "/api/v4/")
+	require.NoError(t, err)
+	return &GitLab{
+		restClient: restClient{Client: server.Client(), BaseURL: u},
+		Host:       "gitlab.example.com",
+		Project:    fake.project,
+	}
+}
+
+func TestGitLabEnsure(t *testing.T) {
+	fake := &fakeGitLab{project: "group/subgroup/project"}
+	g := newTestGitLab(t, fake)
+
+	pr, created, err := g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first change", Body: "body", WIP: true})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "1", pr.ID)
+	assert.Equal(t, "https://gitlab.example.com/group/subgroup/project/-/merge_requests/1", pr.URL)
+	assert.Equal(t, "Draft: first change", fake.mrs[0].Title)
+	assert.True(t, fake.mrs[0].Draft)
+
+	pr, created, err = g.Ensure(context.Background(), PullRequestOptions{Base: "maiao.I1", Head: "maiao.I2", Title: "second change"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "2", pr.ID)
+	assert.Equal(t, "maiao.I1", fake.mrs[1].TargetBranch)
+	assert.False(t, fake.mrs[1].Draft)
+
+	pr, created, err = g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first change"})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "1", pr.ID)
+	assert.Len(t, fake.mrs, 2)
+}
+
+func TestGitLabFind(t *testing.T) {
+	fake := &fakeGitLab{
+		project:   "group/project",
+		pipelines: map[int]string{2: "running"},
+		approved:  map[int]bool{2: true},
+		mrs: []*gitLabMergeRequest{
+			{IID: 1, SourceBranch: "maiao.I1", TargetBranch: "main", State: "closed", WebURL: "https://gitlab.example.com/group/project/-/merge_requests/1"},
+			{IID: 2, SourceBranch: "maiao.I1", TargetBranch: "main", State: "opened", Draft: true, WebURL: "https://gitlab.example.com/group/project/-/merge_requests/2"},
+			{IID: 3, SourceBranch: "maiao.I2", TargetBranch: "maiao.I1", State: "merged"},
+		},
+	}
+	g := newTestGitLab(t, fake)
+
+	status, err := g.Find(context.Background(), "maiao.I1")
+	require.NoError(t, err)
+	assert.Equal(t, &PullRequestStatus{
+		PullRequest:    PullRequest{ID: "2", URL: "https://gitlab.example.com/group/project/-/merge_requests/2"},
+		Base:           "main",
+		Head:           "maiao.I1",
+		State:          PullRequestStateDraft,
+		ReviewDecision: ReviewDecisionApproved,
+		Checks:         ChecksPending,
+	}, status)
+
+	status, err = g.Find(context.Background(), "maiao.I2")
+	require.NoError(t, err)
+	assert.Equal(t, PullRequestStateMerged, status.State)
+	assert.Equal(t, ReviewDecisionRequired, status.ReviewDecision)
+	assert.Empty(t, status.Checks)
+
+	status, err = g.Find(context.Background(), "maiao.I3")
+	require.NoError(t, err)
+	assert.Nil(t, status)
+}
+
+func TestGitLabUpdate(t *testing.T) {
+	fake := &fakeGitLab{project: "group/project"}
+	g := newTestGitLab(t, fake)
+
+	pr, _, err := g.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "change", WIP: true})
+	require.NoError(t, err)
+
+	t.Run("draft status is preserved when the merge request is not marked ready", func(t *testing.T) {
+		_, err := g.Update(context.Background(), pr, PullRequestOptions{Base: "maiao.I0", Head: "maiao.I1", Title: "new title", Body: "new body"})
+		require.NoError(t, err)
+		assert.Equal(t, "Draft: new title", fake.mrs[0].Title)
+		assert.Equal(t, "new body", fake.mrs[0].Description)
+		assert.Equal(t, "maiao.I0", fake.mrs[0].TargetBranch)
+		assert.True(t, fake.mrs[0].Draft)
+	})
+
+	t.Run("draft status is removed when the merge request is marked ready", func(t *testing.T) {
+		updated, err := g.Update(context.Background(), pr, PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "new title", Ready: true})
+		require.NoError(t, err)
+		assert.Equal(t, "1", updated.ID)
+		assert.Equal(t, "new title", fake.mrs[0].Title)
+		assert.False(t, fake.mrs[0].Draft)
+	})
+
+	t.Run("updating a missing merge request fails", func(t *testing.T) {
+		_, err := g.Update(context.Background(), &PullRequest{ID: "42"}, PullRequestOptions{Base: "main", Head: "maiao.I1"})
+		assert.Error(t, err)
+		assert.True(t, isNotFound(err))
+	})
+}
+
+func TestGitLabManagePullRequests(t *testing.T) {
+	fake := &fakeGitLab{
+		project: "group/project",
+		mrs: []*gitLabMergeRequest{
+			{IID: 1, SourceBranch: "maiao.I1", TargetBranch: "main", State: "opened", Title: "first change"},
+			{IID: 2, SourceBranch: "maiao.I2", TargetBranch: "maiao.I1", State: "opened", Title: "second change"},
+			{IID: 3, SourceBranch: "maiao.I3", TargetBranch: "maiao.I1", State: "closed", Title: "third change"},
+		},
+	}
+	g := newTestGitLab(t, fake)
+
+	status, err := g.Get(context.Background(), "1")
+	require.NoError(t, err)
+	assert.Equal(t, "maiao.I1", status.Head)
+	assert.Equal(t, PullRequestStateOpen, status.State)
+
+	children, err := g.ListOpen(context.Background(), "maiao.I1")
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	assert.Equal(t, "2", children[0].ID)
+
+	require.NoError(t, g.Retarget(context.Background(), &children[0].PullRequest, "main"))
+	assert.Equal(t, "main", fake.mrs[1].TargetBranch)
+	assert.Equal(t, "second change", fake.mrs[1].Title)
+
+	require.NoError(t, g.Close(context.Background(), &status.PullRequest, "superseded"))
+	assert.Equal(t, "closed", fake.mrs[0].State)
+	assert.Equal(t, []string{"superseded"}, fake.notes[1])
+	assert.Equal(t, "first change", fake.mrs[0].Title)
+
+	require.NoError(t, g.Merge(context.Background(), &children[0].PullRequest, MergeMethodSquash))
+	assert.Equal(t, "merged", fake.mrs[1].State)
+	assert.True(t, fake.squashed[2])
+	assert.Error(t, g.Merge(context.Background(), &children[0].PullRequest, MergeMethodRebase))
+
+	_, err = g.Get(context.Background(), "42")
+	assert.True(t, isNotFound(err))
+}
+
+func TestGitLabDefaultBranch(t *testing.T) {
+	g := newTestGitLab(t, &fakeGitLab{project: "group/project", defaultBranch: "develop"})
+	assert.Equal(t, "develop", g.DefaultBranch(context.Background()))
+
+	g.Project = "other/project"
+	assert.Equal(t, "", g.DefaultBranch(context.Background()))
+}
+
+func TestGitLabLinkedTopicIssues(t *testing.T) {
+	g := GitLab{Host: "gitlab.example.com"}
+	assert.Equal(
+		t,
+		"https://gitlab.example.com/dashboard/merge_requests?scope=all&search=topic-sha&state=opened",
+		g.LinkedTopicIssues("topic-sha"),
+	)
+}
+
+func TestNewGitLabUpserter(t *testing.T) {
+	defer tempEnv("GITLAB_TOKEN", "some-token")()
+	fake := &fakeGitLab{project: "group/project", t: t}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer some-token", r.Header.Get("Authorization"))
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "https://")
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, server.Client())
+
+	t.Run("when the repository has no group, constructor fails", func(t *testing.T) {
+		g, err := NewGitLabUpserter(ctx, &transport.Endpoint{Host: host, Path: "/project.git"})
+		assert.Error(t, err)
+		assert.Nil(t, g)
+	})
+	t.Run("when the project does not exist, constructor fails", func(t *testing.T) {
+		g, err := NewGitLabUpserter(ctx, &transport.Endpoint{Host: host, Path: "/group/missing.git"})
+		assert.Error(t, err)
+		assert.Nil(t, g)
+	})
+	t.Run("when the project exists, the client is configured", func(t *testing.T) {
+		g, err := NewGitLabUpserter(ctx, &transport.Endpoint{Host: host, Path: "/group/project.git"})
+		require.NoError(t, err)
+		assert.Equal(t, "group/project", g.Project)
+		assert.Equal(t, host, g.Host)
+		assert.Equal(t, "https://"+host+"/api/v4/", g.BaseURL.String())
+	})
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+// LocalStorePath is the path of the local pull requests store, relative to the git directory
+var LocalStorePath = filepath.Join("maiao", "prs.json")
+
+// Local implements the PullRequester interface by recording pull requests in a JSON file
+// instead of calling a forge.
+// It allows rehearsing stack submissions without any forge token. Unless PushBranches is set, the branches of
+// the changes are not pushed to the remote of the repository either, only its remote tracking branches are updated
+type Local struct {
+	// Path is the path of the JSON store
+	Path string
+	// PushBranches pushes the branches of the changes to the remote, like with any other backend.
+	// The local backend sets it from the maiao.localPush git configuration
+	PushBranches bool
+}
+
+// LocalPullRequest is a pull request as recorded in the local store
+type LocalPullRequest struct {
+	ID    int    `json:"id"`
+	Base  string `json:"base"`
+	Head  string `json:"head"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Draft bool   `json:"draft"`
+	// Closed is set once the pull request is closed, closed pull requests are never updated again
+	Closed bool `json:"closed,omitempty"`
+	// Merged is set once the pull request is merged, nothing is merged in the repository itself
+	Merged   bool     `json:"merged,omitempty"`
+	Comments []string `json:"comments,omitempty"`
+}
+
+type localStore struct {
+	LastID       int                 `json:"lastID"`
+	PullRequests []*LocalPullRequest `json:"pullRequests"`
+}
+
+func (l *Local) load() (*localStore, error) {
+	store := &localStore{}
+	b, err := os.ReadFile(l.Path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, store)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local pull requests store %s: %w", l.Path, err)
+	}
+	return store, nil
+}
+
+func (l *Local) save(store *localStore) error {
+	b, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(l.Path), 0o755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(l.Path, append(b, '\n'), 0o644)
+}
+
+func (l *Local) pullRequest(pr *LocalPullRequest) *PullRequest {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(l.Path), Fragment: strconv.Itoa(pr.ID)}
+	return &PullRequest{
+		ID:  strconv.Itoa(pr.ID),
+		URL: u.String(),
+	}
+}
+
+func (l *Local) status(pr *LocalPullRequest) *PullRequestStatus {
+	status := &PullRequestStatus{
+		PullRequest: *l.pullRequest(pr),
+		Base:        pr.Base,
+		Head:        pr.Head,
+		Title:       pr.Title,
+		State:       PullRequestStateOpen,
+		Body:        pr.Body,
+	}
+	switch {
+	case pr.Merged:
+		status.State = PullRequestStateMerged
+	case pr.Closed:
+		status.State = PullRequestStateClosed
+	case pr.Draft:
+		status.State = PullRequestStateDraft
+	}
+	return status
+}
+
+// edit applies fn to the recorded pull request identified by id and saves the store
+func (l *Local) edit(id string, fn func(*LocalPullRequest)) (*LocalPullRequest, error) {
+	store, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, recorded := range store.PullRequests {
+		if strconv.Itoa(recorded.ID) != id {
+			continue
+		}
+		fn(recorded)
+		return recorded, l.save(store)
+	}
+	return nil, fmt.Errorf("pull request %s not found in %s", id, l.Path)
+}
+
+// PullRequests returns all the pull requests recorded in the store
+func (l *Local) PullRequests() ([]*LocalPullRequest, error) {
+	store, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	return store.PullRequests, nil
+}
+
+// Ensure ensures a PR is recorded for the head branch
+func (l *Local) Ensure(ctx context.Context, options PullRequestOptions) (*PullRequest, bool, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":   "ensuring existing pull request",
+		"store":     l.Path,
+		"prOptions": options,
+	})
+	store, err := l.load()
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to load local pull requests")
+		return nil, false, err
+	}
+	matching := []*LocalPullRequest{}
+	for _, pr := range store.PullRequests {
+		if pr.Head == options.Head && !pr.Closed {
+			matching = append(matching, pr)
+		}
+	}
+	switch len(matching) {
+	case 0:
+		store.LastID++
+		pr := &LocalPullRequest{
+			ID:    store.LastID,
+			Base:  options.Base,
+			Head:  options.Head,
+			Title: options.Title,
+			Body:  options.Body,
+			Draft: options.WIP,
+		}
+		store.PullRequests = append(store.PullRequests, pr)
+		err = l.save(store)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to record new pull request")
+			return nil, false, err
+		}
+		log.ForContext(ctx).Debug("new PR has been recorded")
+		return l.pullRequest(pr), true, nil
+	case 1:
+		log.ForContext(ctx).Trace("PR already existed")
+		return l.pullRequest(matching[0]), false, nil
+	}
+	log.ForContext(ctx).Error("too many matching pull requests")
+	return nil, false, errors.New("Too many matching pull requests")
+}
+
+// Update implements the Update interface to update a recorded pull request
+func (l *Local) Update(ctx context.Context, pr *PullRequest, options PullRequestOptions) (*PullRequest, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":   "updating existing pull request",
+		"store":     l.Path,
+		"prOptions": options,
+		"prID":      pr.ID,
+	})
+	store, err := l.load()
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to load local pull requests")
+		return nil, err
+	}
+	for _, recorded := range store.PullRequests {
+		if strconv.Itoa(recorded.ID) != pr.ID {
+			continue
+		}
+		recorded.Base = options.Base
+		recorded.Title = options.Title
+		recorded.Body = options.Body
+		if options.Ready {
+			log.ForContext(ctx).Info("marking pull request as ready")
+			recorded.Draft = false
+		}
+		err = l.save(store)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to record pull request")
+			return nil, err
+		}
+		return l.pullRequest(recorded), nil
+	}
+	return nil, fmt.Errorf("pull request %s not found in %s", pr.ID, l.Path)
+}
+
+// Find returns the status of the most recent pull request recorded for head, or nil when none exists
+func (l *Local) Find(ctx context.Context, head string) (*PullRequestStatus, error) {
+	store, err := l.load()
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("store", l.Path).Error("failed to load local pull requests")
+		return nil, err
+	}
+	for i := len(store.PullRequests) - 1; i >= 0; i-- {
+		if store.PullRequests[i].Head == head {
+			return l.status(store.PullRequests[i]), nil
+		}
+	}
+	return nil, nil
+}
+
+// Get returns the status of the recorded pull request identified by id
+func (l *Local) Get(ctx context.Context, id string) (*PullRequestStatus, error) {
+	store, err := l.load()
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("store", l.Path).Error("failed to load local pull requests")
+		return nil, err
+	}
+	for _, pr := range store.PullRequests {
+		if strconv.Itoa(pr.ID) == id {
+			return l.status(pr), nil
+		}
+	}
+	return nil, fmt.Errorf("pull request %s not found in %s", id, l.Path)
+}
+
+// ListOpen returns the recorded pull requests targeting the base branch that are not closed
+func (l *Local) ListOpen(ctx context.Context, base string) ([]*PullRequestStatus, error) {
+	store, err := l.load()
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("store", l.Path).Error("failed to load local pull requests")
+		return nil, err
+	}
+	statuses := []*PullRequestStatus{}
+	for _, pr := range store.PullRequests {
+		if pr.Base == base && !pr.Closed {
+			statuses = append(statuses, l.status(pr))
+		}
+	}
+	return statuses, nil
+}
+
+// Close records the pull request as closed, along with comment when not empty
+func (l *Local) Close(ctx context.Context, pr *PullRequest, comment string) error {
+	_, err := l.edit(pr.ID, func(recorded *LocalPullRequest) {
+		if comment != "" {
+			recorded.Comments = append(recorded.Comments, comment)
+		}
+		recorded.Closed = true
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("store", l.Path).WithField("prID", pr.ID).Error("failed to close pull request")
+	}
+	return err
+}
+
+// Merge records the pull request as merged.
+// The local backend does not touch the repository, the base branch is left as is
+func (l *Local) Merge(ctx context.Context, pr *PullRequest, method string) error {
+	_, err := l.edit(pr.ID, func(recorded *LocalPullRequest) {
+		recorded.Merged = true
+		recorded.Closed = true
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("store", l.Path).WithField("prID", pr.ID).Error("failed to merge pull request")
+	}
+	return err
+}
+
+// Retarget records base as the new base branch of the pull request
+func (l *Local) Retarget(ctx context.Context, pr *PullRequest, base string) error {
+	_, err := l.edit(pr.ID, func(recorded *LocalPullRequest) {
+		recorded.Base = base
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("store", l.Path).WithField("prID", pr.ID).Error("failed to change pull request base")
+	}
+	return err
+}
+
+// LinkedTopicIssues returns the URL of the local store
+func (l *Local) LinkedTopicIssues(topicSearchString string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(l.Path)}
+	return u.String()
+}
+
+// KeepsBranchesLocal returns true unless PushBranches is set, for rehearsals not to write to the shared remote
+func (l *Local) KeepsBranchesLocal() bool {
+	return !l.PushBranches
+}
+
+// DefaultBranch returns an empty string, letting the git configuration decide on the default branch
+func (l *Local) DefaultBranch(ctx context.Context) string {
+	return ""
+}
+
+// NewLocalPullRequester instanciates a pull requester recording pull requests in the gitDir
+func NewLocalPullRequester(ctx context.Context, gitDir string) (*Local, error) {
+	if gitDir == "" {
+		return nil, errors.New("the local backend requires the git directory of the repository")
+	}
+	return &Local{Path: filepath.Join(gitDir, LocalStorePath)}, nil
+}
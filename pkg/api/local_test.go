@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocal(t *testing.T) {
+	gitDir := t.TempDir()
+	l, err := NewLocalPullRequester(context.Background(), gitDir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(gitDir, "maiao", "prs.json"), l.Path)
+
+	pr, created, err := l.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first", Body: "body", WIP: true})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "1", pr.ID)
+	assert.Equal(t, "file://"+filepath.ToSlash(l.Path)+"#1", pr.URL)
+
+	pr2, created, err := l.Ensure(context.Background(), PullRequestOptions{Base: "maiao.I1", Head: "maiao.I2", Title: "second"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "2", pr2.ID)
+
+	t.Run("existing pull requests are found by their head", func(t *testing.T) {
+		pr, created, err := l.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "first"})
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, "1", pr.ID)
+	})
+
+	t.Run("updates keep the draft state unless marked ready", func(t *testing.T) {
+		_, err := l.Update(context.Background(), pr, PullRequestOptions{Base: "develop", Head: "maiao.I1", Title: "new title", Body: "new body"})
+		require.NoError(t, err)
+		prs, err := l.PullRequests()
+		require.NoError(t, err)
+		assert.Equal(t, LocalPullRequest{ID: 1, Base: "develop", Head: "maiao.I1", Title: "new title", Body: "new body", Draft: true}, *prs[0])
+
+		_, err = l.Update(context.Background(), pr, PullRequestOptions{Base: "develop", Head: "maiao.I1", Title: "new title", Ready: true})
+		require.NoError(t, err)
+		prs, err = l.PullRequests()
+		require.NoError(t, err)
+		assert.False(t, prs[0].Draft)
+	})
+
+	t.Run("pull requests are found by their head", func(t *testing.T) {
+		status, err := l.Find(context.Background(), "maiao.I2")
+		require.NoError(t, err)
+		assert.Equal(t, &PullRequestStatus{PullRequest: *pr2, Base: "maiao.I1", Head: "maiao.I2", Title: "second", State: PullRequestStateOpen}, status)
+
+		status, err = l.Find(context.Background(), "maiao.unknown")
+		require.NoError(t, err)
+		assert.Nil(t, status)
+	})
+
+	t.Run("pull requests are managed by their number and base", func(t *testing.T) {
+		status, err := l.Get(context.Background(), "1")
+		require.NoError(t, err)
+		assert.Equal(t, "maiao.I1", status.Head)
+
+		children, err := l.ListOpen(context.Background(), "maiao.I1")
+		require.NoError(t, err)
+		require.Len(t, children, 1)
+		assert.Equal(t, "2", children[0].ID)
+
+		require.NoError(t, l.Retarget(context.Background(), pr2, "develop"))
+		require.NoError(t, l.Close(context.Background(), pr, "superseded"))
+		prs, err := l.PullRequests()
+		require.NoError(t, err)
+		assert.Equal(t, "develop", prs[1].Base)
+		assert.True(t, prs[0].Closed)
+		assert.Equal(t, []string{"superseded"}, prs[0].Comments)
+
+		status, err = l.Find(context.Background(), "maiao.I1")
+		require.NoError(t, err)
+		assert.Equal(t, PullRequestStateClosed, status.State)
+		children, err = l.ListOpen(context.Background(), "develop")
+		require.NoError(t, err)
+		require.Len(t, children, 1)
+		assert.Equal(t, "2", children[0].ID)
+
+		require.NoError(t, l.Merge(context.Background(), pr2, MergeMethodMerge))
+		status, err = l.Find(context.Background(), "maiao.I2")
+		require.NoError(t, err)
+		assert.Equal(t, PullRequestStateMerged, status.State)
+		children, err = l.ListOpen(context.Background(), "develop")
+		require.NoError(t, err)
+		assert.Empty(t, children)
+
+		_, err = l.Get(context.Background(), "42")
+		assert.Error(t, err)
+	})
+
+	t.Run("updating an unknown pull request fails", func(t *testing.T) {
+		_, err := l.Update(context.Background(), &PullRequest{ID: "42"}, PullRequestOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("the store is persisted across instances", func(t *testing.T) {
+		other, err := NewLocalPullRequester(context.Background(), gitDir)
+		require.NoError(t, err)
+		pr, created, err := other.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I3"})
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.Equal(t, "3", pr.ID)
+
+		pr, created, err = other.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1"})
+		require.NoError(t, err)
+		assert.True(t, created, "closed pull requests must not be reused")
+		assert.Equal(t, "4", pr.ID)
+	})
+
+	t.Run("a corrupted store is reported", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(l.Path, []byte("not json"), 0o644))
+		_, _, err := l.Ensure(context.Background(), PullRequestOptions{Head: "maiao.I4"})
+		assert.Error(t, err)
+	})
+}
+
+func TestNewLocalPullRequesterRequiresGitDir(t *testing.T) {
+	l, err := NewLocalPullRequester(context.Background(), "")
+	assert.Error(t, err)
+	assert.Nil(t, l)
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sirupsen/logrus"
+)
+
+// PluginPrefix is the prefix of the executables implementing external backends.
+// Setting maiao.<host>.forge to <name> uses the git-review-backend-<name> executable found in the PATH
+// when no built-in backend has this name
+const PluginPrefix = "git-review-backend-"
+
+// Actions sent to backend plugins, as their first argument and in the action field of the request
+const (
+	PluginActionEnsure        = "ensure"
+	PluginActionUpdate        = "update"
+	PluginActionDefaultBranch = "default-branch"
+	PluginActionTopicLink     = "topic-link"
+	PluginActionFind          = "find"
+	PluginActionGet           = "get"
+	PluginActionList          = "list"
+	PluginActionClose         = "close"
+	PluginActionRetarget      = "retarget"
+	PluginActionMerge         = "merge"
+	PluginActionCapabilities  = "capabilities"
+)
+
+// Optional features a backend plugin declares in its answer to the capabilities action.
+// Plugins failing the capabilities action declare none of them
+const (
+	// PluginCapabilityFind tells the plugin answers the find action.
+	// Without it, no pull request is found for a head without changing anything
+	PluginCapabilityFind = "find"
+	// PluginCapabilityAutoMerge tells the plugin enables auto-merge with the method in the autoMerge field of the options.
+	// Without it, reviews requesting auto-merge fail
+	PluginCapabilityAutoMerge = "auto-merge"
+)
+
+// PluginRemote describes the git remote a plugin is called for
+type PluginRemote struct {
+	URL      string `json:"url"`
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"`
+	Path     string `json:"path"`
+}
+
+// PluginRequest is the JSON document written to the standard input of a backend plugin
+type PluginRequest struct {
+	Action      string              `json:"action"`
+	Remote      PluginRemote        `json:"remote"`
+	Options     *PullRequestOptions `json:"options,omitempty"`
+	PullRequest *PullRequest        `json:"pullRequest,omitempty"`
+	Topic       string              `json:"topic,omitempty"`
+	// Comment is the comment to leave when closing a pull request
+	Comment string `json:"comment,omitempty"`
+	// MergeMethod is the method to merge a pull request with, one of the MergeMethod* values
+	MergeMethod string `json:"mergeMethod,omitempty"`
+}
+
+// PluginResponse is the JSON document a backend plugin writes to its standard output.
+// A non empty Error, or a non zero exit code, fails the action
+type PluginResponse struct {
+	PullRequest *PullRequest `json:"pullRequest,omitempty"`
+	Created     bool         `json:"created,omitempty"`
+	Branch      string       `json:"branch,omitempty"`
+	URL         string       `json:"url,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	// Status is the answer to the find action, left empty when no pull request exists for the head
+	Status *PullRequestStatus `json:"status,omitempty"`
+	// PullRequests is the answer to the list action
+	PullRequests []*PullRequestStatus `json:"pullRequests,omitempty"`
+	// Capabilities is the answer to the capabilities action, listing the PluginCapability* values the plugin supports
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// Plugin implements the PullRequester interface by driving an external executable.
+// The executable is run once per action, receiving the action as its only argument,
+// a PluginRequest on its standard input and answering a PluginResponse on its standard output
+type Plugin struct {
+	Name   string
+	Path   string
+	Remote PluginRemote
+
+	capabilitiesOnce sync.Once
+	capabilities     map[string]bool
+}
+
+// supports tells whether the plugin declares capability, asking it once for all its capabilities
+func (p *Plugin) supports(ctx context.Context, capability string) bool {
+	p.capabilitiesOnce.Do(func() {
+		p.capabilities = map[string]bool{}
+		response, err := p.call(ctx, PluginRequest{Action: PluginActionCapabilities})
+		if err != nil {
+			log.ForContext(ctx).WithError(err).WithField("plugin", p.Path).Debug("backend plugin does not declare any capability")
+			return
+		}
+		for _, c := range response.Capabilities {
+			p.capabilities[c] = true
+		}
+	})
+	return p.capabilities[capability]
+}
+
+func (p *Plugin) call(ctx context.Context, request PluginRequest) (*PluginResponse, error) {
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"plugin": p.Path,
+		"action": request.Action,
+	})
+	request.Remote = p.Remote
+	in, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, p.Path, request.Action)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	log.ForContext(ctx).Trace("calling backend plugin")
+	err = cmd.Run()
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("stderr", stderr.String()).Error("backend plugin failed")
+		return nil, fmt.Errorf("%s %s failed: %w: %s", PluginPrefix+p.Name, request.Action, err, strings.TrimSpace(stderr.String()))
+	}
+	response := PluginResponse{}
+	err = json.Unmarshal(stdout.Bytes(), &response)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("stdout", stdout.String()).Error("invalid backend plugin response")
+		return nil, fmt.Errorf("%s %s: invalid response: %w", PluginPrefix+p.Name, request.Action, err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("%s %s: %s", PluginPrefix+p.Name, request.Action, response.Error)
+	}
+	return &response, nil
+}
+
+// Ensure ensures a PR is opened for the head branch
+func (p *Plugin) Ensure(ctx context.Context, options PullRequestOptions) (*PullRequest, bool, error) {
+	response, err := p.call(ctx, PluginRequest{Action: PluginActionEnsure, Options: &options})
+	if err != nil {
+		return nil, false, err
+	}
+	if response.PullRequest == nil {
+		return nil, false, errors.New("backend plugin did not return any pull request")
+	}
+	return response.PullRequest, response.Created, nil
+}
+
+// Update implements the Update interface to update an existing pull request
+func (p *Plugin) Update(ctx context.Context, pr *PullRequest, options PullRequestOptions) (*PullRequest, error) {
+	response, err := p.call(ctx, PluginRequest{Action: PluginActionUpdate, PullRequest: pr, Options: &options})
+	if err != nil {
+		return nil, err
+	}
+	if response.PullRequest == nil {
+		return pr, nil
+	}
+	return response.PullRequest, nil
+}
+
+// SupportsAutoMerge implements the AutoMerger interface for plugins declaring the auto-merge capability
+func (p *Plugin) SupportsAutoMerge() bool {
+	return p.supports(context.Background(), PluginCapabilityAutoMerge)
+}
+
+// Find returns the status of the pull request of the head branch reported by the plugin, or nil when none exists.
+// The head is provided in the head field of the request options.
+// Plugins not declaring the find capability are not asked, and no pull request is found
+func (p *Plugin) Find(ctx context.Context, head string) (*PullRequestStatus, error) {
+	if !p.supports(ctx, PluginCapabilityFind) {
+		return nil, nil
+	}
+	response, err := p.call(ctx, PluginRequest{Action: PluginActionFind, Options: &PullRequestOptions{Head: head}})
+	if err != nil {
+		return nil, err
+	}
+	return response.Status, nil
+}
+
+// Get returns the status of the pull request identified by id, provided in the pullRequest field of the request
+func (p *Plugin) Get(ctx context.Context, id string) (*PullRequestStatus, error) {
+	response, err := p.call(ctx, PluginRequest{Action: PluginActionGet, PullRequest: &PullRequest{ID: id}})
+	if err != nil {
+		return nil, err
+	}
+	if response.Status == nil {
+		return nil, fmt.Errorf("pull request %s not found", id)
+	}
+	return response.Status, nil
+}
+
+// ListOpen returns the open pull requests targeting the base branch reported by the plugin.
+// The base is provided in the base field of the request options
+func (p *Plugin) ListOpen(ctx context.Context, base string) ([]*PullRequestStatus, error) {
+	response, err := p.call(ctx, PluginRequest{Action: PluginActionList, Options: &PullRequestOptions{Base: base}})
+	if err != nil {
+		return nil, err
+	}
+	if response.PullRequests == nil {
+		return []*PullRequestStatus{}, nil
+	}
+	return response.PullRequests, nil
+}
+
+// Close asks the plugin to close the pull request, leaving comment when not empty
+func (p *Plugin) Close(ctx context.Context, pr *PullRequest, comment string) error {
+	_, err := p.call(ctx, PluginRequest{Action: PluginActionClose, PullRequest: pr, Comment: comment})
+	return err
+}
+
+// Retarget asks the plugin to change the base branch of the pull request.
+// The base is provided in the base field of the request options
+func (p *Plugin) Retarget(ctx context.Context, pr *PullRequest, base string) error {
+	_, err := p.call(ctx, PluginRequest{Action: PluginActionRetarget, PullRequest: pr, Options: &PullRequestOptions{Base: base}})
+	return err
+}
+
+// Merge asks the plugin to merge the pull request using method
+func (p *Plugin) Merge(ctx context.Context, pr *PullRequest, method string) error {
+	_, err := p.call(ctx, PluginRequest{Action: PluginActionMerge, PullRequest: pr, MergeMethod: method})
+	return err
+}
+
+// DefaultBranch returns the default branch reported by the plugin, or an empty string on failure
+func (p *Plugin) DefaultBranch(ctx context.Context) string {
+	response, err := p.call(ctx, PluginRequest{Action: PluginActionDefaultBranch})
+	if err != nil {
+		return ""
+	}
+	return response.Branch
+}
+
+// LinkedTopicIssues returns the URL reported by the plugin, or an empty string on failure
+func (p *Plugin) LinkedTopicIssues(topicSearchString string) string {
+	response, err := p.call(context.Background(), PluginRequest{Action: PluginActionTopicLink, Topic: topicSearchString})
+	if err != nil {
+		return ""
+	}
+	return response.URL
+}
+
+// NewPluginUpserter instanciates a PullRequester backed by the git-review-backend-<name> executable
+func NewPluginUpserter(ctx context.Context, name string, endpoint *transport.Endpoint) (*Plugin, error) {
+	path, err := exec.LookPath(PluginPrefix + name)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("plugin", PluginPrefix+name).Debug("backend plugin not found")
+		return nil, err
+	}
+	return &Plugin{
+		Name: name,
+		Path: path,
+		Remote: PluginRemote{
+			URL:      endpoint.String(),
+			Protocol: endpoint.Protocol,
+			Host:     endpoint.Host,
+			Port:     endpoint.Port,
+			Path:     endpoint.Path,
+		},
+	}, nil
+}
+
+// pluginBackend returns the backend driving the git-review-backend-<name> plugin, when installed
+func pluginBackend(name string) (Backend, bool) {
+	if _, err := exec.LookPath(PluginPrefix + name); err != nil {
+		return Backend{}, false
+	}
+	return Backend{
+		Name: name,
+		New: func(ctx context.Context, endpoint *transport.Endpoint, options BackendOptions) (PullRequester, error) {
+			return NewPluginUpserter(ctx, name, endpoint)
+		},
+	}, true
+}
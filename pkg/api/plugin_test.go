@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// installFakePlugin builds the reference git-review-backend-fake helper and puts it in the PATH.
+// It returns the path of the file holding the fake backend state
+func installFakePlugin(t *testing.T) string {
+	t.Helper()
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain is required to build the fake backend plugin")
+	}
+	dir := t.TempDir()
+	out, err := exec.Command(goBin, "build", "-o", filepath.Join(dir, PluginPrefix+"fake"), "./testdata/git-review-backend-fake").CombinedOutput()
+	require.NoError(t, err, string(out))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	state := filepath.Join(dir, "state.json")
+	t.Setenv("GIT_REVIEW_BACKEND_FAKE_STATE", state)
+	return state
+}
+
+func TestPlugin(t *testing.T) {
+	state := installFakePlugin(t)
+	require.NoError(t, os.WriteFile(state, []byte(`{"defaultBranch": "trunk"}`), 0o644))
+
+	endpoint, err := transport.NewEndpoint("https://git.example.com/org/repo.git")
+	require.NoError(t, err)
+	p, err := NewPluginUpserter(context.Background(), "fake", endpoint)
+	require.NoError(t, err)
+	assert.Equal(t, "git.example.com", p.Remote.Host)
+	assert.Equal(t, "/org/repo.git", p.Remote.Path)
+
+	pr, created, err := p.Ensure(context.Background(), PullRequestOptions{Base: "trunk", Head: "maiao.I1", Title: "change", WIP: true})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, &PullRequest{ID: "1", URL: "https://git.example.com/org/repo.git/pulls/1"}, pr)
+
+	pr, created, err = p.Ensure(context.Background(), PullRequestOptions{Base: "trunk", Head: "maiao.I1", Title: "change"})
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "1", pr.ID)
+
+	assert.True(t, p.SupportsAutoMerge())
+	updated, err := p.Update(context.Background(), pr, PullRequestOptions{Base: "maiao.I0", Head: "maiao.I1", Title: "new title", Ready: true, AutoMerge: MergeMethodSquash})
+	require.NoError(t, err)
+	assert.Equal(t, "1", updated.ID)
+
+	b, err := os.ReadFile(state)
+	require.NoError(t, err)
+	stored := struct {
+		PullRequests []struct {
+			Base      string `json:"base"`
+			Title     string `json:"title"`
+			WIP       bool   `json:"wip"`
+			AutoMerge string `json:"autoMerge"`
+		} `json:"pullRequests"`
+	}{}
+	require.NoError(t, json.Unmarshal(b, &stored))
+	require.Len(t, stored.PullRequests, 1)
+	assert.Equal(t, "maiao.I0", stored.PullRequests[0].Base)
+	assert.Equal(t, "new title", stored.PullRequests[0].Title)
+	assert.False(t, stored.PullRequests[0].WIP)
+	assert.Equal(t, MergeMethodSquash, stored.PullRequests[0].AutoMerge)
+
+	status, err := p.Find(context.Background(), "maiao.I1")
+	require.NoError(t, err)
+	assert.Equal(t, &PullRequestStatus{PullRequest: *updated, Base: "maiao.I0", Head: "maiao.I1", Title: "new title", State: PullRequestStateOpen}, status)
+	status, err = p.Find(context.Background(), "maiao.I2")
+	require.NoError(t, err)
+	assert.Nil(t, status)
+
+	t.Run("pull requests are managed by their number and base", func(t *testing.T) {
+		child, _, err := p.Ensure(context.Background(), PullRequestOptions{Base: "maiao.I1", Head: "maiao.I2", Title: "child"})
+		require.NoError(t, err)
+
+		status, err := p.Get(context.Background(), "1")
+		require.NoError(t, err)
+		assert.Equal(t, "maiao.I1", status.Head)
+		_, err = p.Get(context.Background(), "42")
+		assert.Error(t, err)
+
+		children, err := p.ListOpen(context.Background(), "maiao.I1")
+		require.NoError(t, err)
+		require.Len(t, children, 1)
+		assert.Equal(t, child.ID, children[0].ID)
+
+		require.NoError(t, p.Retarget(context.Background(), child, "maiao.I0"))
+		require.NoError(t, p.Close(context.Background(), updated, "superseded"))
+		status, err = p.Find(context.Background(), "maiao.I1")
+		require.NoError(t, err)
+		assert.Equal(t, PullRequestStateClosed, status.State)
+		children, err = p.ListOpen(context.Background(), "maiao.I0")
+		require.NoError(t, err)
+		require.Len(t, children, 1)
+		assert.Equal(t, child.ID, children[0].ID)
+
+		require.NoError(t, p.Merge(context.Background(), child, MergeMethodSquash))
+		status, err = p.Find(context.Background(), "maiao.I2")
+		require.NoError(t, err)
+		assert.Equal(t, PullRequestStateMerged, status.State)
+	})
+
+	assert.Equal(t, "trunk", p.DefaultBranch(context.Background()))
+	assert.Equal(t, "https://git.example.com/search?q=topic-sha", p.LinkedTopicIssues("topic-sha"))
+
+	t.Run("errors reported by the plugin are returned", func(t *testing.T) {
+		_, err := p.Update(context.Background(), &PullRequest{ID: "42"}, PullRequestOptions{})
+		require.Error(t, err)
+		assert.Equal(t, "git-review-backend-fake update: pull request 42 not found", err.Error())
+	})
+
+	t.Run("plugin failures are returned", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(state, []byte(`not json`), 0o644))
+		_, _, err := p.Ensure(context.Background(), PullRequestOptions{Head: "maiao.I2"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unable to load state")
+		assert.Equal(t, "", p.DefaultBranch(context.Background()))
+	})
+}
+
+func TestPluginWithoutOptionalActions(t *testing.T) {
+	dir := t.TempDir()
+	// a plugin implementing only the actions required to submit a stack, failing on any other
+	script := "#!/bin/sh\ncat > /dev/null\ncase \"$1\" in\n" +
+		"ensure) echo '{\"pullRequest\": {\"id\": \"1\", \"url\": \"https://git.example.com/pulls/1\"}, \"created\": true}' ;;\n" +
+		"*) echo \"unsupported action $1\" >&2; exit 1 ;;\nesac\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, PluginPrefix+"minimal"), []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	endpoint, err := transport.NewEndpoint("https://git.example.com/org/repo.git")
+	require.NoError(t, err)
+	p, err := NewPluginUpserter(context.Background(), "minimal", endpoint)
+	require.NoError(t, err)
+
+	status, err := p.Find(context.Background(), "maiao.I1")
+	require.NoError(t, err, "plugins without the find capability must not fail reviews")
+	assert.Nil(t, status)
+	assert.False(t, p.SupportsAutoMerge(), "auto-merge must not be silently ignored by plugins not declaring it")
+	pr, created, err := p.Ensure(context.Background(), PullRequestOptions{Base: "main", Head: "maiao.I1", Title: "change"})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "1", pr.ID)
+}
+
+func TestPluginBackendSelection(t *testing.T) {
+	installFakePlugin(t)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	pr, err := NewPullRequester(context.Background(), testRemote(t, "git@git.example.com:org/repo.git"), BackendOptions{Config: testConfig(t, "git.example.com", "fake")})
+	require.NoError(t, err)
+	require.IsType(t, &Plugin{}, pr)
+	assert.Equal(t, "fake", pr.(*Plugin).Name)
+
+	t.Run("missing plugins are reported as unknown forges", func(t *testing.T) {
+		_, err := NewPullRequester(context.Background(), testRemote(t, "git@git.example.com:org/repo.git"), BackendOptions{Config: testConfig(t, "git.example.com", "missing")})
+		assert.IsType(t, &UnknownBackendError{}, err)
+	})
+}
@@ -2,10 +2,14 @@ package api
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"strings"
 
+	"github.com/adevinta/maiao/pkg/credentials"
+	lgit "github.com/adevinta/maiao/pkg/git"
 	"github.com/adevinta/maiao/pkg/log"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/sirupsen/logrus"
 )
@@ -18,38 +22,203 @@ type PullRequester interface {
 	Ensure(context.Context, PullRequestOptions) (*PullRequest, bool, error)
 	LinkedTopicIssues(topicSearchString string) string
 	DefaultBranch(context.Context) string
+	// Find returns the current status of the most recent pull request opened for head, or nil when none exists.
+	// Find never changes anything on the remote
+	Find(ctx context.Context, head string) (*PullRequestStatus, error)
+}
+
+// ChangePusher is implemented by review systems where reviews are created by pushing
+// commits to a magic ref, like Gerrit's refs/for/<branch>, rather than by opening
+// pull requests between branches
+type ChangePusher interface {
+	// PushRef returns the ref the stack must be pushed to for review
+	PushRef(PushOptions) string
+	// FindChange returns the review of a given change ID targeting branch, or nil when none exists
+	FindChange(ctx context.Context, changeID, branch string) (*PullRequest, error)
+}
+
+// PullRequestManager is implemented by backends able to manage pull requests beyond creating and updating them,
+// allowing to abandon changes
+type PullRequestManager interface {
+	// Get returns the current status of the pull request identified by id
+	Get(ctx context.Context, id string) (*PullRequestStatus, error)
+	// ListOpen returns the open pull requests targeting the base branch
+	ListOpen(ctx context.Context, base string) ([]*PullRequestStatus, error)
+	// Close closes the pull request without merging it, after adding comment to it when not empty
+	Close(ctx context.Context, pr *PullRequest, comment string) error
+	// Retarget changes the base branch of the pull request, leaving its title and description untouched
+	Retarget(ctx context.Context, pr *PullRequest, base string) error
+}
+
+// PullRequestMerger is implemented by backends able to merge pull requests, allowing to land changes
+type PullRequestMerger interface {
+	// Merge merges the pull request into its base branch using method, one of the MergeMethod* values
+	Merge(ctx context.Context, pr *PullRequest, method string) error
+}
+
+// AutoMerger is implemented by backends honouring PullRequestOptions.AutoMerge, the others ignore it
+type AutoMerger interface {
+	// SupportsAutoMerge tells whether auto-merge can be enabled on the pull requests created and updated by the backend
+	SupportsAutoMerge() bool
+}
+
+// ForkSupporter is implemented by backends able to open pull requests from the branches of forks,
+// given as <owner>:<branch> heads. Other backends only handle branches of the reviewed repository
+type ForkSupporter interface {
+	// SupportsForks tells whether pull requests can be opened from the branches of forks
+	SupportsForks() bool
+}
+
+// BranchKeeper is implemented by backends which do not need the branches of the changes on the remote,
+// like the local backend rehearsing reviews. Those branches are only kept in the remote tracking branches of the repository
+type BranchKeeper interface {
+	// KeepsBranchesLocal tells whether the branches of the changes must not be written to the remote
+	KeepsBranchesLocal() bool
+}
+
+// Methods to merge pull requests with, as accepted by PullRequestMerger
+const (
+	MergeMethodMerge  = "merge"
+	MergeMethodSquash = "squash"
+	MergeMethodRebase = "rebase"
+)
+
+// PushOptions are the options available when pushing changes for review
+type PushOptions struct {
+	Branch string
+	Topic  string
+	WIP    bool
+	Ready  bool
 }
 
 // PullRequestOptions are the options available to create or update a pull request
 type PullRequestOptions struct {
-	Base  string
-	Head  string
-	Title string
-	Body  string
-	WIP   bool
-	Ready bool
+	Base string `json:"base"`
+	// Head is the branch of the pull request, or <owner>:<branch> when it lives in a fork
+	Head  string `json:"head"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	WIP   bool   `json:"wip"`
+	Ready bool   `json:"ready"`
+	// AutoMerge enables auto-merge on the pull request with this method, one of the MergeMethod* values.
+	// It is left empty to leave auto-merge untouched
+	AutoMerge string `json:"autoMerge,omitempty"`
 }
 
 // PullRequest defines the object
 type PullRequest struct {
-	ID  string
-	URL string
+	ID  string `json:"id"`
+	URL string `json:"url"`
 }
 
-func NewPullRequester(ctx context.Context, remote *git.Remote) (PullRequester, error) {
+// States of a pull request, as reported in PullRequestStatus
+const (
+	PullRequestStateOpen   = "open"
+	PullRequestStateDraft  = "draft"
+	PullRequestStateMerged = "merged"
+	PullRequestStateClosed = "closed"
+)
+
+// Review decisions, as reported in PullRequestStatus
+const (
+	ReviewDecisionApproved         = "approved"
+	ReviewDecisionChangesRequested = "changes_requested"
+	ReviewDecisionRequired         = "review_required"
+)
+
+// States of the continuous integration checks, as reported in PullRequestStatus
+const (
+	ChecksSuccess = "success"
+	ChecksFailure = "failure"
+	ChecksPending = "pending"
+)
+
+// PullRequestStatus describes the current state of a pull request
+type PullRequestStatus struct {
+	PullRequest
+	Base string `json:"base"`
+	Head string `json:"head"`
+	// Title is the title of the pull request, as shown by the forge
+	Title string `json:"title,omitempty"`
+	// State is one of the PullRequestState* values
+	State string `json:"state"`
+	// ReviewDecision is one of the ReviewDecision* values, or empty when the forge does not report it
+	ReviewDecision string `json:"reviewDecision,omitempty"`
+	// Checks is the rollup of the continuous integration checks of the head commit,
+	// one of the Checks* values, or empty when there are no checks
+	Checks string `json:"checks,omitempty"`
+	// Body is the description of the pull request
+	Body string `json:"body,omitempty"`
+}
+
+// splitHead splits a pull request head into the owner of the fork it lives in, and the branch name.
+// Heads of the form <owner>:<branch> designate branches of forks, the owner is empty otherwise
+func splitHead(head string) (string, string) {
+	if i := strings.Index(head, ":"); i >= 0 {
+		return head[:i], head[i+1:]
+	}
+	return "", head
+}
+
+// BackendOptions are the options used to select and instanciate the pull request backend of a remote
+type BackendOptions struct {
+	// Config is the repository git configuration, used to find the forge configured for the remote host
+	Config *config.Config
+	// Backend forces the backend to use, regardless of the git configuration
+	Backend string
+	// GitDir is the git directory of the repository, for backends keeping their state locally
+	GitDir string
+	// HostAlias is the ssh host alias of the remote, as written in its URL, when it is resolved to another host name
+	// through ~/.ssh/config. Its maiao.<alias>.* git configuration takes precedence over the one of the host
+	HostAlias string
+	// Credentials authenticate the requests to the forge API, the default credential store is used when nil
+	Credentials credentials.CredentialGetter
+}
+
+// NewPullRequester instanciates the pull requester for the remote.
+// The backend is the one forced in options or selected from the maiao.<host>.forge git configuration when set,
+// otherwise the backends matching the host name are tried first, then all others
+func NewPullRequester(ctx context.Context, remote *git.Remote, options BackendOptions) (PullRequester, error) {
+	var lastErr error
 	for _, u := range remote.Config().URLs {
 		ctx := log.WithContextFields(ctx, logrus.Fields{"remote-url": u})
 		endpoint, err := transport.NewEndpoint(u)
 		if err != nil {
 			log.ForContext(ctx).WithError(err).Errorf("failed to parse remote")
+			lastErr = err
 			continue
 		}
-		r, err := NewGitHubUpserter(ctx, endpoint)
+		alias := endpoint.Host
+		endpoint = lgit.ResolveSSHEndpoint(endpoint)
+		if endpoint.Host != alias {
+			ctx = log.WithContextFields(ctx, logrus.Fields{"ssh-alias": alias, "host": endpoint.Host})
+			log.ForContext(ctx).Debug("resolved ssh host alias")
+		}
+		options := options
+		options.HostAlias = ""
+		if endpoint.Host != alias {
+			options.HostAlias = alias
+		}
+		candidates, err := candidateBackends(options, endpoint.Host)
 		if err != nil {
-			log.ForContext(ctx).WithError(err).Errorf("failed to instanciate github client")
+			log.ForContext(ctx).WithError(err).Errorf("failed to select backend")
+			lastErr = err
 			continue
 		}
-		return r, nil
+		tried := []string{}
+		for _, backend := range candidates {
+			r, err := backend.New(ctx, endpoint, options)
+			if err == nil {
+				log.ForContext(ctx).WithField("forge", backend.Name).Debug("using backend")
+				return r, nil
+			}
+			tried = append(tried, backend.Name)
+			log.ForContext(ctx).WithError(err).Debugf("failed to instanciate %s client", backend.Name)
+		}
+		lastErr = &NoBackendError{Host: endpoint.Host, Tried: tried}
+	}
+	if lastErr == nil {
+		return nil, fmt.Errorf("remote %s has no URL", remote.Config().Name)
 	}
-	return nil, errors.New("not implemented")
+	return nil, lastErr
 }
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/adevinta/maiao/pkg/credentials"
+	gh "github.com/adevinta/maiao/pkg/github"
+	"github.com/adevinta/maiao/pkg/log"
+	"golang.org/x/oauth2"
+)
+
+// restClient is a minimal JSON client for the forges REST APIs
+// for which no dedicated go client is used
+type restClient struct {
+	Client  *http.Client
+	BaseURL *url.URL
+	// ResponsePrefix is stripped from responses before decoding them,
+	// for APIs protecting their JSON against cross site script inclusion
+	ResponsePrefix string
+}
+
+// RESTError is returned when a forge REST API answers with an unexpected status code
+type RESTError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+// Error implements the error interface
+func (e *RESTError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status code %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+func isNotFound(err error) bool {
+	restErr, ok := err.(*RESTError)
+	return ok && restErr.StatusCode == http.StatusNotFound
+}
+
+func (c *restClient) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	u, err := c.BaseURL.Parse(path)
+	if err != nil {
+		return err
+	}
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	var body io.Reader
+	if in != nil {
+		b := &bytes.Buffer{}
+		err = json.NewEncoder(b).Encode(in)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	log.ForContext(ctx).WithField("method", method).WithField("url", u.String()).Trace("calling REST API")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &RESTError{Method: method, URL: u.String(), StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if out == nil {
+		return nil
+	}
+	reader := bufio.NewReader(resp.Body)
+	if c.ResponsePrefix != "" {
+		prefix, err := reader.Peek(len(c.ResponsePrefix))
+		if err == nil && string(prefix) == c.ResponsePrefix {
+			reader.Discard(len(c.ResponsePrefix))
+		}
+	}
+	return json.NewDecoder(reader).Decode(out)
+}
+
+// newTokenHTTPClient returns an http client authenticating with a bearer token.
+// The token is taken from the tokenEnv environment variable when set, or from
+// getter for host otherwise, the default credential getter when nil
+func newTokenHTTPClient(ctx context.Context, getter credentials.CredentialGetter, host, tokenEnv string) (*http.Client, error) {
+	if getter == nil {
+		getter = gh.DefaultCredentialGetter
+	}
+	creds, err := credentials.ChainCredentialGetter{
+		&credentials.EnvToken{PasswordKey: tokenEnv},
+		getter,
+	}.CredentialForHost(host)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("host", host).Errorf("unable to find token")
+		return nil, fmt.Errorf("unable to find token for %s: %s", host, err.Error())
+	}
+	token := creds.Password
+	if token == "" {
+		token = creds.Username
+	}
+	if token == "" {
+		return nil, fmt.Errorf("unable to find a token for domain %s", host)
+	}
+	return oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})), nil
+}
+
+// newBasicAuthHTTPClient returns an http client authenticating with the username and password
+// found in getter for host, the default credential getter when nil.
+// When no credentials are found, a nil client is returned.
+func newBasicAuthHTTPClient(ctx context.Context, getter credentials.CredentialGetter, host string) *http.Client {
+	if getter == nil {
+		getter = gh.DefaultCredentialGetter
+	}
+	creds, err := getter.CredentialForHost(host)
+	if err != nil || creds == nil || creds.Password == "" {
+		log.ForContext(ctx).WithError(err).WithField("host", host).Debug("no credentials found, using anonymous access")
+		return nil
+	}
+	return &http.Client{Transport: &basicAuthTransport{username: creds.Username, password: creds.Password}}
+}
+
+type basicAuthTransport struct {
+	username string
+	password string
+	base     http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *basicAuthTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.SetBasicAuth(t.username, t.password)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
@@ -0,0 +1,251 @@
+// git-review-backend-fake is a reference implementation of the maiao backend plugin protocol.
+//
+// It is invoked as `git-review-backend-fake <action>`, reads a JSON request on its standard input
+// and writes a JSON response on its standard output. Pull requests are kept in the JSON file
+// pointed by the GIT_REVIEW_BACKEND_FAKE_STATE environment variable.
+//
+// Only the standard library is used, so it can be copied as a starting point for new backends.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+type remote struct {
+	URL      string `json:"url"`
+	Protocol string `json:"protocol"`
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"`
+	Path     string `json:"path"`
+}
+
+type pullRequestOptions struct {
+	Base  string `json:"base"`
+	Head  string `json:"head"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	WIP   bool   `json:"wip"`
+	Ready bool   `json:"ready"`
+	// AutoMerge is recorded as is, as the merge method to use once the pull request is approved
+	AutoMerge string `json:"autoMerge,omitempty"`
+}
+
+type pullRequest struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type request struct {
+	Action      string              `json:"action"`
+	Remote      remote              `json:"remote"`
+	Options     *pullRequestOptions `json:"options,omitempty"`
+	PullRequest *pullRequest        `json:"pullRequest,omitempty"`
+	Topic       string              `json:"topic,omitempty"`
+	Comment     string              `json:"comment,omitempty"`
+	MergeMethod string              `json:"mergeMethod,omitempty"`
+}
+
+type pullRequestStatus struct {
+	pullRequest
+	Base  string `json:"base"`
+	Head  string `json:"head"`
+	Title string `json:"title,omitempty"`
+	State string `json:"state"`
+	Body  string `json:"body,omitempty"`
+}
+
+type response struct {
+	PullRequest  *pullRequest         `json:"pullRequest,omitempty"`
+	Created      bool                 `json:"created,omitempty"`
+	Status       *pullRequestStatus   `json:"status,omitempty"`
+	PullRequests []*pullRequestStatus `json:"pullRequests,omitempty"`
+	Branch       string               `json:"branch,omitempty"`
+	URL          string               `json:"url,omitempty"`
+	Error        string               `json:"error,omitempty"`
+	Capabilities []string             `json:"capabilities,omitempty"`
+}
+
+type storedPullRequest struct {
+	pullRequestOptions
+	ID         int      `json:"id"`
+	Closed     bool     `json:"closed,omitempty"`
+	MergedWith string   `json:"mergedWith,omitempty"`
+	Comments   []string `json:"comments,omitempty"`
+}
+
+type state struct {
+	DefaultBranch string               `json:"defaultBranch"`
+	PullRequests  []*storedPullRequest `json:"pullRequests"`
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: git-review-backend-fake <action>")
+		os.Exit(2)
+	}
+	req := request{}
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid request:", err)
+		os.Exit(1)
+	}
+	if req.Action != os.Args[1] {
+		fmt.Fprintf(os.Stderr, "action mismatch: %s != %s\n", req.Action, os.Args[1])
+		os.Exit(1)
+	}
+	path := os.Getenv("GIT_REVIEW_BACKEND_FAKE_STATE")
+	s, err := load(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to load state:", err)
+		os.Exit(1)
+	}
+	resp := handle(s, req)
+	if err := save(path, s); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to save state:", err)
+		os.Exit(1)
+	}
+	json.NewEncoder(os.Stdout).Encode(resp)
+}
+
+func handle(s *state, req request) response {
+	url := func(pr *storedPullRequest) string {
+		return fmt.Sprintf("https://%s%s/pulls/%d", req.Remote.Host, req.Remote.Path, pr.ID)
+	}
+	status := func(pr *storedPullRequest) *pullRequestStatus {
+		state := "open"
+		switch {
+		case pr.MergedWith != "":
+			state = "merged"
+		case pr.Closed:
+			state = "closed"
+		case pr.WIP:
+			state = "draft"
+		}
+		return &pullRequestStatus{
+			pullRequest: pullRequest{ID: strconv.Itoa(pr.ID), URL: url(pr)},
+			Base:        pr.Base,
+			Head:        pr.Head,
+			Title:       pr.Title,
+			State:       state,
+			Body:        pr.Body,
+		}
+	}
+	byID := func() *storedPullRequest {
+		if req.PullRequest == nil {
+			return nil
+		}
+		for _, pr := range s.PullRequests {
+			if strconv.Itoa(pr.ID) == req.PullRequest.ID {
+				return pr
+			}
+		}
+		return nil
+	}
+	switch req.Action {
+	case "ensure":
+		if req.Options == nil {
+			return response{Error: "missing options"}
+		}
+		for _, pr := range s.PullRequests {
+			if pr.Head == req.Options.Head && !pr.Closed {
+				return response{PullRequest: &pullRequest{ID: strconv.Itoa(pr.ID), URL: url(pr)}}
+			}
+		}
+		pr := &storedPullRequest{pullRequestOptions: *req.Options, ID: len(s.PullRequests) + 1}
+		s.PullRequests = append(s.PullRequests, pr)
+		return response{PullRequest: &pullRequest{ID: strconv.Itoa(pr.ID), URL: url(pr)}, Created: true}
+	case "update":
+		if req.Options == nil || req.PullRequest == nil {
+			return response{Error: "missing options or pull request"}
+		}
+		for _, pr := range s.PullRequests {
+			if strconv.Itoa(pr.ID) == req.PullRequest.ID {
+				wip := pr.WIP && !req.Options.Ready
+				pr.pullRequestOptions = *req.Options
+				pr.WIP = wip
+				return response{PullRequest: &pullRequest{ID: strconv.Itoa(pr.ID), URL: url(pr)}}
+			}
+		}
+		return response{Error: "pull request " + req.PullRequest.ID + " not found"}
+	case "find":
+		if req.Options == nil {
+			return response{Error: "missing options"}
+		}
+		for i := len(s.PullRequests) - 1; i >= 0; i-- {
+			if s.PullRequests[i].Head == req.Options.Head {
+				return response{Status: status(s.PullRequests[i])}
+			}
+		}
+		return response{}
+	case "get":
+		if pr := byID(); pr != nil {
+			return response{Status: status(pr)}
+		}
+		return response{}
+	case "list":
+		if req.Options == nil {
+			return response{Error: "missing options"}
+		}
+		resp := response{}
+		for _, pr := range s.PullRequests {
+			if pr.Base == req.Options.Base && !pr.Closed {
+				resp.PullRequests = append(resp.PullRequests, status(pr))
+			}
+		}
+		return resp
+	case "close":
+		pr := byID()
+		if pr == nil {
+			return response{Error: "pull request not found"}
+		}
+		if req.Comment != "" {
+			pr.Comments = append(pr.Comments, req.Comment)
+		}
+		pr.Closed = true
+		return response{}
+	case "merge":
+		pr := byID()
+		if pr == nil || req.MergeMethod == "" {
+			return response{Error: "missing merge method or pull request"}
+		}
+		pr.MergedWith = req.MergeMethod
+		pr.Closed = true
+		return response{}
+	case "retarget":
+		pr := byID()
+		if pr == nil || req.Options == nil {
+			return response{Error: "missing options or pull request"}
+		}
+		pr.Base = req.Options.Base
+		return response{}
+	case "capabilities":
+		return response{Capabilities: []string{"find", "auto-merge"}}
+	case "default-branch":
+		return response{Branch: s.DefaultBranch}
+	case "topic-link":
+		return response{URL: fmt.Sprintf("https://%s/search?q=%s", req.Remote.Host, req.Topic)}
+	}
+	return response{Error: "unsupported action " + req.Action}
+}
+
+func load(path string) (*state, error) {
+	s := &state{}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, json.Unmarshal(b, s)
+}
+
+func save(path string, s *state) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
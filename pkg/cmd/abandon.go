@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/adevinta/maiao/pkg/maiao"
+	"github.com/go-git/go-git/v5"
+	"github.com/spf13/cobra"
+)
+
+func abandon(cmd *cobra.Command, args []string) error {
+	repo, err := git.PlainOpenWithOptions(cmd.Flag("path").Value.String(), &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return err
+	}
+	return maiao.Abandon(context.Background(), repo, maiao.ReviewOptions{
+		Remote:     cmd.Flag("remote").Value.String(),
+		Backend:    cmd.Flag("backend").Value.String(),
+		PushRemote: cmd.Flag("push-remote").Value.String(),
+		DryRun:     cmd.Flag("dry-run").Value.String() != "false",
+	}, args[0], cmd.Flag("message").Value.String())
+}
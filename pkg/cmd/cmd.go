@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,13 +16,37 @@ import (
 	"github.com/spf13/cobra"
 )
 
-const (
-	changeIDEditorHelp = `Reproduces git interactions a human should do to add Change-Ids to commits
-	Used as 'env GIT_EDITOR="git review add-change-id-editor" git rebase -i origin/master'
-	This command selects changes all pickups to rewords and keeps the message intact for the
-	Change-Id hook to be ran
-	`
-)
+// resumedKey marks the context of the commands resumed by the rebase of the stack, holding the arguments
+// of the command that started the rebase
+type resumedKey struct{}
+
+// resumedArgs returns the arguments of the command that started the rebase the command runs as the last step of,
+// nil when it does not run from a rebase
+func resumedArgs(cmd *cobra.Command) []string {
+	if cmd.Context() == nil {
+		return nil
+	}
+	args, _ := cmd.Context().Value(resumedKey{}).([]string)
+	return args
+}
+
+// resumedAfterRebase tells whether the command runs as the last step of the rebase of the stack
+func resumedAfterRebase(cmd *cobra.Command) bool {
+	return resumedArgs(cmd) != nil
+}
+
+// gitExecutor returns the executor rebasing stacks, the git command line when asked with --git-rebase
+// and the default in-process one otherwise
+func gitExecutor(cmd *cobra.Command) git.Executor {
+	if cmd.Flag("git-rebase").Value.String() != "false" {
+		// the commands resumed by a rebase run without arguments, the next rebases must resume the original command
+		if args := resumedArgs(cmd); args != nil {
+			return git.CLIExecutor{Command: append([]string{os.Args[0]}, args...)}
+		}
+		return git.CLIExecutor{}
+	}
+	return nil
+}
 
 // NewCommand implements a new cobra command to run git-review
 func NewCommand() *cobra.Command {
@@ -66,14 +91,28 @@ func NewCommand() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			err = rootCmd.ParseFlags(originalArgs)
+			// subcommands rebasing the stack, like land, resume with a review of the rebased stack
+			resumed, flags, err := rootCmd.Find(originalArgs)
 			if err != nil {
 				return err
 			}
+			err = resumed.ParseFlags(flags)
+			if err != nil {
+				return err
+			}
+			ctx := context.WithValue(cmd.Context(), resumedKey{}, originalArgs)
+			// the target branch must be kept, along with the flags
+			rootCmd.SetArgs(resumed.Flags().Args())
+			rootCmd.SetContext(ctx)
 			err = rootCmd.Execute()
 			if err != nil {
 				return err
 			}
+			if resumed.Name() == "land" && resumed.Flag("all").Value.String() != "false" {
+				// the remaining changes are submitted again, landing goes on with the next ones
+				resumed.SetContext(ctx)
+				return land(resumed, resumed.Flags().Args())
+			}
 			return nil
 		}
 		return review(cmd, args)
@@ -87,13 +126,92 @@ func NewCommand() *cobra.Command {
 	rootCmd.PersistentFlags().String("remote", "", "Specifies the remote the review should be done on. By default the tracking remote of the target branch is used")
 	rootCmd.PersistentFlags().BoolP("work-in-progress", "w", false, "Mark the review as work in progress, or draft in compatible remotes. This flag is exclusively effective when creating Pull Requests")
 	rootCmd.PersistentFlags().BoolP("ready", "W", false, "Mark the review as ready in compatible remotes (i.e. removing the work in progress or draft flag)")
+	rootCmd.PersistentFlags().String("backend", "", "The pull request backend to use (github, gitlab, gitea, bitbucket, gerrit, local or any git-review-backend-<name> plugin). By default it is detected from the remote")
+	rootCmd.PersistentFlags().Bool("close-removed", false, "Close, without asking for confirmation, the pull requests of changes removed from the stack since they were last submitted")
+	rootCmd.PersistentFlags().String("auto-merge", "", "Enable auto-merge, with the given method (merge, squash or rebase), on the pull requests targeting the review branch. Pull requests are added to the merge queue when the branch requires it. Only supported on GitHub and backend plugins declaring it")
+	rootCmd.PersistentFlags().Lookup("auto-merge").NoOptDefVal = "merge"
+	rootCmd.PersistentFlags().Bool("auto-merge-stacked", false, "Enable auto-merge on the pull requests stacked on other changes as well, merging them in their parent branch")
+	rootCmd.PersistentFlags().StringP("output", "o", "text", "Format of the review result, text or json. With json, the submitted changes are printed as JSON on stdout and progress messages on stderr")
+	rootCmd.PersistentFlags().Bool("dry-run", false, "Only print the branches that would be pushed or deleted and the pull requests that would be created or updated, without changing anything")
+	rootCmd.PersistentFlags().Bool("git-rebase", false, "Rebase stacks with git rebase -i instead of the built-in rebase, to resolve conflicts with git. The review resumes once the rebase is done")
+	rootCmd.PersistentFlags().String("push-remote", "", "Specifies the remote branches are pushed to, typically a fork, when it differs from the reviewed remote. Only supported on GitHub and Gitea. Defaults to the maiao.pushRemote git configuration")
+	rootCmd.Flags().Bool("continue", false, "Continue the review stopped on a conflicting rebase, once the conflicts are resolved and staged with git add")
+	rootCmd.Flags().Bool("skip", false, "Continue the review stopped on a conflicting rebase without the conflicting commit")
+	rootCmd.Flags().Bool("abort", false, "Stop the review stopped on a conflicting rebase, restoring the branch as it was before the rebase")
+	rootCmd.MarkFlagsMutuallyExclusive("continue", "skip", "abort")
+	abandonCmd := &cobra.Command{
+		Use:   "abandon <change-id|sha|PR#>",
+		Short: "Closes the pull request of a change and deletes its remote branch",
+		Long: `Closes the pull request of a change, identified by its Change-Id, one of its commits or its pull request number,
+and deletes its remote branch. Pull requests stacked on top of the change are retargeted to its base
+so the rest of the stack stays consistent`,
+		Args: cobra.ExactArgs(1),
+		RunE: abandon,
+	}
+	abandonCmd.Flags().StringP("message", "m", "", "Comment to leave on the pull request when closing it")
+	downloadCmd := &cobra.Command{
+		Use:   "download <PR#|change-id>",
+		Short: "Checks out the stack of a change in a new local branch",
+		Long: `Fetches the branch of a change, identified by its pull request number or its Change-Id, and the branches of
+the changes it is stacked on, following the bases of their pull requests down to the target branch.
+The full stack is checked out in a new local branch tracking the target branch`,
+		Args: cobra.ExactArgs(1),
+		RunE: download,
+	}
+	downloadCmd.Flags().StringP("branch", "b", "", "Name of the local branch to create, defaults to review/<PR#>")
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Deletes the remote maiao.* branches whose pull request is merged, closed or missing",
+		Long: `Lists the maiao.* branches of the remote changes are pushed to and deletes the ones whose pull request
+is merged, closed or missing. Only the branches whose last commit is authored by the current user are considered`,
+		Args: cobra.NoArgs,
+		RunE: gc,
+	}
+	gcCmd.Flags().Duration("older-than", 0, "Only delete branches whose last commit is older than this duration, like 720h")
+	landCmd := &cobra.Command{
+		Use:   "land [<targetBranch>]",
+		Short: "Merges the pull request of the lowest change of the stack and restacks the others",
+		Long: `Merges the pull request of the lowest change of the stack, once it is approved and its checks pass.
+The next pull request is retargeted to the target branch, and the remaining changes are rebased on
+the updated target branch and pushed. With --all, the next changes are landed as long as their
+pull requests are approved and their checks pass`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: land,
+	}
+	landCmd.Flags().String("method", "", "Method to merge pull requests with (merge, squash or rebase). Defaults to the maiao.mergeMethod git configuration, or merge")
+	landCmd.Flags().Bool("all", false, "Keep landing the next changes while their pull requests are approved and their checks pass")
+	undoCmd := &cobra.Command{
+		Use:   "undo [<review-id>]",
+		Short: "Restores the branch as it was before the last review",
+		Long: `Restores the branch checked out when the last review started from its refs/maiao/backup/<review-id> reference.
+Each undo goes one review further back, older reviews being listed with git for-each-ref refs/maiao/backup.
+With --remote-changes, the remote branches pushed by the review and the base, title and description of
+the pull requests it updated are restored too, and the pull requests it created are closed`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: undo,
+	}
+	undoCmd.Flags().Bool("remote-changes", false, "Restore the remote branches and the pull requests changed by the review as well")
+	hookCmd := &cobra.Command{
+		Use:   "hook",
+		Short: "Runs the git hooks installed by git review install",
+	}
+	hookCmd.AddCommand(&cobra.Command{
+		Use:   "commit-msg <file>",
+		Short: "Adds a Change-Id to a commit message file",
+		Long: `Adds a Gerrit compatible Change-Id trailer to the commit message file, unless it already has one or
+belongs to a fixup or squash commit. Setting the gerrit.createChangeId git configuration to false disables it`,
+		Args: cobra.ExactArgs(1),
+		RunE: commitMsgHook,
+	})
 	rootCmd.AddCommand(
 		&cobra.Command{
 			Use:   "install",
 			Short: "Installs commit message hook to the repository",
-			Long:  `Installs commit message hook to the repository`,
-			RunE:  install,
+			Long: `Installs the commit message hook adding Change-Ids to commits in the repository.
+The hook runs git review hook commit-msg, so no network access is needed to install it`,
+			RunE: install,
 		},
+		hookCmd,
 		&cobra.Command{
 			Use:   "version",
 			Short: "Installs commit message hook to the repository",
@@ -103,12 +221,19 @@ func NewCommand() *cobra.Command {
 			},
 		},
 		&cobra.Command{
-			Use:    "add-change-id-editor",
-			Short:  "Handles rebase interactive file edition",
-			Long:   changeIDEditorHelp,
-			RunE:   rebaseEditor,
-			Hidden: true,
+			Use:   "status [<targetBranch>]",
+			Short: "Shows the changes to review with the state of their branches and pull requests",
+			Long: `Shows the changes between the target branch and HEAD with, for each of them, whether the remote branch
+is in sync with the local commit and the state, review decision and checks of its pull request.
+Nothing is pushed or updated on the remote`,
+			Args: cobra.MaximumNArgs(1),
+			RunE: status,
 		},
+		abandonCmd,
+		gcCmd,
+		downloadCmd,
+		landCmd,
+		undoCmd,
 	)
 	rootCmd.AddCommand()
 	return rootCmd
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/adevinta/maiao/pkg/maiao"
+	"github.com/go-git/go-git/v5"
+	"github.com/spf13/cobra"
+)
+
+func gc(cmd *cobra.Command, args []string) error {
+	repo, err := git.PlainOpenWithOptions(cmd.Flag("path").Value.String(), &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return err
+	}
+	olderThan, err := time.ParseDuration(cmd.Flag("older-than").Value.String())
+	if err != nil {
+		return err
+	}
+	return maiao.GC(context.Background(), repo, maiao.ReviewOptions{
+		Remote:     cmd.Flag("remote").Value.String(),
+		Backend:    cmd.Flag("backend").Value.String(),
+		PushRemote: cmd.Flag("push-remote").Value.String(),
+		DryRun:     cmd.Flag("dry-run").Value.String() != "false",
+	}, maiao.GCOptions{
+		OlderThan: olderThan,
+	})
+}
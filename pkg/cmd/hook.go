@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"strings"
+	"time"
+
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/adevinta/maiao/pkg/system"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// commitMsgHook adds a Change-Id to the commit message file given by git, unless disabled with gerrit.createChangeId
+func commitMsgHook(cmd *cobra.Command, args []string) error {
+	repo, err := git.PlainOpenWithOptions(cmd.Flag("path").Value.String(), &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(lgit.ConfigOption(cfg, "gerrit", "", "createChangeId")) {
+	case "false", "no", "off", "0":
+		return nil
+	}
+	content, err := afero.ReadFile(system.DefaultFileSystem, args[0])
+	if err != nil {
+		return err
+	}
+	message := lgit.CleanMessage(string(content), lgit.CommentChar(cfg))
+	// the commit is created on top of HEAD, which does not exist yet for the first commit
+	parent := plumbing.ZeroHash
+	if head, err := repo.Head(); err == nil {
+		parent = head.Hash()
+	}
+	changeID := lgit.NewChangeID(lgit.Committer(cfg, time.Now()), parent, string(content))
+	updated := lgit.AddChangeID(message, changeID)
+	if updated == message {
+		return nil
+	}
+	return afero.WriteFile(system.DefaultFileSystem, args[0], []byte(updated), 0644)
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/adevinta/maiao/pkg/maiao"
+	"github.com/go-git/go-git/v5"
+	"github.com/spf13/cobra"
+)
+
+func land(cmd *cobra.Command, args []string) error {
+	repo, err := git.PlainOpenWithOptions(cmd.Flag("path").Value.String(), &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return err
+	}
+	branch := ""
+	if len(args) > 0 {
+		branch = args[0]
+	}
+	reviewer := &maiao.Reviewer{Git: gitExecutor(cmd)}
+	return reviewer.Land(context.Background(), repo, maiao.ReviewOptions{
+		Remote:       cmd.Flag("remote").Value.String(),
+		Branch:       branch,
+		Topic:        cmd.Flag("topic").Value.String(),
+		Backend:      cmd.Flag("backend").Value.String(),
+		PushRemote:   cmd.Flag("push-remote").Value.String(),
+		CloseRemoved: cmd.Flag("close-removed").Value.String() != "false",
+		DryRun:       cmd.Flag("dry-run").Value.String() != "false",
+	}, maiao.LandOptions{
+		Method: cmd.Flag("method").Value.String(),
+		All:    cmd.Flag("all").Value.String() != "false",
+	})
+}
@@ -2,7 +2,11 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/spf13/cobra"
@@ -13,8 +17,11 @@ import (
 )
 
 const (
-	hookMissing          = "commit message hook is missing, do you want to install it automatically?"
-	noAutoInstallHookFmt = "You are missing change ids in your commits. \nPlease install the commit hook by running\n`curl -o .git/hooks/commit-msg %s && chmod +x .git/hooks/commit-msg`"
+	outputText = "text"
+	outputJSON = "json"
+
+	hookMissing       = "commit message hook is missing, do you want to install it automatically?"
+	noAutoInstallHook = "You are missing change ids in your commits. \nPlease install the commit hook by running\n`git review install`"
 )
 
 func review(cmd *cobra.Command, args []string) error {
@@ -31,23 +38,106 @@ func review(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	if !gerrit.Installed(gitDir) {
+	dryRun := cmd.Flag("dry-run").Value.String() != "false"
+	output := cmd.Flag("output").Value.String()
+	// the JSON result is the only thing written to stdout, progress messages go to stderr
+	var out io.Writer = os.Stdout
+	switch output {
+	case outputText:
+	case outputJSON:
+		if dryRun {
+			return errors.New("--dry-run does not support --output=json")
+		}
+		out = os.Stderr
+	default:
+		return fmt.Errorf("unsupported output %s, expecting %s or %s", output, outputText, outputJSON)
+	}
+	reviewer := &maiao.Reviewer{Output: out, Git: gitExecutor(cmd)}
+	if resume := resumeAction(cmd); resume != "" {
+		if dryRun {
+			return fmt.Errorf("--dry-run does not support --%s", resume)
+		}
+		return resumeReview(cmd, reviewer, repo, resume, output)
+	}
+	// dry runs must not change anything, including the hooks
+	if !dryRun && !gerrit.Installed(gitDir) {
 		if prompt.YesNo(hookMissing) {
 			err = gerrit.Install(gitDir)
 			if err != nil {
 				return err
 			}
 		} else {
-			fmt.Println(fmt.Sprintf(noAutoInstallHookFmt, gerrit.HookURL()))
+			fmt.Fprintln(out, noAutoInstallHook)
 			return nil
 		}
 	}
-	return maiao.Review(context.Background(), repo, maiao.ReviewOptions{
-		Remote:         cmd.Flag("remote").Value.String(),
-		SkipRebase:     cmd.Flag("no-rebase").Value.String() != "false",
-		Topic:          cmd.Flag("topic").Value.String(),
-		Branch:         branch,
-		WorkInProgress: cmd.Flag("work-in-progress").Value.String() != "false",
-		Ready:          cmd.Flag("ready").Value.String() != "false",
+	result, err := reviewer.Review(context.Background(), repo, maiao.ReviewOptions{
+		Remote:             cmd.Flag("remote").Value.String(),
+		SkipRebase:         cmd.Flag("no-rebase").Value.String() != "false",
+		Topic:              cmd.Flag("topic").Value.String(),
+		Branch:             branch,
+		WorkInProgress:     cmd.Flag("work-in-progress").Value.String() != "false",
+		Ready:              cmd.Flag("ready").Value.String() != "false",
+		Backend:            cmd.Flag("backend").Value.String(),
+		PushRemote:         cmd.Flag("push-remote").Value.String(),
+		CloseRemoved:       cmd.Flag("close-removed").Value.String() != "false",
+		AutoMerge:          cmd.Flag("auto-merge").Value.String(),
+		AutoMergeStacked:   cmd.Flag("auto-merge-stacked").Value.String() != "false",
+		DryRun:             dryRun,
+		Confirm:            prompt.YesNo,
+		ResumedAfterRebase: resumedAfterRebase(cmd),
 	})
+	if err != nil {
+		return err
+	}
+	if output != outputJSON {
+		return nil
+	}
+	if resumedAfterRebase(cmd) {
+		result.Rebased = true
+	}
+	return printResult(result)
+}
+
+// resumeAction returns the action resuming a review stopped on conflicts, continue, skip or abort,
+// or an empty string when a new review starts
+func resumeAction(cmd *cobra.Command) string {
+	for _, action := range []string{"continue", "skip", "abort"} {
+		if cmd.Flags().Lookup(action) != nil && cmd.Flag(action).Value.String() != "false" {
+			return action
+		}
+	}
+	return ""
+}
+
+// resumeReview continues, skips or aborts the review stopped on a conflicting rebase
+func resumeReview(cmd *cobra.Command, reviewer *maiao.Reviewer, repo *git.Repository, action, output string) error {
+	var result *maiao.ReviewResult
+	var err error
+	switch action {
+	case "continue":
+		result, err = reviewer.Continue(context.Background(), repo)
+	case "skip":
+		result, err = reviewer.Skip(context.Background(), repo)
+	default:
+		return reviewer.Abort(context.Background(), repo)
+	}
+	if err != nil {
+		return err
+	}
+	if output != outputJSON {
+		return nil
+	}
+	return printResult(result)
+}
+
+// printResult prints the result of a review as JSON on stdout
+func printResult(result *maiao.ReviewResult) error {
+	if result.Resumed {
+		// the review resumed once the stack is rebased reports the submitted changes
+		return nil
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
 }
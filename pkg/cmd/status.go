@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/adevinta/maiao/pkg/maiao"
+	"github.com/go-git/go-git/v5"
+	"github.com/spf13/cobra"
+)
+
+func status(cmd *cobra.Command, args []string) error {
+	repo, err := git.PlainOpenWithOptions(cmd.Flag("path").Value.String(), &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return err
+	}
+	branch := ""
+	if len(args) > 0 {
+		branch = args[0]
+	}
+	statuses, err := maiao.Status(context.Background(), repo, maiao.ReviewOptions{
+		Remote:     cmd.Flag("remote").Value.String(),
+		Branch:     branch,
+		Backend:    cmd.Flag("backend").Value.String(),
+		PushRemote: cmd.Flag("push-remote").Value.String(),
+	})
+	if err != nil {
+		return err
+	}
+	if len(statuses) == 0 {
+		fmt.Println("nothing to review")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGE-ID\tSHA\tBRANCH\tPR\tSTATE\tREVIEW\tCHECKS\tTITLE")
+	for _, s := range statuses {
+		changeID := s.ChangeID
+		if changeID == "" {
+			changeID = "-"
+		}
+		branchState := "not pushed"
+		switch {
+		case s.ChangeID == "":
+			branchState = "-"
+		case s.InSync():
+			branchState = "in sync"
+		case s.RemoteSHA != "":
+			branchState = "outdated"
+		}
+		pr, state, review, checks := "-", "-", "-", "-"
+		if s.PullRequest != nil {
+			pr = "#" + s.PullRequest.ID + " " + s.PullRequest.URL
+			state = s.PullRequest.State
+			review = orDash(s.PullRequest.ReviewDecision)
+			checks = orDash(s.PullRequest.Checks)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", changeID, s.SHA[:7], branchState, pr, state, review, checks, s.Title)
+	}
+	return w.Flush()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
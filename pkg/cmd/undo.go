@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/adevinta/maiao/pkg/maiao"
+	"github.com/go-git/go-git/v5"
+	"github.com/spf13/cobra"
+)
+
+func undo(cmd *cobra.Command, args []string) error {
+	repo, err := git.PlainOpenWithOptions(cmd.Flag("path").Value.String(), &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return err
+	}
+	options := maiao.UndoOptions{
+		Remote: cmd.Flag("remote-changes").Value.String() != "false",
+	}
+	if len(args) > 0 {
+		options.ID = args[0]
+	}
+	return maiao.Undo(context.Background(), repo, options)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 
+	lgit "github.com/adevinta/maiao/pkg/git"
 	"github.com/adevinta/maiao/pkg/log"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
@@ -31,7 +32,9 @@ func (a *GitAuth) ClientConfig() (*ssh.ClientConfig, error) {
 	if a.Endpoint == nil {
 		return nil, errors.New("no endpoint found")
 	}
-	sshauthMethod, err := gitssh.DefaultAuthBuilder(a.Endpoint.User)
+	// host aliases may define the user in the ssh configuration
+	endpoint := lgit.ResolveSSHEndpoint(a.Endpoint)
+	sshauthMethod, err := gitssh.DefaultAuthBuilder(endpoint.User)
 	if err != nil {
 		return nil, err
 	}
@@ -2,8 +2,6 @@ package gerrit
 
 import (
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 
@@ -15,14 +13,11 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	gitHubURL         = "https://raw.githubusercontent.com"
-	repo              = "GerritCodeReview/gerrit"
-	commitHash        = "43d985a2a15a7d59d42e19ffd60d41c0de6c3e59"
-	commitMsgHookPath = "gerrit-server/src/main/resources/com/google/gerrit/server/tools/root/hooks/commit-msg"
-)
-
-var commitMsgHookURL = fmt.Sprintf("%s/%s/%s/%s", gitHubURL, repo, commitHash, commitMsgHookPath)
+// commitMsgHook delegates the computation of Change-Ids to git review, so the hook can be installed without network
+const commitMsgHook = `#!/bin/sh
+# Adds a Gerrit compatible Change-Id to commit messages, installed by git review install
+exec git review hook commit-msg "$1"
+`
 
 type Interface interface {
 	Installed() bool
@@ -33,10 +28,6 @@ type Gerrit struct {
 	gitDir string
 }
 
-func HookURL() string {
-	return commitMsgHookURL
-}
-
 // Installed returned wether the gerrit hook message is installed
 func (g *Gerrit) Installed() bool {
 	path := git.HookPath(g.gitDir, git.CommitMsgHook)
@@ -56,15 +47,7 @@ func (g *Gerrit) Install() error {
 	l := log.Logger.WithFields(logrus.Fields{
 		"gitDir":           g.gitDir,
 		"commit-hook path": path,
-		"download-url":     commitMsgHookURL,
 	})
-	l.Debug("downloading commit message hook")
-	r, err := http.Get(commitMsgHookURL)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("failed to download commit message hook from %s", commitMsgHookURL))
-	}
-	defer r.Body.Close()
-	l.Debugf("downloaded commit message hook")
 	d := filepath.Dir(path)
 	s, err := system.DefaultFileSystem.Stat(d)
 	if err != nil {
@@ -87,7 +70,7 @@ func (g *Gerrit) Install() error {
 		return errors.Wrap(err, fmt.Sprintf("failed to create commit message hook file %s", path))
 	}
 	defer fd.Close()
-	_, err = io.Copy(fd, r.Body)
+	_, err = fd.WriteString(commitMsgHook)
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("failed to write commit message hook file %s", path))
 	}
@@ -0,0 +1,143 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	// scissorsLine follows the comment character on the line below which git drops the commit message
+	scissorsLine = " ------------------------ >8 ------------------------"
+	// defaultCommentChar starts the comment lines of commit messages when core.commentChar is not set
+	defaultCommentChar = "#"
+	// emptyTreeHash is the reference the Gerrit hook uses in place of HEAD on the first commit of a repository
+	emptyTreeHash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+)
+
+var (
+	// fixups and squashes, as created by git commit --fixup and --squash, are folded in the commit they fix
+	autosquashTitleRe = regexp.MustCompile(`^[a-z]+! `)
+	trailerRe         = regexp.MustCompile(`^[a-zA-Z0-9_-]+\s*:\s`)
+)
+
+// NewChangeID computes a Gerrit compatible Change-Id, I followed by 40 hexadecimal characters, the same way the
+// Gerrit commit-msg hook does: hashing the identity of the committer, the commit the change is created on
+// and the raw commit message. A zero parent stands for the first commit of the repository
+func NewChangeID(committer object.Signature, parent plumbing.Hash, message string) string {
+	ref := emptyTreeHash
+	if !parent.IsZero() {
+		ref = parent.String()
+	}
+	b := &bytes.Buffer{}
+	committer.Encode(b)
+	fmt.Fprintf(b, "\n%s\n%s", ref, message)
+	return "I" + plumbing.ComputeHash(plumbing.BlobObject, b.Bytes()).String()
+}
+
+// Committer returns the identity git would record as committer at the given time.
+// Like git, the GIT_COMMITTER_NAME and GIT_COMMITTER_EMAIL environment variables take precedence over the configuration
+func Committer(cfg *config.Config, when time.Time) object.Signature {
+	name := os.Getenv("GIT_COMMITTER_NAME")
+	if name == "" {
+		name = ConfigOption(cfg, "user", "", "name")
+	}
+	email := os.Getenv("GIT_COMMITTER_EMAIL")
+	if email == "" {
+		email = ConfigOption(cfg, "user", "", "email")
+	}
+	return object.Signature{Name: name, Email: email, When: when}
+}
+
+// CommentChar returns the core.commentChar git configuration, the prefix of the comment lines of commit messages.
+// It defaults to #, also used when git picks the comment character by itself, with auto
+func CommentChar(cfg *config.Config) string {
+	commentChar := ConfigOption(cfg, "core", "", "commentChar")
+	if commentChar == "" || commentChar == "auto" {
+		return defaultCommentChar
+	}
+	return commentChar
+}
+
+// CleanMessage strips a commit message the way git does before committing it: the comments, starting with commentChar,
+// and everything below the scissors line are removed, as well as trailing spaces, repeated blank lines and surrounding blank lines
+func CleanMessage(message, commentChar string) string {
+	lines := []string{}
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		if line == commentChar+scissorsLine {
+			break
+		}
+		if strings.HasPrefix(line, commentChar) {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// AddChangeID adds the Change-Id trailer to a commit message cleaned with CleanMessage.
+// Like in the Gerrit hook, the Change-Id joins the trailers ending the message, before any Signed-off-by, and
+// messages that are empty, already have a Change-Id or belong to fixup or squash commits are returned unchanged
+func AddChangeID(message, changeID string) string {
+	if strings.TrimSpace(message) == "" || autosquashTitleRe.MatchString(message) {
+		return message
+	}
+	if _, ok := Parse(message).GetChangeID(); ok {
+		return message
+	}
+	message = strings.TrimRight(message, "\n")
+	trailer := changeIDHeader + ": " + changeID
+	paragraphs := strings.Split(message, "\n\n")
+	last := strings.Split(paragraphs[len(paragraphs)-1], "\n")
+	// the title is never a trailer block, even when it looks like one
+	if len(paragraphs) == 1 || !isTrailerBlock(last) {
+		return message + "\n\n" + trailer + "\n"
+	}
+	trailers := []string{}
+	inserted := false
+	for _, line := range last {
+		if !inserted && strings.HasPrefix(line, "Signed-off-by:") {
+			trailers = append(trailers, trailer)
+			inserted = true
+		}
+		trailers = append(trailers, line)
+	}
+	if !inserted {
+		trailers = append(trailers, trailer)
+	}
+	paragraphs[len(paragraphs)-1] = strings.Join(trailers, "\n")
+	return strings.Join(paragraphs, "\n\n") + "\n"
+}
+
+// isTrailerBlock tells whether all the lines of a paragraph are trailers, or their indented continuation
+func isTrailerBlock(lines []string) bool {
+	for i, line := range lines {
+		if i > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			continue
+		}
+		if !trailerRe.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package git
+
+import (
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewChangeID(t *testing.T) {
+	committer := object.Signature{Name: "John Doe", Email: "john.doe@example.com", When: time.Unix(1700000000, 0).In(time.FixedZone("", 3600))}
+	parent := plumbing.NewHash("0123456789abcdef0123456789abcdef01234567")
+	message := "Some change\n\nWith a body\n"
+
+	t.Run("Change-Ids are Gerrit compatible", func(t *testing.T) {
+		assert.Regexp(t, regexp.MustCompile(`^I[0-9a-f]{40}$`), NewChangeID(committer, parent, message))
+	})
+
+	t.Run("Change-Ids are computed like the Gerrit hook does", func(t *testing.T) {
+		if _, err := exec.LookPath("git"); err != nil {
+			t.Skip("git is not installed")
+		}
+		cmd := exec.Command("git", "hash-object", "--stdin")
+		cmd.Stdin = strings.NewReader("John Doe <john.doe@example.com> 1700000000 +0100\n" + parent.String() + "\n" + message)
+		out, err := cmd.Output()
+		require.NoError(t, err)
+		assert.Equal(t, "I"+strings.TrimSpace(string(out)), NewChangeID(committer, parent, message))
+	})
+
+	t.Run("Change-Ids differ for different messages, committers or parents", func(t *testing.T) {
+		changeID := NewChangeID(committer, parent, message)
+		assert.NotEqual(t, changeID, NewChangeID(committer, parent, "Other change\n"))
+		assert.NotEqual(t, changeID, NewChangeID(object.Signature{Name: "Jane Doe", Email: "jane.doe@example.com", When: committer.When}, parent, message))
+		assert.NotEqual(t, changeID, NewChangeID(committer, plumbing.ZeroHash, message))
+	})
+}
+
+func TestCommitter(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	when := time.Unix(1700000000, 0)
+	cfg := config.NewConfig()
+	cfg.Raw.Section("user").SetOption("name", "John Doe")
+	cfg.Raw.Section("user").SetOption("email", "john.doe@example.com")
+
+	t.Setenv("GIT_COMMITTER_NAME", "")
+	t.Setenv("GIT_COMMITTER_EMAIL", "")
+	assert.Equal(t, object.Signature{Name: "John Doe", Email: "john.doe@example.com", When: when}, Committer(cfg, when))
+
+	t.Setenv("GIT_COMMITTER_NAME", "Jane Doe")
+	t.Setenv("GIT_COMMITTER_EMAIL", "jane.doe@example.com")
+	assert.Equal(t, object.Signature{Name: "Jane Doe", Email: "jane.doe@example.com", When: when}, Committer(cfg, when))
+}
+
+func TestCleanMessage(t *testing.T) {
+	assert.Equal(t, "", CleanMessage("\n# Please enter the commit message\n\n", "#"))
+	assert.Equal(t, "Title\n\nBody  with spaces\n", CleanMessage("\n\nTitle   \n\n\n# comment\n\nBody  with spaces\t\n\n", "#"))
+	assert.Equal(t, "Title\n", CleanMessage("Title\n# ------------------------ >8 ------------------------\ndiff --git a/file b/file\n", "#"))
+	assert.Equal(t, "Title\n\n#123 is fixed\n", CleanMessage("Title\n\n#123 is fixed\n; comment\n", ";"))
+	assert.Equal(t, "Title\n", CleanMessage("Title\n; ------------------------ >8 ------------------------\n# diff\n", ";"))
+}
+
+func TestCommentChar(t *testing.T) {
+	cfg := config.NewConfig()
+	assert.Equal(t, "#", CommentChar(cfg))
+	cfg.Raw.Section("core").SetOption("commentChar", "auto")
+	assert.Equal(t, "#", CommentChar(cfg))
+	cfg.Raw.Section("core").SetOption("commentChar", ";")
+	assert.Equal(t, ";", CommentChar(cfg))
+}
+
+func TestAddChangeID(t *testing.T) {
+	changeID := "I0123456789abcdef0123456789abcdef01234567"
+	tests := []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{
+			name:     "title only",
+			message:  "Title\n",
+			expected: "Title\n\nChange-Id: " + changeID + "\n",
+		},
+		{
+			name:     "title looking like a trailer",
+			message:  "pkg: fix something\n",
+			expected: "pkg: fix something\n\nChange-Id: " + changeID + "\n",
+		},
+		{
+			name:     "message with a body",
+			message:  "Title\n\nSome body\nwith lines\n",
+			expected: "Title\n\nSome body\nwith lines\n\nChange-Id: " + changeID + "\n",
+		},
+		{
+			name:     "message with trailers",
+			message:  "Title\n\nSome body\n\nBug: 42\n",
+			expected: "Title\n\nSome body\n\nBug: 42\nChange-Id: " + changeID + "\n",
+		},
+		{
+			name:     "message signed off",
+			message:  "Title\n\nBug: 42\nSigned-off-by: John Doe <john.doe@example.com>\n",
+			expected: "Title\n\nBug: 42\nChange-Id: " + changeID + "\nSigned-off-by: John Doe <john.doe@example.com>\n",
+		},
+		{
+			name:     "message with a Change-Id",
+			message:  "Title\n\nChange-Id: I1111111111111111111111111111111111111111\n",
+			expected: "Title\n\nChange-Id: I1111111111111111111111111111111111111111\n",
+		},
+		{
+			name:     "fixup",
+			message:  "fixup! Title\n",
+			expected: "fixup! Title\n",
+		},
+		{
+			name:     "empty message",
+			message:  "",
+			expected: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, AddChangeID(test.message, changeID))
+		})
+	}
+}
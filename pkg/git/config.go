@@ -0,0 +1,28 @@
+package git
+
+import (
+	"github.com/go-git/go-git/v5/config"
+)
+
+// ConfigOption returns the value of the <section>.[<subsection>.]<key> git configuration.
+// The repository configuration takes precedence over the global one
+func ConfigOption(cfg *config.Config, section, subsection, key string) string {
+	if value := rawConfigOption(cfg, section, subsection, key); value != "" {
+		return value
+	}
+	global, err := config.LoadConfig(config.GlobalScope)
+	if err != nil {
+		return ""
+	}
+	return rawConfigOption(global, section, subsection, key)
+}
+
+func rawConfigOption(cfg *config.Config, section, subsection, key string) string {
+	if cfg == nil || cfg.Raw == nil {
+		return ""
+	}
+	if subsection == "" {
+		return cfg.Raw.Section(section).Option(key)
+	}
+	return cfg.Raw.Section(section).Subsection(subsection).Option(key)
+}
@@ -0,0 +1,432 @@
+package git
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Reasons why a path can't be merged, as reported in Conflict
+const (
+	// ConflictContent is reported when both sides changed the same lines of a file, or a binary file
+	ConflictContent = "content"
+	// ConflictAddAdd is reported when both sides added the same path with different contents
+	ConflictAddAdd = "add/add"
+	// ConflictModifyDelete is reported when a side deleted a path the other one modified
+	ConflictModifyDelete = "modify/delete"
+	// ConflictMode is reported when both sides changed the mode of a path differently, like making a file executable
+	// on one side and a symbolic link on the other
+	ConflictMode = "mode"
+	// ConflictFileDirectory is reported when a side added a file where the other one added a directory
+	ConflictFileDirectory = "file/directory"
+)
+
+// Conflict describes a path a three-way merge can't resolve automatically
+type Conflict struct {
+	Path string `json:"path"`
+	// Reason is one of the Conflict* constants
+	Reason string `json:"reason"`
+	// Base, Ours and Theirs are the blobs of the path on each side of the merge, zero when it is missing
+	Base   plumbing.Hash `json:"base"`
+	Ours   plumbing.Hash `json:"ours"`
+	Theirs plumbing.Hash `json:"theirs"`
+}
+
+// treeEntry is a file, a symbolic link or a submodule of a flattened tree
+type treeEntry struct {
+	mode filemode.FileMode
+	hash plumbing.Hash
+}
+
+// MergeTrees merges the changes from base to ours and from base to theirs, like git does with a three-way merge.
+// Files changed on both sides are merged line by line. The merged tree is written in s and returned along with
+// the conflicts. When there are conflicts, the tree holds the conflicting files the way git leaves them in the
+// working tree: with conflict markers around the conflicting lines, or the version of the side that kept them
+func MergeTrees(s storer.EncodedObjectStorer, base, ours, theirs plumbing.Hash) (plumbing.Hash, []Conflict, error) {
+	return mergeTrees(s, base, ours, theirs, [2]string{"ours", "theirs"})
+}
+
+// mergeTrees implements MergeTrees, labelling the sides of the conflict markers with labels
+func mergeTrees(s storer.EncodedObjectStorer, base, ours, theirs plumbing.Hash, labels [2]string) (plumbing.Hash, []Conflict, error) {
+	trees := []map[string]treeEntry{}
+	for _, hash := range []plumbing.Hash{base, ours, theirs} {
+		entries, err := flattenTree(s, hash)
+		if err != nil {
+			return plumbing.ZeroHash, nil, err
+		}
+		trees = append(trees, entries)
+	}
+	paths := map[string]struct{}{}
+	for _, entries := range trees {
+		for path := range entries {
+			paths[path] = struct{}{}
+		}
+	}
+	sorted := []string{}
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	merged := map[string]treeEntry{}
+	conflicts := []Conflict{}
+	for _, path := range sorted {
+		b, bok := trees[0][path]
+		o, ook := trees[1][path]
+		t, tok := trees[2][path]
+		conflict := Conflict{Path: path, Base: b.hash, Ours: o.hash, Theirs: t.hash}
+		switch {
+		case ook == tok && o == t:
+			if ook {
+				merged[path] = o
+			}
+		case bok == ook && b == o:
+			if tok {
+				merged[path] = t
+			}
+		case bok == tok && b == t:
+			if ook {
+				merged[path] = o
+			}
+		case !ook || !tok:
+			conflict.Reason = ConflictModifyDelete
+			conflicts = append(conflicts, conflict)
+			// the modified version is kept for the conflict to be resolved
+			if ook {
+				merged[path] = o
+			} else {
+				merged[path] = t
+			}
+		default:
+			entry, reason, err := mergeEntries(s, b, bok, o, t, labels)
+			if err != nil {
+				return plumbing.ZeroHash, nil, err
+			}
+			if reason != "" {
+				conflict.Reason = reason
+				conflicts = append(conflicts, conflict)
+			}
+			merged[path] = entry
+		}
+	}
+	for path := range merged {
+		for dir := parentDir(path); dir != ""; dir = parentDir(dir) {
+			if entry, ok := merged[dir]; ok {
+				conflicts = append(conflicts, Conflict{Path: dir, Reason: ConflictFileDirectory, Ours: entry.hash, Theirs: entry.hash})
+				// a path can't be both a file and a directory, the directory is kept
+				delete(merged, dir)
+			}
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+	hash, err := writeTree(s, merged)
+	return hash, conflicts, err
+}
+
+// mergeEntries merges an entry changed differently on both sides.
+// When they can't be merged, it returns the reason of the conflict along with the entry to leave in the working
+// tree: the file with conflict markers when it is text, our version otherwise
+func mergeEntries(s storer.EncodedObjectStorer, base treeEntry, hasBase bool, ours, theirs treeEntry, labels [2]string) (treeEntry, string, error) {
+	merged := treeEntry{}
+	switch {
+	case ours.mode == theirs.mode:
+		merged.mode = ours.mode
+	case hasBase && base.mode == ours.mode:
+		merged.mode = theirs.mode
+	case hasBase && base.mode == theirs.mode:
+		merged.mode = ours.mode
+	default:
+		return ours, ConflictMode, nil
+	}
+	switch {
+	case ours.hash == theirs.hash:
+		merged.hash = ours.hash
+		return merged, "", nil
+	case hasBase && base.hash == ours.hash:
+		merged.hash = theirs.hash
+		return merged, "", nil
+	case hasBase && base.hash == theirs.hash:
+		merged.hash = ours.hash
+		return merged, "", nil
+	case !merged.mode.IsFile() || merged.mode == filemode.Symlink:
+		return ours, ConflictContent, nil
+	}
+	reason := ""
+	if !hasBase {
+		// files added on both sides are merged as if they were empty before, to get conflict markers
+		reason = ConflictAddAdd
+	}
+	contents := []string{}
+	for _, entry := range []treeEntry{base, ours, theirs} {
+		if entry.hash.IsZero() {
+			contents = append(contents, "")
+			continue
+		}
+		content, err := readBlob(s, entry.hash)
+		if err != nil {
+			return merged, "", err
+		}
+		if strings.ContainsRune(content, 0) {
+			if reason == "" {
+				reason = ConflictContent
+			}
+			return ours, reason, nil
+		}
+		contents = append(contents, content)
+	}
+	content, ok := mergeLines(contents[0], contents[1], contents[2], labels)
+	if !ok && reason == "" {
+		reason = ConflictContent
+	}
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		return merged, "", err
+	}
+	_, err = w.Write([]byte(content))
+	if err != nil {
+		w.Close()
+		return merged, "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return merged, "", err
+	}
+	merged.hash, err = s.SetEncodedObject(obj)
+	return merged, reason, err
+}
+
+// hunk replaces the base lines [start, end) with lines
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+// hunks lists the changes from base to other, in base line coordinates
+func hunks(base, other string) []hunk {
+	result := []hunk{}
+	line := 0
+	var current *hunk
+	for _, d := range diff.Do(base, other) {
+		lines := splitLines(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			if current != nil {
+				result = append(result, *current)
+				current = nil
+			}
+			line += len(lines)
+		case diffmatchpatch.DiffDelete:
+			if current == nil {
+				current = &hunk{start: line, end: line}
+			}
+			line += len(lines)
+			current.end = line
+		case diffmatchpatch.DiffInsert:
+			if current == nil {
+				current = &hunk{start: line, end: line}
+			}
+			current.lines = append(current.lines, lines...)
+		}
+	}
+	if current != nil {
+		result = append(result, *current)
+	}
+	return result
+}
+
+// mergeLines merges the changes from base to ours and from base to theirs line by line.
+// Like git, changes touching the same or adjacent lines are only merged when they are identical, otherwise
+// the merge fails and the conflicting lines of both sides are surrounded by conflict markers labelled with labels
+func mergeLines(base, ours, theirs string, labels [2]string) (string, bool) {
+	baseLines := splitLines(base)
+	sides := [][]hunk{hunks(base, ours), hunks(base, theirs)}
+	merged := []string{}
+	ok := true
+	line := 0
+	i, j := 0, 0
+	for i < len(sides[0]) || j < len(sides[1]) {
+		// gather the overlapping hunks of both sides, starting with the first one
+		group := [2][]hunk{}
+		start, end := -1, -1
+		for {
+			next := -1
+			switch {
+			case i < len(sides[0]) && (j >= len(sides[1]) || sides[0][i].start <= sides[1][j].start):
+				next = 0
+			case j < len(sides[1]):
+				next = 1
+			}
+			if next == -1 {
+				break
+			}
+			h := sides[0][i:]
+			if next == 1 {
+				h = sides[1][j:]
+			}
+			if start != -1 && h[0].start > end {
+				break
+			}
+			if start == -1 {
+				start = h[0].start
+			}
+			if h[0].end > end {
+				end = h[0].end
+			}
+			group[next] = append(group[next], h[0])
+			if next == 0 {
+				i++
+			} else {
+				j++
+			}
+		}
+		merged = append(merged, baseLines[line:start]...)
+		switch {
+		case len(group[1]) == 0:
+			merged = append(merged, applyHunks(baseLines, start, end, group[0])...)
+		case len(group[0]) == 0:
+			merged = append(merged, applyHunks(baseLines, start, end, group[1])...)
+		default:
+			ours := applyHunks(baseLines, start, end, group[0])
+			theirs := applyHunks(baseLines, start, end, group[1])
+			if strings.Join(ours, "") == strings.Join(theirs, "") {
+				merged = append(merged, ours...)
+				break
+			}
+			ok = false
+			merged = append(merged, "<<<<<<< "+labels[0]+"\n")
+			merged = append(merged, terminateLines(ours)...)
+			merged = append(merged, "=======\n")
+			merged = append(merged, terminateLines(theirs)...)
+			merged = append(merged, ">>>>>>> "+labels[1]+"\n")
+		}
+		line = end
+	}
+	merged = append(merged, baseLines[line:]...)
+	return strings.Join(merged, ""), ok
+}
+
+// terminateLines makes sure the last line ends with a line feed, for a conflict marker to follow
+func terminateLines(lines []string) []string {
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		lines[len(lines)-1] += "\n"
+	}
+	return lines
+}
+
+// applyHunks returns the base lines [start, end) once the hunks of one side are applied
+func applyHunks(base []string, start, end int, hunks []hunk) []string {
+	result := []string{}
+	line := start
+	for _, h := range hunks {
+		result = append(result, base[line:h.start]...)
+		result = append(result, h.lines...)
+		line = h.end
+	}
+	return append(result, base[line:end]...)
+}
+
+// splitLines splits text in lines, keeping their line feed
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func parentDir(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+func readBlob(s storer.EncodedObjectStorer, hash plumbing.Hash) (string, error) {
+	blob, err := object.GetBlob(s, hash)
+	if err != nil {
+		return "", err
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	b := &bytes.Buffer{}
+	_, err = io.Copy(b, r)
+	return b.String(), err
+}
+
+// flattenTree lists the files, symbolic links and submodules of a tree by path
+func flattenTree(s storer.EncodedObjectStorer, hash plumbing.Hash) (map[string]treeEntry, error) {
+	tree, err := object.GetTree(s, hash)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]treeEntry{}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Mode == filemode.Dir {
+			continue
+		}
+		entries[name] = treeEntry{mode: entry.Mode, hash: entry.Hash}
+	}
+}
+
+// writeTree writes the tree, and its sub trees, holding entries by path
+func writeTree(s storer.EncodedObjectStorer, entries map[string]treeEntry) (plumbing.Hash, error) {
+	tree := &object.Tree{}
+	dirs := map[string]map[string]treeEntry{}
+	for path, entry := range entries {
+		name, rest, ok := strings.Cut(path, "/")
+		if !ok {
+			tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: entry.mode, Hash: entry.hash})
+			continue
+		}
+		if dirs[name] == nil {
+			dirs[name] = map[string]treeEntry{}
+		}
+		dirs[name][rest] = entry
+	}
+	for name, dir := range dirs {
+		hash, err := writeTree(s, dir)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: hash})
+	}
+	// git sorts directories as if their name ended with a slash
+	sortName := func(entry object.TreeEntry) string {
+		if entry.Mode == filemode.Dir {
+			return entry.Name + "/"
+		}
+		return entry.Name
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool {
+		return sortName(tree.Entries[i]) < sortName(tree.Entries[j])
+	})
+	obj := s.NewEncodedObject()
+	err := tree.Encode(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return s.SetEncodedObject(obj)
+}
@@ -0,0 +1,101 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeLines(t *testing.T) {
+	base := "a\nb\nc\nd\ne\n"
+	tests := []struct {
+		name   string
+		ours   string
+		theirs string
+		merged string
+		ok     bool
+	}{
+		{name: "unchanged", ours: base, theirs: base, merged: base, ok: true},
+		{name: "changed on one side", ours: "a\nB\nc\nd\ne\n", theirs: base, merged: "a\nB\nc\nd\ne\n", ok: true},
+		{name: "distant changes", ours: "A\nb\nc\nd\ne\n", theirs: "a\nb\nc\nd\nE\n", merged: "A\nb\nc\nd\nE\n", ok: true},
+		{name: "insertions and deletions", ours: "a\nc\nd\ne\n", theirs: "a\nb\nc\nd\ne\nf\n", merged: "a\nc\nd\ne\nf\n", ok: true},
+		{name: "identical changes", ours: "a\nB\nc\nd\ne\n", theirs: "a\nB\nc\nd\ne\n", merged: "a\nB\nc\nd\ne\n", ok: true},
+		{name: "same line changed differently", ours: "a\nB\nc\nd\ne\n", theirs: "a\nX\nc\nd\ne\n", merged: "a\n<<<<<<< ours\nB\n=======\nX\n>>>>>>> theirs\nc\nd\ne\n", ok: false},
+		{name: "adjacent lines changed", ours: "a\nB\nc\nd\ne\n", theirs: "a\nb\nC\nd\ne\n", merged: "a\n<<<<<<< ours\nB\nc\n=======\nb\nC\n>>>>>>> theirs\nd\ne\n", ok: false},
+		{name: "different insertions at the same place", ours: "a\nb\nc\nd\ne\nf\n", theirs: "a\nb\nc\nd\ne\ng\n", merged: "a\nb\nc\nd\ne\n<<<<<<< ours\nf\n=======\ng\n>>>>>>> theirs\n", ok: false},
+		{name: "missing final line feeds", ours: "a\nb\nc\nd\nE", theirs: "a\nb\nc\nd\nX", merged: "a\nb\nc\nd\n<<<<<<< ours\nE\n=======\nX\n>>>>>>> theirs\n", ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, ok := mergeLines(base, test.ours, test.theirs, [2]string{"ours", "theirs"})
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.merged, merged)
+		})
+	}
+}
+
+func TestMergeTrees(t *testing.T) {
+	dir := t.TempDir()
+	cmd(t, "git", "-C", dir, "init", "-b", "main")
+	cmd(t, "git", "-C", dir, "config", "user.email", "john.doe@example.com")
+	cmd(t, "git", "-C", dir, "config", "user.name", "John Doe")
+	commitFile(t, dir, "file", "a\nb\nc\nd\ne\n", "Initial commit")
+	commitFile(t, dir, "removed", "removed\n", "Add removed")
+	base := cmdOutput(t, "git", "-C", dir, "rev-parse", "HEAD^{tree}")
+	cmd(t, "git", "-C", dir, "checkout", "-b", "ours")
+	commitFile(t, dir, "file", "A\nb\nc\nd\ne\n", "Change the first line")
+	commitFile(t, dir, "added", "ours\n", "Add a file")
+	commitFile(t, dir, "removed", "changed\n", "Change removed")
+	ours := cmdOutput(t, "git", "-C", dir, "rev-parse", "HEAD^{tree}")
+	cmd(t, "git", "-C", dir, "checkout", "-b", "theirs", "main")
+	commitFile(t, dir, "file", "a\nb\nc\nd\nE\n", "Change the last line")
+	commitFile(t, dir, "added", "theirs\n", "Add a file")
+	cmd(t, "git", "-C", dir, "rm", "-q", "removed")
+	cmd(t, "git", "-C", dir, "commit", "-m", "Remove removed")
+	commitFile(t, dir, "other", "other\n", "Add other")
+	theirs := cmdOutput(t, "git", "-C", dir, "rev-parse", "HEAD^{tree}")
+
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+
+	t.Run("conflicting paths are reported", func(t *testing.T) {
+		tree, conflicts, err := MergeTrees(repo.Storer, plumbing.NewHash(base), plumbing.NewHash(ours), plumbing.NewHash(theirs))
+		require.NoError(t, err)
+		require.Len(t, conflicts, 2)
+		assert.Equal(t, "added", conflicts[0].Path)
+		assert.Equal(t, ConflictAddAdd, conflicts[0].Reason)
+		assert.True(t, conflicts[0].Base.IsZero())
+		assert.Equal(t, cmdOutput(t, "git", "-C", dir, "rev-parse", "ours:added"), conflicts[0].Ours.String())
+		assert.Equal(t, cmdOutput(t, "git", "-C", dir, "rev-parse", "theirs:added"), conflicts[0].Theirs.String())
+		assert.Equal(t, "removed", conflicts[1].Path)
+		assert.Equal(t, ConflictModifyDelete, conflicts[1].Reason)
+		assert.True(t, conflicts[1].Theirs.IsZero())
+
+		assert.Equal(t, "<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n", cmdOutput(t, "git", "-C", dir, "cat-file", "blob", tree.String()+":added")+"\n")
+		assert.Equal(t, "changed", cmdOutput(t, "git", "-C", dir, "cat-file", "blob", tree.String()+":removed"))
+		assert.Equal(t, "A\nb\nc\nd\nE", cmdOutput(t, "git", "-C", dir, "cat-file", "blob", tree.String()+":file"))
+	})
+
+	t.Run("changes of both sides are merged", func(t *testing.T) {
+		merged, conflicts, err := MergeTrees(repo.Storer, plumbing.NewHash(base), plumbing.NewHash(base), plumbing.NewHash(theirs))
+		require.NoError(t, err)
+		assert.Empty(t, conflicts)
+		assert.Equal(t, theirs, merged.String())
+
+		cmd(t, "git", "-C", dir, "checkout", "-b", "mergeable", "ours~2")
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "dir"), 0755))
+		commitFile(t, dir, "dir/nested", "nested\n", "Add a nested file")
+		mergeable := cmdOutput(t, "git", "-C", dir, "rev-parse", "HEAD^{tree}")
+		merged, conflicts, err = MergeTrees(repo.Storer, plumbing.NewHash(base), plumbing.NewHash(mergeable), plumbing.NewHash(theirs))
+		require.NoError(t, err)
+		assert.Empty(t, conflicts)
+		assert.Equal(t, "A\nb\nc\nd\nE\n", cmdOutput(t, "git", "-C", dir, "cat-file", "blob", merged.String()+":file")+"\n")
+		assert.Equal(t, "added\ndir/nested\nfile\nother\n", cmdOutput(t, "git", "-C", dir, "ls-tree", "-r", "--name-only", merged.String())+"\n")
+		cmd(t, "git", "-C", dir, "fsck", "--strict")
+	})
+}
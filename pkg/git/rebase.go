@@ -0,0 +1,375 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/adevinta/maiao/pkg/system"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ConflictError is returned when a commit can't be applied on top of another one without conflicts
+type ConflictError struct {
+	// Commit is the commit that could not be applied
+	Commit plumbing.Hash
+	// Onto is the commit it was applied on
+	Onto      plumbing.Hash
+	Conflicts []Conflict
+	// Tree is the result of the merge, with conflict markers in the conflicting files, as checked out by CheckoutConflict
+	Tree plumbing.Hash
+}
+
+func (e *ConflictError) Error() string {
+	paths := []string{}
+	for _, conflict := range e.Conflicts {
+		paths = append(paths, fmt.Sprintf("%s (%s)", conflict.Path, conflict.Reason))
+	}
+	return fmt.Sprintf("could not apply %s: conflicts in %s", e.Commit.String(), strings.Join(paths, ", "))
+}
+
+// CherryPick applies the changes of commit on top of onto with a three-way merge, recording committer as committer.
+// It returns the new commit, onto itself when the changes are already applied, or a *ConflictError
+func CherryPick(repo Repository, commit *object.Commit, onto plumbing.Hash, committer object.Signature) (plumbing.Hash, error) {
+	s, err := repositoryStorage(repo)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if len(commit.ParentHashes) != 1 {
+		return plumbing.ZeroHash, fmt.Errorf("unable to apply commit %s, only commits with a single parent can be applied", commit.Hash)
+	}
+	if commit.ParentHashes[0] == onto {
+		return commit.Hash, nil
+	}
+	parent, err := object.GetCommit(s, commit.ParentHashes[0])
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	target, err := object.GetCommit(s, onto)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	labels := [2]string{"HEAD", fmt.Sprintf("%s (%s)", commit.Hash.String()[:7], Parse(commit.Message).GetTitle())}
+	tree, conflicts, err := mergeTrees(s, parent.TreeHash, target.TreeHash, commit.TreeHash, labels)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if len(conflicts) > 0 {
+		return plumbing.ZeroHash, &ConflictError{Commit: commit.Hash, Onto: onto, Conflicts: conflicts, Tree: tree}
+	}
+	// like git rebase, commits becoming empty are dropped, the ones created empty are kept
+	if tree == target.TreeHash && commit.TreeHash != parent.TreeHash {
+		return onto, nil
+	}
+	picked := &object.Commit{
+		Author:       commit.Author,
+		Committer:    committer,
+		Message:      commit.Message,
+		TreeHash:     tree,
+		ParentHashes: []plumbing.Hash{onto},
+		Encoding:     commit.Encoding,
+	}
+	obj := s.NewEncodedObject()
+	err = picked.Encode(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return s.SetEncodedObject(obj)
+}
+
+// Rebase applies commits, oldest first, on top of onto with CherryPick and returns the new head.
+// References are left untouched, so the rebase has no effect until HEAD is moved to the returned commit
+func Rebase(repo Repository, onto plumbing.Hash, commits []plumbing.Hash) (plumbing.Hash, error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	s, err := repositoryStorage(repo)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	head := onto
+	for _, hash := range commits {
+		commit, err := object.GetCommit(s, hash)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		head, err = CherryPick(repo, commit, head, rebaseCommitter(cfg, commit))
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	return head, nil
+}
+
+// rebaseCommitter returns the committer of the rebased commit, the current user or the original committer when unknown
+func rebaseCommitter(cfg *config.Config, commit *object.Commit) object.Signature {
+	committer := Committer(cfg, time.Now())
+	if committer.Name == "" || committer.Email == "" {
+		committer.Name = commit.Committer.Name
+		committer.Email = commit.Committer.Email
+	}
+	return committer
+}
+
+// CheckClean fails when the working tree or the index have uncommitted changes, untracked files aside
+func CheckClean(repo Repository) error {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return err
+	}
+	for path, file := range status {
+		if (file.Staging != git.Unmodified && file.Staging != git.Untracked) || (file.Worktree != git.Unmodified && file.Worktree != git.Untracked) {
+			return fmt.Errorf("%s has uncommitted changes: commit or stash them first", path)
+		}
+	}
+	return nil
+}
+
+// CheckoutConflict checks out the result of the conflicting merge on top of its Onto commit, with HEAD detached,
+// the way git rebase stops on conflicts. The merged paths are staged and the conflicting ones are left unstaged,
+// with conflict markers in text files, to be resolved by hand and staged before CommitResolution is called
+func CheckoutConflict(repo Repository, conflict *ConflictError) error {
+	s, err := repositoryStorage(repo)
+	if err != nil {
+		return err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	// the conflicting tree is checked out from a commit no reference points to
+	merge := &object.Commit{
+		Author:       object.Signature{Name: "git review", When: time.Now()},
+		Committer:    object.Signature{Name: "git review", When: time.Now()},
+		Message:      fmt.Sprintf("conflicting merge of %s", conflict.Commit.String()),
+		TreeHash:     conflict.Tree,
+		ParentHashes: []plumbing.Hash{conflict.Onto},
+	}
+	obj := s.NewEncodedObject()
+	err = merge.Encode(obj)
+	if err != nil {
+		return err
+	}
+	hash, err := s.SetEncodedObject(obj)
+	if err != nil {
+		return err
+	}
+	err = s.SetReference(plumbing.NewHashReference(plumbing.HEAD, conflict.Onto))
+	if err != nil {
+		return err
+	}
+	err = wt.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset})
+	if err != nil {
+		return errors.Wrap(err, "failed to check out the conflicting files")
+	}
+	paths := []string{}
+	for _, c := range conflict.Conflicts {
+		// the directory colliding with a file is kept and staged
+		if c.Reason != ConflictFileDirectory {
+			paths = append(paths, c.Path)
+		}
+	}
+	if len(paths) == 0 {
+		return wt.Reset(&git.ResetOptions{Commit: conflict.Onto, Mode: git.SoftReset})
+	}
+	return wt.Reset(&git.ResetOptions{Commit: conflict.Onto, Mode: git.MixedReset, Files: paths})
+}
+
+// CommitResolution commits the staged resolution of the conflicts of commit on top of HEAD, with the author and the
+// message of commit. Nothing is committed when the resolution leaves HEAD unchanged, in which case HEAD is returned.
+// It fails when changes are left unstaged, or a conflicting path is left untracked
+func CommitResolution(repo Repository, commit plumbing.Hash, conflicts []string) (plumbing.Hash, error) {
+	s, err := repositoryStorage(repo)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	for path, file := range status {
+		if file.Worktree != git.Unmodified && file.Worktree != git.Untracked {
+			return plumbing.ZeroHash, fmt.Errorf("%s has unstaged changes, stage the resolved files with git add first", path)
+		}
+	}
+	for _, path := range conflicts {
+		if file, ok := status[path]; ok && file.Worktree == git.Untracked {
+			return plumbing.ZeroHash, fmt.Errorf("%s is not resolved, stage it with git add or remove it with git rm first", path)
+		}
+	}
+	original, err := object.GetCommit(s, commit)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	committer := rebaseCommitter(cfg, original)
+	resolved, err := wt.Commit(original.Message, &git.CommitOptions{
+		Author:    &original.Author,
+		Committer: &committer,
+		Parents:   []plumbing.Hash{head.Hash()},
+	})
+	if err == git.ErrEmptyCommit {
+		return head.Hash(), nil
+	}
+	return resolved, err
+}
+
+// CheckoutBranch points branch to hash, checks it out and resets the index and the working tree to hash.
+// HEAD is detached at hash when branch is empty
+func CheckoutBranch(repo Repository, branch plumbing.ReferenceName, hash plumbing.Hash) error {
+	s, err := repositoryStorage(repo)
+	if err != nil {
+		return err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	head := plumbing.NewHashReference(plumbing.HEAD, hash)
+	if branch != "" {
+		err = s.SetReference(plumbing.NewHashReference(branch, hash))
+		if err != nil {
+			return err
+		}
+		head = plumbing.NewSymbolicReference(plumbing.HEAD, branch)
+	}
+	err = s.SetReference(head)
+	if err != nil {
+		return err
+	}
+	err = wt.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to check out %s", hash.String()))
+	}
+	return nil
+}
+
+// RebaseInProgress tells whether a git rebase is stopped in the repository, waiting to be continued or aborted
+func RebaseInProgress(repo Repository) (bool, error) {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return false, err
+	}
+	gitDir, err := FindGitDir(wt.Filesystem.Root())
+	if err != nil {
+		return false, err
+	}
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		_, err := system.DefaultFileSystem.Stat(filepath.Join(gitDir, dir))
+		if err == nil {
+			return true, nil
+		}
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// NativeExecutor runs git operations in-process with go-git, without needing the git command line
+type NativeExecutor struct{}
+
+// MergeBase implements Executor with the go-git merge base lookup
+func (e NativeExecutor) MergeBase(ctx context.Context, repo Repository, base, head plumbing.Revision) (plumbing.Hash, error) {
+	s, err := repositoryStorage(repo)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	commits := []*object.Commit{}
+	for _, rev := range []plumbing.Revision{base, head} {
+		hash, err := repo.ResolveRevision(rev)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		commit, err := object.GetCommit(s, *hash)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		commits = append(commits, commit)
+	}
+	bases, err := commits[0].MergeBase(commits[1])
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if len(bases) == 0 {
+		return plumbing.ZeroHash, fmt.Errorf("%s and %s have no common ancestor", base, head)
+	}
+	return bases[0].Hash, nil
+}
+
+// RebaseCommits implements Executor by applying the picked commits of todo in-process.
+// As with git rebase, the working tree must not have uncommitted changes. It is updated along with HEAD once all
+// the commits are applied, and left untouched on conflicts, reported as a *ConflictError the caller can check out
+// with CheckoutConflict. The review is not resumed, the caller carries on
+func (e NativeExecutor) RebaseCommits(ctx context.Context, repo Repository, base, onto plumbing.Hash, todo string) (bool, error) {
+	commits := []plumbing.Hash{}
+	for _, line := range strings.Split(todo, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "pick", "p", "reword", "r":
+			if len(fields) < 2 {
+				return false, fmt.Errorf("missing commit in rebase todo line %q", line)
+			}
+			hash, err := repo.ResolveRevision(plumbing.Revision(fields[1]))
+			if err != nil {
+				return false, err
+			}
+			commits = append(commits, *hash)
+		case "drop", "d":
+		default:
+			return false, fmt.Errorf("unsupported rebase todo action %s", fields[0])
+		}
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return false, err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return false, err
+	}
+	err = CheckClean(repo)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot rebase")
+	}
+	rebased, err := Rebase(repo, onto, commits)
+	if err != nil {
+		return false, err
+	}
+	err = UpdateHead(repo, head.Hash(), rebased)
+	if err != nil {
+		return false, err
+	}
+	err = wt.Reset(&git.ResetOptions{Commit: rebased, Mode: git.HardReset})
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to check out the rebased commit %s", rebased.String()))
+	}
+	return false, nil
+}
@@ -0,0 +1,159 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNativeExecutor(t *testing.T) {
+	dir := t.TempDir()
+	cmd(t, "git", "-C", dir, "init", "-b", "main")
+	cmd(t, "git", "-C", dir, "config", "user.email", "john.doe@example.com")
+	cmd(t, "git", "-C", dir, "config", "user.name", "John Doe")
+	base := commitFile(t, dir, "file", "a\nb\nc\nd\ne\n", "Initial commit")
+	cmd(t, "git", "-C", dir, "checkout", "-b", "topic")
+	first := commitFile(t, dir, "file", "A\nb\nc\nd\ne\n", "First change")
+	cmd(t, "git", "-C", dir, "commit", "--allow-empty", "-m", "Empty change")
+	empty := cmdOutput(t, "git", "-C", dir, "rev-parse", "HEAD")
+	second := commitFile(t, dir, "other", "other\n", "Second change")
+	cmd(t, "git", "-C", dir, "checkout", "main")
+	onto := commitFile(t, dir, "file", "a\nb\nc\nd\nE\n", "Upstream change")
+	cmd(t, "git", "-C", dir, "checkout", "topic")
+
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	executor := NativeExecutor{}
+
+	t.Run("merge bases are found in-process", func(t *testing.T) {
+		mergeBase, err := executor.MergeBase(context.Background(), repo, "main", "topic")
+		require.NoError(t, err)
+		assert.Equal(t, base, mergeBase.String())
+	})
+
+	t.Run("rebases are refused with uncommitted changes", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "other"), []byte("changed\n"), 0644))
+		defer cmd(t, "git", "-C", dir, "checkout", "other")
+		_, err := executor.RebaseCommits(context.Background(), repo, plumbing.NewHash(base), plumbing.NewHash(onto), "pick "+second+" Second change")
+		assert.Error(t, err)
+		assert.Equal(t, second, cmdOutput(t, "git", "-C", dir, "rev-parse", "HEAD"))
+	})
+
+	t.Run("conflicts are reported and leave the branch untouched", func(t *testing.T) {
+		conflicting := commitFile(t, dir, "file", "A\nb\nc\nd\nX\n", "Conflicting change")
+		_, err := executor.RebaseCommits(context.Background(), repo, plumbing.NewHash(base), plumbing.NewHash(onto), "pick "+first+" First change\npick "+conflicting+" Conflicting change")
+		var conflict *ConflictError
+		require.True(t, errors.As(err, &conflict), "unexpected error %v", err)
+		assert.Equal(t, conflicting, conflict.Commit.String())
+		require.Len(t, conflict.Conflicts, 1)
+		assert.Equal(t, "file", conflict.Conflicts[0].Path)
+		assert.Equal(t, ConflictContent, conflict.Conflicts[0].Reason)
+		assert.Equal(t, conflicting, cmdOutput(t, "git", "-C", dir, "rev-parse", "HEAD"))
+		cmd(t, "git", "-C", dir, "reset", "-q", "--hard", second)
+	})
+
+	t.Run("commits are rebased following the todo list", func(t *testing.T) {
+		resumed, err := executor.RebaseCommits(context.Background(), repo, plumbing.NewHash(base), plumbing.NewHash(onto), "pick "+first+" First change\npick "+empty+" Empty change\npick "+second+" Second change")
+		require.NoError(t, err)
+		assert.False(t, resumed)
+		assert.Equal(t, "refs/heads/topic", cmdOutput(t, "git", "-C", dir, "symbolic-ref", "HEAD"))
+		assert.Equal(t, "Second change\nEmpty change\nFirst change\nUpstream change\nInitial commit", cmdOutput(t, "git", "-C", dir, "log", "--format=%s"))
+		assert.Equal(t, "A\nb\nc\nd\nE", cmdOutput(t, "git", "-C", dir, "show", "HEAD:file"))
+		assert.Empty(t, cmdOutput(t, "git", "-C", dir, "status", "--porcelain"))
+		content, err := os.ReadFile(filepath.Join(dir, "file"))
+		require.NoError(t, err)
+		assert.Equal(t, "A\nb\nc\nd\nE\n", string(content))
+	})
+}
+
+func TestCLIExecutor(t *testing.T) {
+	dir := t.TempDir()
+	cmd(t, "git", "-C", dir, "init", "-b", "main")
+	cmd(t, "git", "-C", dir, "config", "user.email", "john.doe@example.com")
+	cmd(t, "git", "-C", dir, "config", "user.name", "John Doe")
+	base := commitFile(t, dir, "file", "a\n", "Initial commit")
+	cmd(t, "git", "-C", dir, "checkout", "-b", "topic")
+	first := commitFile(t, dir, "first", "first\n", "First change")
+	commitFile(t, dir, "dropped", "dropped\n", "Dropped change")
+	cmd(t, "git", "-C", dir, "checkout", "main")
+	onto := commitFile(t, dir, "file", "A\n", "Upstream change")
+	cmd(t, "git", "-C", dir, "checkout", "topic")
+
+	review := filepath.Join(t.TempDir(), "git-review")
+	require.NoError(t, os.WriteFile(review, []byte("#!/bin/sh\necho \"$"+RebaseArgsEnvVar+"\" > "+filepath.Join(dir, ".git", "review-args")+"\n"), 0755))
+	t.Setenv("GIT_EDITOR", "false")
+
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	resumed, err := CLIExecutor{Command: []string{review, "--remote", "origin"}}.RebaseCommits(context.Background(), repo, plumbing.NewHash(base), plumbing.NewHash(onto), "pick "+first+" First change")
+	require.NoError(t, err)
+	assert.True(t, resumed)
+	assert.Equal(t, "First change\nUpstream change\nInitial commit", cmdOutput(t, "git", "-C", dir, "log", "--format=%s"), "the todo list must be used as is, without any editor")
+	args, err := os.ReadFile(filepath.Join(dir, ".git", "review-args"))
+	require.NoError(t, err)
+	assert.Equal(t, `["--remote","origin"]`+"\n", string(args))
+}
+
+func TestCheckoutConflict(t *testing.T) {
+	dir := t.TempDir()
+	cmd(t, "git", "-C", dir, "init", "-b", "main")
+	cmd(t, "git", "-C", dir, "config", "user.email", "john.doe@example.com")
+	cmd(t, "git", "-C", dir, "config", "user.name", "John Doe")
+	commitFile(t, dir, "file", "a\nb\nc\n", "Initial commit")
+	cmd(t, "git", "-C", dir, "checkout", "-b", "topic")
+	merged := commitFile(t, dir, "merged", "topic\n", "Add merged")
+	conflicting := commitFile(t, dir, "file", "A\nb\nc\n", "Conflicting change")
+	cmd(t, "git", "-C", dir, "checkout", "main")
+	onto := commitFile(t, dir, "file", "X\nb\nc\n", "Upstream change")
+	cmd(t, "git", "-C", dir, "checkout", "topic")
+
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	rebased, err := Rebase(repo, plumbing.NewHash(onto), []plumbing.Hash{plumbing.NewHash(merged)})
+	require.NoError(t, err)
+	commit, err := repo.CommitObject(plumbing.NewHash(conflicting))
+	require.NoError(t, err)
+	_, err = CherryPick(repo, commit, rebased, commit.Committer)
+	var conflict *ConflictError
+	require.True(t, errors.As(err, &conflict), "unexpected error %v", err)
+
+	require.NoError(t, CheckoutConflict(repo, conflict))
+	assert.Equal(t, rebased.String(), cmdOutput(t, "git", "-C", dir, "rev-parse", "HEAD"))
+	assert.Equal(t, conflicting, cmdOutput(t, "git", "-C", dir, "rev-parse", "topic"))
+	content, err := os.ReadFile(filepath.Join(dir, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "<<<<<<< HEAD\nX\n=======\nA\n>>>>>>> "+conflicting[:7]+" (Conflicting change)\nb\nc\n", string(content))
+	assert.Equal(t, "file", cmdOutput(t, "git", "-C", dir, "diff", "--name-only"))
+	assert.Empty(t, cmdOutput(t, "git", "-C", dir, "diff", "--cached", "--name-only"))
+
+	t.Run("unstaged resolutions are not committed", func(t *testing.T) {
+		_, err := CommitResolution(repo, plumbing.NewHash(conflicting), []string{"file"})
+		assert.Error(t, err)
+	})
+
+	t.Run("staged resolutions are committed with the original author and message", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("Y\nb\nc\n"), 0644))
+		cmd(t, "git", "-C", dir, "add", "file")
+		resolved, err := CommitResolution(repo, plumbing.NewHash(conflicting), []string{"file"})
+		require.NoError(t, err)
+		assert.Equal(t, resolved.String(), cmdOutput(t, "git", "-C", dir, "rev-parse", "HEAD"))
+		assert.Equal(t, rebased.String(), cmdOutput(t, "git", "-C", dir, "rev-parse", "HEAD~1"))
+		assert.Equal(t, "Conflicting change", cmdOutput(t, "git", "-C", dir, "log", "-1", "--format=%s"))
+		assert.Equal(t, cmdOutput(t, "git", "-C", dir, "log", "-1", "--format=%an <%ae> %ad", conflicting), cmdOutput(t, "git", "-C", dir, "log", "-1", "--format=%an <%ae> %ad"))
+
+		t.Run("branches are checked out on the rebased commits", func(t *testing.T) {
+			require.NoError(t, CheckoutBranch(repo, plumbing.NewBranchReferenceName("topic"), resolved))
+			assert.Equal(t, "refs/heads/topic", cmdOutput(t, "git", "-C", dir, "symbolic-ref", "HEAD"))
+			assert.Equal(t, resolved.String(), cmdOutput(t, "git", "-C", dir, "rev-parse", "topic"))
+			assert.Equal(t, "X\nb\nc\n", cmdOutput(t, "git", "-C", dir, "show", "main:file")+"\n")
+			assert.Empty(t, cmdOutput(t, "git", "-C", dir, "status", "--porcelain"))
+		})
+	})
+}
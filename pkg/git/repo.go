@@ -32,13 +32,43 @@ type Repository interface {
 	Push(o *git.PushOptions) error
 	Branches() (storer.ReferenceIter, error)
 	Config() (*config.Config, error)
+	SetConfig(cfg *config.Config) error
 	Fetch(o *git.FetchOptions) error
 	Log(o *git.LogOptions) (object.CommitIter, error)
 	ResolveRevision(rev plumbing.Revision) (*plumbing.Hash, error)
 	Worktree() (*git.Worktree, error)
 }
 
+// Executor runs the git operations go-git does not provide
+type Executor interface {
+	// MergeBase returns the best common ancestor of base and head
+	MergeBase(ctx context.Context, repo Repository, base, head plumbing.Revision) (plumbing.Hash, error)
+	// RebaseCommits rebases the commits between base and HEAD onto onto, following todo, a git rebase -i todo list.
+	// It returns whether the review is resumed by the rebase itself, like when git rebase -i runs git review again,
+	// in which case the caller stops there
+	RebaseCommits(ctx context.Context, repo Repository, base, onto plumbing.Hash, todo string) (bool, error)
+}
+
+// CLIExecutor runs git operations with the git command line.
+// Rebases are interactive git rebases, that can be resolved with git itself when they conflict
+type CLIExecutor struct {
+	// Command is the git review command line, run once the commits are rebased to resume the review.
+	// os.Args is used when empty
+	Command []string
+}
+
+// MergeBase returns the best common ancestor of base and head using the default CLIExecutor
 func MergeBase(ctx context.Context, repo Repository, base, head plumbing.Revision) (plumbing.Hash, error) {
+	return CLIExecutor{}.MergeBase(ctx, repo, base, head)
+}
+
+// RebaseCommits rebases the commits between base and HEAD onto onto using the default CLIExecutor
+func RebaseCommits(ctx context.Context, repo Repository, base, onto plumbing.Hash, todo string) (bool, error) {
+	return CLIExecutor{}.RebaseCommits(ctx, repo, base, onto, todo)
+}
+
+// MergeBase implements Executor with git merge-base
+func (e CLIExecutor) MergeBase(ctx context.Context, repo Repository, base, head plumbing.Revision) (plumbing.Hash, error) {
 	wt, err := repo.Worktree()
 	if err != nil {
 		return plumbing.Hash{}, err
@@ -62,44 +92,73 @@ func MergeBase(ctx context.Context, repo Repository, base, head plumbing.Revisio
 	return plumbing.NewHash(strings.Trim(out.String(), " \n")), nil
 }
 
-func RebaseCommits(ctx context.Context, repo Repository, base, onto plumbing.Hash, todo string) error {
+// RebaseCommits implements Executor with git rebase -i.
+// The rebase ends with the execution of the git review command, which receives its arguments in the RebaseArgsEnvVar
+// environment variable, so the review is always resumed by the rebase, including when it is continued after a conflict
+func (e CLIExecutor) RebaseCommits(ctx context.Context, repo Repository, base, onto plumbing.Hash, todo string) (bool, error) {
+	command := e.Command
+	if len(command) == 0 {
+		command = os.Args
+	}
 	wt, err := repo.Worktree()
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	fd, err := ioutil.TempFile("", "rebase-todo-")
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer func() {
 		os.Remove(fd.Name())
 	}()
-	todo = todo + "\n" + "exec " + os.Args[0]
+	args, err := json.Marshal(command[1:])
+	if err != nil {
+		return false, err
+	}
+	// the arguments are part of the exec line for the review to resume with them when the rebase is continued
+	// after a conflict, from a new git rebase --continue process
+	todo = todo + "\n" + "exec " + RebaseArgsEnvVar + "=" + shellQuote(string(args)) + " " + shellQuote(command[0])
 	_, err = fd.Write([]byte(todo + "\n"))
 	if err != nil {
 		fd.Close()
-		return err
+		return false, err
 	}
 	err = fd.Close()
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	c := exec.Command("git", "-C", wt.Filesystem.Root(), "rebase", "-i", "--onto", onto.String(), base.String())
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	c.Stdin = os.Stdin
-	args, err := json.Marshal(os.Args[1:])
+	// the sequence editor only replaces the todo list git generates with ours
+	c.Env = append(os.Environ(), "GIT_SEQUENCE_EDITOR=cp "+shellQuote(fd.Name()))
+	err = c.Run()
 	if err != nil {
-		return err
+		return false, err
 	}
-	c.Env = append(os.Environ(), "GIT_EDITOR="+os.Args[0]+" add-change-id-editor "+fd.Name(), RebaseArgsEnvVar+"="+string(args))
-	err = c.Run()
+	return true, nil
+}
+
+// ResumeRebase runs git rebase with action, one of continue, skip or abort, to finish a rebase stopped on conflicts.
+// Once continued, the rebase resumes the review like RebaseCommits does
+func (e CLIExecutor) ResumeRebase(ctx context.Context, repo Repository, action string) error {
+	wt, err := repo.Worktree()
 	if err != nil {
 		return err
 	}
-	return nil
+	c := exec.Command("git", "-C", wt.Filesystem.Root(), "rebase", "--"+action)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	c.Stdin = os.Stdin
+	return c.Run()
+}
+
+// shellQuote quotes s as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
 func resolveGitDir(gitDir string) (string, error) {
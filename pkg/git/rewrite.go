@@ -0,0 +1,136 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// repositoryStorage returns the storage of a go-git repository, where commits and references can be written
+func repositoryStorage(repo Repository) (storage.Storer, error) {
+	r, ok := repo.(*git.Repository)
+	if !ok {
+		return nil, fmt.Errorf("unable to write objects in a %T repository", repo)
+	}
+	return r.Storer, nil
+}
+
+// RewriteCommits recreates the chain of commits from base, excluded, to head with the messages returned by message.
+// The trees, the authors and the committers, dates included, are kept as is and the signatures are dropped.
+// Commits are only recreated when their message changes or one of their ancestors was recreated, and the hash of
+// the rewritten head is returned, head itself when nothing changed. References are left untouched
+func RewriteCommits(repo Repository, base, head plumbing.Hash, message func(*object.Commit) string) (plumbing.Hash, error) {
+	s, err := repositoryStorage(repo)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	commits := []*object.Commit{}
+	for hash := head; hash != base; {
+		commit, err := object.GetCommit(s, hash)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if len(commit.ParentHashes) != 1 {
+			return plumbing.ZeroHash, fmt.Errorf("unable to rewrite commit %s, only commits with a single parent can be rewritten", commit.Hash)
+		}
+		commits = append([]*object.Commit{commit}, commits...)
+		hash = commit.ParentHashes[0]
+	}
+	parent := base
+	for _, commit := range commits {
+		msg := message(commit)
+		if commit.ParentHashes[0] == parent && msg == commit.Message {
+			parent = commit.Hash
+			continue
+		}
+		rewritten := &object.Commit{
+			Author:       commit.Author,
+			Committer:    commit.Committer,
+			Message:      msg,
+			TreeHash:     commit.TreeHash,
+			ParentHashes: []plumbing.Hash{parent},
+			Encoding:     commit.Encoding,
+		}
+		obj := s.NewEncodedObject()
+		err = rewritten.Encode(obj)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		parent, err = s.SetEncodedObject(obj)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	return parent, nil
+}
+
+// UpdateHead moves the checked out branch, or HEAD when it is detached, from old to new.
+// The index and the working tree are left untouched, new is expected to have the same tree as old
+func UpdateHead(repo Repository, old, new plumbing.Hash) error {
+	s, err := repositoryStorage(repo)
+	if err != nil {
+		return err
+	}
+	head, err := s.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+	name := plumbing.HEAD
+	if head.Type() == plumbing.SymbolicReference {
+		name = head.Target()
+	}
+	current, err := s.Reference(name)
+	if err != nil {
+		return err
+	}
+	if current.Hash() != old {
+		return errors.New("HEAD was moved while its commits were rewritten")
+	}
+	return s.CheckAndSetReference(plumbing.NewHashReference(name, new), current)
+}
+
+// SetReference points the reference name to hash, creating it when missing
+func SetReference(repo Repository, name plumbing.ReferenceName, hash plumbing.Hash) error {
+	s, err := repositoryStorage(repo)
+	if err != nil {
+		return err
+	}
+	return s.SetReference(plumbing.NewHashReference(name, hash))
+}
+
+// RemoveReference deletes the reference name, doing nothing when it is missing
+func RemoveReference(repo Repository, name plumbing.ReferenceName) error {
+	s, err := repositoryStorage(repo)
+	if err != nil {
+		return err
+	}
+	return s.RemoveReference(name)
+}
+
+// RemoteTrackingBranches returns the remote tracking branches of remote, by branch name, with the commit they point to
+func RemoteTrackingBranches(repo Repository, remote string) (map[string]plumbing.Hash, error) {
+	s, err := repositoryStorage(repo)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := s.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+	prefix := plumbing.NewRemoteReferenceName(remote, "").String()
+	branches := map[string]plumbing.Hash{}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && strings.HasPrefix(ref.Name().String(), prefix) {
+			branches[strings.TrimPrefix(ref.Name().String(), prefix)] = ref.Hash()
+		}
+		return nil
+	})
+	return branches, err
+}
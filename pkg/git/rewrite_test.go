@@ -0,0 +1,67 @@
+package git
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteCommits(t *testing.T) {
+	dir := t.TempDir()
+	cmd(t, "git", "-C", dir, "init", "-b", "main")
+	cmd(t, "git", "-C", dir, "config", "user.email", "john.doe@example.com")
+	cmd(t, "git", "-C", dir, "config", "user.name", "John Doe")
+	base := commitFile(t, dir, "file", "initial", "Initial commit")
+	first := commitFile(t, dir, "file", "first", "First change")
+	cmd(t, "git", "-C", dir, "commit", "--allow-empty", "--date", "2020-01-01T00:00:00+02:00", "-m", "Second change")
+	head := cmdOutput(t, "git", "-C", dir, "rev-parse", "HEAD")
+
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+
+	t.Run("commits are kept when their messages are unchanged", func(t *testing.T) {
+		rewritten, err := RewriteCommits(repo, plumbing.NewHash(base), plumbing.NewHash(head), func(c *object.Commit) string {
+			return c.Message
+		})
+		require.NoError(t, err)
+		assert.Equal(t, head, rewritten.String())
+	})
+
+	t.Run("descendants of rewritten commits are recreated with their trees, authors and committers", func(t *testing.T) {
+		rewritten, err := RewriteCommits(repo, plumbing.NewHash(base), plumbing.NewHash(head), func(c *object.Commit) string {
+			if c.Hash.String() == first {
+				return strings.Replace(c.Message, "First", "Rewritten", 1)
+			}
+			return c.Message
+		})
+		require.NoError(t, err)
+		require.NotEqual(t, head, rewritten.String())
+
+		for _, pair := range [][2]string{{head, rewritten.String()}, {head + "^", rewritten.String() + "^"}} {
+			original, err := repo.CommitObject(plumbing.NewHash(cmdOutput(t, "git", "-C", dir, "rev-parse", pair[0])))
+			require.NoError(t, err)
+			commit, err := repo.CommitObject(plumbing.NewHash(cmdOutput(t, "git", "-C", dir, "rev-parse", pair[1])))
+			require.NoError(t, err)
+			assert.Equal(t, original.TreeHash, commit.TreeHash)
+			assert.Equal(t, original.Author.String(), commit.Author.String())
+			assert.True(t, original.Author.When.Equal(commit.Author.When))
+			assert.Equal(t, original.Committer.String(), commit.Committer.String())
+			assert.True(t, original.Committer.When.Equal(commit.Committer.When))
+		}
+		assert.Equal(t, "Rewritten change\n", cmdOutput(t, "git", "-C", dir, "log", "-1", "--format=%B", rewritten.String()+"^")+"\n")
+		assert.Equal(t, base, cmdOutput(t, "git", "-C", dir, "rev-parse", rewritten.String()+"~2"))
+
+		t.Run("the checked out branch can be moved to the rewritten commits", func(t *testing.T) {
+			assert.Error(t, UpdateHead(repo, plumbing.NewHash(first), rewritten))
+			require.NoError(t, UpdateHead(repo, plumbing.NewHash(head), rewritten))
+			assert.Equal(t, rewritten.String(), cmdOutput(t, "git", "-C", dir, "rev-parse", "main"))
+			assert.Equal(t, "refs/heads/main", cmdOutput(t, "git", "-C", dir, "symbolic-ref", "HEAD"))
+			assert.Empty(t, cmdOutput(t, "git", "-C", dir, "status", "--porcelain"))
+		})
+	})
+}
@@ -0,0 +1,77 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/kevinburke/ssh_config"
+)
+
+// SSHConfigFiles returns the ssh configuration files, by order of precedence
+var SSHConfigFiles = func() []string {
+	files := []string{}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".ssh", "config"))
+	}
+	return append(files, filepath.Join("/", "etc", "ssh", "ssh_config"))
+}
+
+func loadSSHConfigs() []*ssh_config.Config {
+	configs := []*ssh_config.Config{}
+	for _, path := range SSHConfigFiles() {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		cfg, err := ssh_config.Decode(f)
+		f.Close()
+		if err != nil {
+			log.Logger.WithField("path", path).WithError(err).Warn("failed to parse the ssh configuration, its host aliases are not resolved")
+			continue
+		}
+		configs = append(configs, cfg)
+	}
+	return configs
+}
+
+func sshConfigOption(configs []*ssh_config.Config, alias, key string) string {
+	for _, cfg := range configs {
+		value, err := cfg.Get(alias, key)
+		if err == nil && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// ResolveSSHEndpoint returns the endpoint an ssh remote actually points to, according to the
+// HostName, User and Port of the ssh configuration, allowing to use host aliases like git@github-work:org/repo.git.
+// Users and ports set in the remote URL take precedence over the ssh configuration.
+// Endpoints using other protocols, or hosts without ssh configuration, are returned unchanged
+func ResolveSSHEndpoint(endpoint *transport.Endpoint) *transport.Endpoint {
+	if endpoint == nil || endpoint.Protocol != "ssh" {
+		return endpoint
+	}
+	configs := loadSSHConfigs()
+	if len(configs) == 0 {
+		return endpoint
+	}
+	alias := endpoint.Host
+	resolved := *endpoint
+	if hostName := sshConfigOption(configs, alias, "HostName"); hostName != "" {
+		resolved.Host = strings.ReplaceAll(hostName, "%h", alias)
+	}
+	if resolved.User == "" {
+		resolved.User = sshConfigOption(configs, alias, "User")
+	}
+	if resolved.Port == 0 || resolved.Port == 22 {
+		if port, err := strconv.Atoi(sshConfigOption(configs, alias, "Port")); err == nil {
+			resolved.Port = port
+		}
+	}
+	return &resolved
+}
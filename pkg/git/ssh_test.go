@@ -0,0 +1,100 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withSSHConfig makes the given content the only ssh configuration for the duration of the test
+func withSSHConfig(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	original := SSHConfigFiles
+	t.Cleanup(func() {
+		SSHConfigFiles = original
+	})
+	SSHConfigFiles = func() []string {
+		return []string{path}
+	}
+}
+
+func TestResolveSSHEndpoint(t *testing.T) {
+	withSSHConfig(t, `
+Host github-work
+	HostName github.com
+	IdentityFile ~/.ssh/work
+
+Host gitea
+	HostName %h.company.example.com
+	User gitea-user
+	Port 2222
+`)
+
+	resolve := func(t *testing.T, remote string) *transport.Endpoint {
+		t.Helper()
+		endpoint, err := transport.NewEndpoint(remote)
+		require.NoError(t, err)
+		return ResolveSSHEndpoint(endpoint)
+	}
+
+	t.Run("host aliases are resolved to their HostName", func(t *testing.T) {
+		endpoint := resolve(t, "git@github-work:org/repo.git")
+		assert.Equal(t, "github.com", endpoint.Host)
+		assert.Equal(t, "git", endpoint.User)
+		assert.Equal(t, 22, endpoint.Port)
+		assert.Equal(t, "org/repo.git", endpoint.Path)
+	})
+
+	t.Run("user and port are taken from the ssh configuration when not in the URL", func(t *testing.T) {
+		endpoint := resolve(t, "ssh://gitea/org/repo.git")
+		assert.Equal(t, "gitea.company.example.com", endpoint.Host)
+		assert.Equal(t, "gitea-user", endpoint.User)
+		assert.Equal(t, 2222, endpoint.Port)
+	})
+
+	t.Run("users and ports of the URL take precedence", func(t *testing.T) {
+		endpoint := resolve(t, "ssh://me@gitea:2200/org/repo.git")
+		assert.Equal(t, "gitea.company.example.com", endpoint.Host)
+		assert.Equal(t, "me", endpoint.User)
+		assert.Equal(t, 2200, endpoint.Port)
+	})
+
+	t.Run("unknown hosts are left unchanged", func(t *testing.T) {
+		endpoint := resolve(t, "git@gitlab.com:org/repo.git")
+		assert.Equal(t, "gitlab.com", endpoint.Host)
+		assert.Equal(t, "git", endpoint.User)
+	})
+
+	t.Run("http remotes are left unchanged", func(t *testing.T) {
+		endpoint := resolve(t, "https://github-work/org/repo.git")
+		assert.Equal(t, "github-work", endpoint.Host)
+	})
+
+	t.Run("missing ssh configurations are ignored", func(t *testing.T) {
+		SSHConfigFiles = func() []string {
+			return []string{filepath.Join(t.TempDir(), "missing")}
+		}
+		endpoint := resolve(t, "git@github-work:org/repo.git")
+		assert.Equal(t, "github-work", endpoint.Host)
+	})
+
+	t.Run("undecodable ssh configurations are reported", func(t *testing.T) {
+		withSSHConfig(t, "Match host github-work\n\tHostName github.com\n")
+		hook := test.NewLocal(log.Logger)
+		defer hook.Reset()
+		endpoint := resolve(t, "git@github-work:org/repo.git")
+		assert.Equal(t, "github-work", endpoint.Host)
+		require.NotNil(t, hook.LastEntry())
+		assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+		assert.Contains(t, hook.LastEntry().Message, "failed to parse the ssh configuration")
+	})
+}
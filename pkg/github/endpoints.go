@@ -0,0 +1,85 @@
+package gh
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// APIEndpoints overrides the API URLs of a GitHub instance, for GitHub Enterprise
+// instances whose API is not served under https://<host>/api/v3/ and https://<host>/api/graphql.
+// The zero value uses the default URLs of the instance
+type APIEndpoints struct {
+	// RESTURL is the base URL of the REST API, like https://api.github.company.example.com/api/v3/
+	RESTURL string
+	// GraphQLURL is the URL of the GraphQL API, like https://api.github.company.example.com/api/graphql.
+	// When empty, it is derived from RESTURL
+	GraphQLURL string
+}
+
+// configured tells whether any API URL is overridden
+func (e APIEndpoints) configured() bool {
+	return e.RESTURL != "" || e.GraphQLURL != ""
+}
+
+// RESTURL returns the base URL of the REST API of domain, overridden by endpoints when configured
+func RESTURL(domain string, endpoints APIEndpoints) (*url.URL, error) {
+	if endpoints.RESTURL != "" {
+		u, err := url.Parse(endpoints.RESTURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REST API URL for %s: %w", domain, err)
+		}
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+		}
+		return u, nil
+	}
+	switch domain {
+	case "github.com", "api.github.com":
+		return &url.URL{Scheme: "https", Host: "api.github.com", Path: "/"}, nil
+	}
+	return &url.URL{Scheme: "https", Host: domain, Path: "/api/v3/"}, nil
+}
+
+// GraphQLURL returns the URL of the GraphQL API of domain, overridden by endpoints when configured
+func GraphQLURL(domain string, endpoints APIEndpoints) (*url.URL, error) {
+	if endpoints.GraphQLURL != "" {
+		u, err := url.Parse(endpoints.GraphQLURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GraphQL API URL for %s: %w", domain, err)
+		}
+		return u, nil
+	}
+	rest, err := RESTURL(domain, endpoints)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(rest.Path, "/v3/") {
+		// GitHub Enterprise serves the GraphQL API next to the REST one: /api/v3/ and /api/graphql
+		return rest.Parse("../graphql")
+	}
+	return rest.Parse("graphql")
+}
+
+// rewriteTransport sends all requests to a fixed URL.
+// It allows to use a GraphQL endpoint the GitHub cli client can't be configured with
+type rewriteTransport struct {
+	URL  *url.URL
+	Base http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.URL.Scheme
+	r.URL.Host = t.URL.Host
+	r.URL.Path = t.URL.Path
+	r.URL.RawPath = t.URL.RawPath
+	r.Host = t.URL.Host
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
@@ -0,0 +1,85 @@
+package gh
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIURLs(t *testing.T) {
+	t.Run("github.com uses api.github.com", func(t *testing.T) {
+		rest, err := RESTURL("github.com", APIEndpoints{})
+		require.NoError(t, err)
+		assert.Equal(t, "https://api.github.com/", rest.String())
+		graphQL, err := GraphQLURL("github.com", APIEndpoints{})
+		require.NoError(t, err)
+		assert.Equal(t, "https://api.github.com/graphql", graphQL.String())
+	})
+	t.Run("GitHub Enterprise defaults to the /api/ path of the host", func(t *testing.T) {
+		rest, err := RESTURL("github.company.example.com", APIEndpoints{})
+		require.NoError(t, err)
+		assert.Equal(t, "https://github.company.example.com/api/v3/", rest.String())
+		graphQL, err := GraphQLURL("github.company.example.com", APIEndpoints{})
+		require.NoError(t, err)
+		assert.Equal(t, "https://github.company.example.com/api/graphql", graphQL.String())
+	})
+	t.Run("the configured REST URL is used, and the GraphQL one derived from it", func(t *testing.T) {
+		endpoints := APIEndpoints{RESTURL: "https://ghe-api.company.example.com/github/api/v3"}
+		rest, err := RESTURL("github-alias", endpoints)
+		require.NoError(t, err)
+		assert.Equal(t, "https://ghe-api.company.example.com/github/api/v3/", rest.String())
+		graphQL, err := GraphQLURL("github-alias", endpoints)
+		require.NoError(t, err)
+		assert.Equal(t, "https://ghe-api.company.example.com/github/api/graphql", graphQL.String())
+	})
+	t.Run("the configured GraphQL URL is used", func(t *testing.T) {
+		graphQL, err := GraphQLURL("github-alias", APIEndpoints{RESTURL: "https://ghe-api.company.example.com/", GraphQLURL: "https://ghe-graphql.company.example.com/query"})
+		require.NoError(t, err)
+		assert.Equal(t, "https://ghe-graphql.company.example.com/query", graphQL.String())
+	})
+}
+
+func TestNewClientUsesConfiguredAPI(t *testing.T) {
+	c, err := NewClientForEndpoints(http.DefaultClient, "github-alias", APIEndpoints{RESTURL: "https://ghe-api.company.example.com/api/v3/"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://ghe-api.company.example.com/api/v3/", c.BaseURL.String())
+	assert.Equal(t, "https://ghe-api.company.example.com/api/v3/upload/", c.UploadURL.String())
+}
+
+func TestNewHTTPClientForEndpointsUsesAPIHostCredentials(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	defer setCredentialStore(DefaultCredentialGetter)
+	hosts := []string{}
+	setCredentialStore(&hostCredentialGetter{hosts: &hosts, known: "github.company.example.com"})
+
+	_, err := NewHTTPClientForEndpoints(context.Background(), "github.company.example.com", APIEndpoints{RESTURL: "https://ghe-api.company.example.com/api/v3/"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ghe-api.company.example.com", "github.company.example.com"}, hosts)
+}
+
+func TestNewGraphQLClientUsesConfiguredURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/custom/graphql", r.URL.Path)
+		b, _ := io.ReadAll(r.Body)
+		assert.True(t, strings.Contains(string(b), "viewer"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"viewer": {"login": "octocat"}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewGraphQLClientForEndpoints(server.Client(), "github-alias", APIEndpoints{GraphQLURL: server.URL + "/custom/graphql"})
+	require.NoError(t, err)
+	var query struct {
+		Viewer struct {
+			Login string
+		}
+	}
+	require.NoError(t, client.Query("Viewer", &query, nil))
+	assert.Equal(t, "octocat", query.Viewer.Login)
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"net/url"
 
+	"github.com/adevinta/maiao/pkg/credentials"
 	"github.com/adevinta/maiao/pkg/log"
 	"github.com/cli/go-gh/v2/pkg/api"
 	"github.com/google/go-github/v55/github"
@@ -20,13 +20,30 @@ func GitHubAPIDomain(domain string) string {
 	return domain
 }
 
+// NewHTTPClientForDomain returns an http client authenticated with the token found for the API host of domain
 func NewHTTPClientForDomain(ctx context.Context, domain string) (*http.Client, error) {
-	domain = GitHubAPIDomain(domain)
+	return NewHTTPClientForEndpoints(ctx, domain, APIEndpoints{}, DefaultCredentialGetter)
+}
+
+// NewHTTPClientForEndpoints returns an http client authenticated with the token getter has for the API host of domain,
+// as overridden by endpoints. DefaultCredentialGetter is used when getter is nil
+func NewHTTPClientForEndpoints(ctx context.Context, domain string, endpoints APIEndpoints, getter credentials.CredentialGetter) (*http.Client, error) {
+	if getter == nil {
+		getter = DefaultCredentialGetter
+	}
+	apiURL, err := RESTURL(domain, endpoints)
+	if err != nil {
+		return nil, err
+	}
 	// TODO: move this to handle unauthorized calls.
-	token, err := getGithubToken(domain)
+	token, err := getGithubToken(getter, apiURL.Host)
+	if err != nil && apiURL.Host != domain && apiURL.Host != GitHubAPIDomain(domain) {
+		// API served from a dedicated host, credentials may still be registered for the main one
+		token, err = getGithubToken(getter, domain)
+	}
 	if err != nil {
-		log.ForContext(ctx).WithError(err).WithField("domain", domain).Errorf("unable to find token")
-		return nil, fmt.Errorf("unable to find token for %s: %s", domain, err.Error())
+		log.ForContext(ctx).WithError(err).WithField("domain", apiURL.Host).Errorf("unable to find token")
+		return nil, fmt.Errorf("unable to find token for %s: %s", apiURL.Host, err.Error())
 	}
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
@@ -35,12 +52,27 @@ func NewHTTPClientForDomain(ctx context.Context, domain string) (*http.Client, e
 	return tc, nil
 }
 
+// NewGraphQLClient instanciates a GitHub GraphQL client for domain
 func NewGraphQLClient(httpClient *http.Client, domain string) (*api.GraphQLClient, error) {
+	return NewGraphQLClientForEndpoints(httpClient, domain, APIEndpoints{})
+}
+
+// NewGraphQLClientForEndpoints instanciates a GitHub GraphQL client for domain.
+//
+// When endpoints are configured, requests are sent to their GraphQL URL
+func NewGraphQLClientForEndpoints(httpClient *http.Client, domain string, endpoints APIEndpoints) (*api.GraphQLClient, error) {
 	opts := api.ClientOptions{
 		AuthToken: "overridden by Transport",
 		Host:      GitHubAPIDomain(domain),
 		Transport: httpClient.Transport,
 	}
+	if endpoints.configured() {
+		graphQLURL, err := GraphQLURL(domain, endpoints)
+		if err != nil {
+			return nil, err
+		}
+		opts.Transport = &rewriteTransport{URL: graphQLURL, Base: httpClient.Transport}
+	}
 	client, err := api.NewGraphQLClient(opts)
 	if err != nil {
 		return nil, err
@@ -51,31 +83,38 @@ func NewGraphQLClient(httpClient *http.Client, domain string) (*api.GraphQLClien
 // NewClient instanciates a new github client depending on the domain name
 //
 // When requesting a client for a different host than github.com,
-// a client for github enterprise is considered
+// a client for github enterprise is considered, using https://<domain>/api/v3/
 //
 // Credentials are even taken from GITHUB_TOKEN environment variable or
 // from your ~/.netrc file
 func NewClient(httpClient *http.Client, domain string) (*github.Client, error) {
+	return NewClientForEndpoints(httpClient, domain, APIEndpoints{})
+}
+
+// NewClientForEndpoints instanciates a new github client like NewClient,
+// sending requests to the REST URL of endpoints when configured
+func NewClientForEndpoints(httpClient *http.Client, domain string, endpoints APIEndpoints) (*github.Client, error) {
 	c := github.NewClient(httpClient)
-	switch domain {
-	case "github.com", "api.github.com":
+	switch {
+	case (domain == "github.com" || domain == "api.github.com") && !endpoints.configured():
 	default:
-		GitHubURL := url.URL{
-			Scheme: "https",
-			Host:   GitHubAPIDomain(domain),
-			Path:   "/api/v3/",
+		GitHubURL, err := RESTURL(domain, endpoints)
+		if err != nil {
+			return nil, err
+		}
+		GitHubUploadURL, err := GitHubURL.Parse("upload/")
+		if err != nil {
+			return nil, err
 		}
-		GitHubUploadURL := GitHubURL
-		GitHubUploadURL.Path = "/api/v3/upload/"
-		c.BaseURL = &GitHubURL
+		c.BaseURL = GitHubURL
 		// TODO: confirm from https://github.com/goreleaser/goreleaser/issues/365#issuecomment-331655225
-		c.UploadURL = &GitHubUploadURL
+		c.UploadURL = GitHubUploadURL
 	}
 	return c, nil
 }
 
-func getGithubToken(domain string) (string, error) {
-	creds, err := DefaultCredentialGetter.CredentialForHost(domain)
+func getGithubToken(getter credentials.CredentialGetter, domain string) (string, error) {
+	creds, err := getter.CredentialForHost(domain)
 	if err != nil {
 		return "", err
 	}
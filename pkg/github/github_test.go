@@ -2,6 +2,7 @@ package gh
 
 import (
 	"context"
+	"errors"
 	"os"
 	"testing"
 
@@ -40,6 +41,20 @@ func (c *TestCredentialGetter) CredentialForHost(string) (*credentials.Credentia
 	return c.Credentials, c.Error
 }
 
+// hostCredentialGetter records the hosts credentials are requested for, and only knows credentials for one host
+type hostCredentialGetter struct {
+	hosts *[]string
+	known string
+}
+
+func (c *hostCredentialGetter) CredentialForHost(host string) (*credentials.Credentials, error) {
+	*c.hosts = append(*c.hosts, host)
+	if host != c.known {
+		return nil, errors.New("not found")
+	}
+	return &credentials.Credentials{Password: "token"}, nil
+}
+
 func TestGetGitHubToken(t *testing.T) {
 	t.Cleanup(system.Reset)
 	os.Unsetenv("GITHUB_TOKEN")
@@ -52,7 +67,7 @@ func TestGetGitHubToken(t *testing.T) {
 			Username: "user",
 			Password: "api key",
 		}
-		token, err := getGithubToken("test.domain.tld")
+		token, err := getGithubToken(DefaultCredentialGetter, "test.domain.tld")
 		assert.NoError(t, err)
 		assert.Equal(t, "api key", token)
 	})
@@ -61,7 +76,7 @@ func TestGetGitHubToken(t *testing.T) {
 		creds.Credentials = &credentials.Credentials{
 			Username: "user",
 		}
-		token, err := getGithubToken("test.domain.tld")
+		token, err := getGithubToken(DefaultCredentialGetter, "test.domain.tld")
 		assert.NoError(t, err)
 		assert.Equal(t, "user", token)
 	})
@@ -70,14 +85,14 @@ func TestGetGitHubToken(t *testing.T) {
 		creds.Credentials = &credentials.Credentials{
 			Password: "api key",
 		}
-		token, err := getGithubToken("test.domain.tld")
+		token, err := getGithubToken(DefaultCredentialGetter, "test.domain.tld")
 		assert.NoError(t, err)
 		assert.Equal(t, "api key", token)
 	})
 	t.Run("when nothing is provided, an error is returned", func(t *testing.T) {
 		defer func(c *credentials.Credentials) { creds.Credentials = c }(creds.Credentials)
 		creds.Credentials = &credentials.Credentials{}
-		token, err := getGithubToken("test.domain.tld")
+		token, err := getGithubToken(DefaultCredentialGetter, "test.domain.tld")
 		assert.Error(t, err)
 		assert.Equal(t, "", token)
 	})
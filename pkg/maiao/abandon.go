@@ -0,0 +1,177 @@
+package maiao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/adevinta/maiao/pkg/api"
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	pullRequestNumberRegex = regexp.MustCompile(`^#?[0-9]+$`)
+	changeIDRegex          = regexp.MustCompile(`^I[0-9a-f]{40}$`)
+)
+
+// Abandon closes the pull request of a change, identified by its Change-Id, a commit or its pull request number,
+// leaving comment on it when not empty, and deletes its remote branch.
+// Pull requests stacked on top of the abandoned change are retargeted to its base beforehand, keeping the rest of the stack consistent
+func (r *Reviewer) Abandon(ctx context.Context, repo lgit.Repository, options ReviewOptions, target, comment string) error {
+	if options.DryRun {
+		return errors.New("dry runs are not supported when abandoning changes")
+	}
+	defaultRemoteOption(ctx, repo, &options)
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context": "abandoning change",
+		"remote":  options.Remote,
+		"target":  target,
+	})
+
+	_, prAPI, err := r.newPullRequester(ctx, repo, &options)
+	if err != nil {
+		return err
+	}
+	manager, ok := prAPI.(api.PullRequestManager)
+	if !ok {
+		return errors.New("the pull request backend does not support abandoning changes")
+	}
+
+	forkOwner := ""
+	if options.PushRemote != "" && options.PushRemote != options.Remote {
+		forkOwner, _, err = forkRemote(repo, options.PushRemote)
+		if err != nil {
+			return err
+		}
+	}
+
+	status, err := findChangePullRequest(ctx, repo, prAPI, manager, forkOwner, target)
+	if err != nil {
+		return err
+	}
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"prID": status.ID,
+		"head": status.Head,
+		"base": status.Base,
+	})
+
+	switch status.State {
+	case api.PullRequestStateMerged:
+		return fmt.Errorf("pull request %s is already merged", status.URL)
+	case api.PullRequestStateClosed:
+		fmt.Fprintln(r.output(), fmt.Sprintf("PR %s is already closed", status.URL))
+	default:
+		// children must be retargeted before closing the pull request and deleting its branch,
+		// some forges automatically close pull requests whose base branch is deleted
+		children, err := manager.ListOpen(ctx, status.Head)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			err = manager.Retarget(ctx, &child.PullRequest, status.Base)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(r.output(), fmt.Sprintf("retargeted PR %s to %s", child.URL, status.Base))
+		}
+		err = manager.Close(ctx, &status.PullRequest, comment)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(r.output(), fmt.Sprintf("closed PR %s", status.URL))
+	}
+
+	if _, ok := prAPI.(api.ChangePusher); ok {
+		// changes pushed for review do not have any branch of their own
+		return nil
+	}
+	// heads of pull requests opened from forks may be prefixed with the fork owner
+	branch := status.Head[strings.LastIndex(status.Head, ":")+1:]
+	return r.deleteRemoteBranches(ctx, repo, prAPI, options, branch)
+}
+
+// findChangePullRequest returns the pull request target refers to, either its number, a Change-Id or a commit
+func findChangePullRequest(ctx context.Context, repo lgit.Repository, prAPI api.PullRequester, manager api.PullRequestManager, forkOwner, target string) (*api.PullRequestStatus, error) {
+	if pullRequestNumberRegex.MatchString(target) {
+		return manager.Get(ctx, strings.TrimPrefix(target, "#"))
+	}
+	changeID := target
+	if !changeIDRegex.MatchString(target) {
+		hash, err := repo.ResolveRevision(plumbing.Revision(target))
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to resolve revision")
+			return nil, fmt.Errorf("%s is neither a pull request number, a Change-Id nor a commit: %w", target, err)
+		}
+		commits, err := repo.Log(&git.LogOptions{From: *hash})
+		if err != nil {
+			return nil, err
+		}
+		commit, err := commits.Next()
+		commits.Close()
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		changeID, ok = lgit.Parse(commit.Message).GetChangeID()
+		if !ok {
+			return nil, fmt.Errorf("commit %s does not have any Change-Id", hash.String())
+		}
+	}
+	head := "maiao." + changeID
+	if forkOwner != "" {
+		head = forkOwner + ":" + head
+	}
+	status, err := prAPI.Find(ctx, head)
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, fmt.Errorf("no pull request found for change %s", changeID)
+	}
+	return status, nil
+}
+
+// deleteRemoteBranches deletes change branches from the remote they were pushed to
+func (r *Reviewer) deleteRemoteBranches(ctx context.Context, repo lgit.Repository, prAPI api.PullRequester, options ReviewOptions, branches ...string) error {
+	pushRemote := options.Remote
+	if options.PushRemote != "" {
+		pushRemote = options.PushRemote
+	}
+	remote, err := repo.Remote(pushRemote)
+	if err != nil {
+		return err
+	}
+	if len(remote.Config().URLs) != 1 {
+		return errors.New("multiple URLs not supported")
+	}
+	endpoint, err := transport.NewEndpoint(remote.Config().URLs[0])
+	if err != nil {
+		return err
+	}
+	refspecs := []config.RefSpec{}
+	for _, branch := range branches {
+		refspecs = append(refspecs, config.RefSpec(":"+plumbing.NewBranchReferenceName(branch).String()))
+	}
+	log.ForContext(ctx).WithField("refspec", refspecs).WithField("pushRemote", pushRemote).Debugf("deleting change branches")
+	err = r.pushBranches(ctx, repo, prAPI, pushRemote, endpoint, refspecs, false)
+	if err == git.NoErrAlreadyUpToDate {
+		log.ForContext(ctx).Debug("change branches were already deleted")
+		return nil
+	}
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to delete change branches")
+		return err
+	}
+	for _, branch := range branches {
+		fmt.Fprintln(r.output(), fmt.Sprintf("deleted branch %s", branch))
+	}
+	return nil
+}
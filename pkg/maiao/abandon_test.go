@@ -0,0 +1,54 @@
+package maiao
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/adevinta/maiao/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAbandon(t *testing.T) {
+	work, remote, repo := newTestStack(t,
+		"First change\n\nChange-Id: I1111111111111111111111111111111111111111",
+		"Second change\n\nChange-Id: I2222222222222222222222222222222222222222",
+		"Third change\n\nChange-Id: I3333333333333333333333333333333333333333",
+	)
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local"}
+	_, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+	local := &api.Local{Path: filepath.Join(work, ".git", api.LocalStorePath)}
+
+	require.NoError(t, Abandon(context.Background(), repo, options, "HEAD~1", "superseded"))
+
+	prs, err := local.PullRequests()
+	require.NoError(t, err)
+	require.Len(t, prs, 3)
+	assert.False(t, prs[0].Closed)
+	assert.True(t, prs[1].Closed)
+	assert.Equal(t, []string{"superseded"}, prs[1].Comments)
+	assert.False(t, prs[2].Closed)
+	assert.Equal(t, "maiao.I1111111111111111111111111111111111111111", prs[2].Base, "the child pull request must be retargeted to the parent of the abandoned change")
+	assert.Empty(t, gitCommand(t, remote, "branch", "--list", "maiao.I2222222222222222222222222222222222222222"))
+	assert.NotEmpty(t, gitCommand(t, remote, "branch", "--list", "maiao.I3333333333333333333333333333333333333333"))
+
+	t.Run("abandoning by pull request number", func(t *testing.T) {
+		require.NoError(t, Abandon(context.Background(), repo, options, "#3", ""))
+		status, err := local.Get(context.Background(), "3")
+		require.NoError(t, err)
+		assert.Equal(t, api.PullRequestStateClosed, status.State)
+		assert.Empty(t, gitCommand(t, remote, "branch", "--list", "maiao.I3333333333333333333333333333333333333333"))
+	})
+
+	t.Run("abandoning an already closed change only ensures its branch is deleted", func(t *testing.T) {
+		require.NoError(t, Abandon(context.Background(), repo, options, "I2222222222222222222222222222222222222222", ""))
+	})
+
+	t.Run("unknown changes are reported", func(t *testing.T) {
+		err := Abandon(context.Background(), repo, options, "I4444444444444444444444444444444444444444", "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no pull request found")
+	})
+}
@@ -0,0 +1,167 @@
+package maiao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/adevinta/maiao/pkg/api"
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sirupsen/logrus"
+)
+
+// Download fetches the stack of the change identified by target, either its pull request number or its Change-Id,
+// and checks it out in the localBranch branch, tracking the branch the stack targets.
+// The bases of the pull requests are followed down to the target branch, so the local branch holds the full stack.
+// When localBranch is empty, review/<pull request number> is used
+func (r *Reviewer) Download(ctx context.Context, repo lgit.Repository, options ReviewOptions, target, localBranch string) error {
+	if options.DryRun {
+		return errors.New("dry runs are not supported when downloading changes")
+	}
+	defaultRemoteOption(ctx, repo, &options)
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context": "downloading change",
+		"remote":  options.Remote,
+		"target":  target,
+	})
+
+	remote, prAPI, err := r.newPullRequester(ctx, repo, &options)
+	if err != nil {
+		return err
+	}
+	if _, ok := prAPI.(api.ChangePusher); ok {
+		return errors.New("downloading changes is not supported by backends pushing changes for review")
+	}
+	manager, ok := prAPI.(api.PullRequestManager)
+	if !ok {
+		return errors.New("the pull request backend does not support downloading changes")
+	}
+	if !pullRequestNumberRegex.MatchString(target) && !changeIDRegex.MatchString(target) {
+		return fmt.Errorf("%s is neither a pull request number nor a Change-Id", target)
+	}
+	top, err := findChangePullRequest(ctx, repo, prAPI, manager, "", target)
+	if err != nil {
+		return err
+	}
+
+	// stack lists the pull requests from the requested one down to the one targeting the review branch
+	stack := []*api.PullRequestStatus{top}
+	seen := map[string]struct{}{top.Head: {}}
+	for pr := top; strings.HasPrefix(pr.Base, "maiao."); {
+		if _, ok := seen[pr.Base]; ok {
+			return fmt.Errorf("pull request %s is part of a cycle of bases", pr.URL)
+		}
+		seen[pr.Base] = struct{}{}
+		parent, err := prAPI.Find(ctx, pr.Base)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			return fmt.Errorf("no pull request found for %s, the base of %s", pr.Base, pr.URL)
+		}
+		stack = append(stack, parent)
+		pr = parent
+	}
+	targetBranch := stack[len(stack)-1].Base
+
+	fetched := append(changeHeads(stack), targetBranch)
+	if keeper, ok := prAPI.(api.BranchKeeper); ok && keeper.KeepsBranchesLocal() {
+		// the branches of the changes are only known to the remote tracking branches of the repository
+		fetched = []string{targetBranch}
+	}
+	err = r.fetchChangeBranches(ctx, remote, fetched)
+	if err != nil {
+		return err
+	}
+	hashes := map[string]plumbing.Hash{}
+	for _, pr := range stack {
+		hash, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewRemoteReferenceName(options.Remote, pr.Head)))
+		if err != nil {
+			return fmt.Errorf("unable to find the %s branch of %s: %w", pr.Head, pr.URL, err)
+		}
+		hashes[pr.Head] = *hash
+	}
+	for i := len(stack) - 1; i > 0; i-- {
+		parent, child := stack[i], stack[i-1]
+		base, err := r.git().MergeBase(ctx, repo, plumbing.Revision(hashes[parent.Head].String()), plumbing.Revision(hashes[child.Head].String()))
+		if err != nil {
+			return err
+		}
+		if base != hashes[parent.Head] {
+			fmt.Fprintln(r.output(), fmt.Sprintf("warning: %s is not based on the latest version of %s", child.Head, parent.Head))
+		}
+	}
+
+	if localBranch == "" {
+		localBranch = "review/" + top.ID
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	err = wt.Checkout(&git.CheckoutOptions{
+		Hash:   hashes[top.Head],
+		Branch: plumbing.NewBranchReferenceName(localBranch),
+		Create: true,
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).WithField("localBranch", localBranch).Error("failed to check out the stack")
+		return err
+	}
+	// tracking the target branch lets git review submit the stack to the same branch
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Branches[localBranch] = &config.Branch{
+		Name:   localBranch,
+		Remote: options.Remote,
+		Merge:  plumbing.NewBranchReferenceName(targetBranch),
+	}
+	err = repo.SetConfig(cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(r.output(), fmt.Sprintf("checked out %d changes of %s in %s, on top of %s/%s", len(stack), top.URL, localBranch, options.Remote, targetBranch))
+	return nil
+}
+
+func changeHeads(stack []*api.PullRequestStatus) []string {
+	heads := []string{}
+	for _, pr := range stack {
+		heads = append(heads, pr.Head)
+	}
+	return heads
+}
+
+// fetchChangeBranches updates the remote tracking branches of branches only
+func (r *Reviewer) fetchChangeBranches(ctx context.Context, remote *git.Remote, branches []string) error {
+	if len(remote.Config().URLs) != 1 {
+		return errors.New("multiple URLs not supported")
+	}
+	endpoint, err := transport.NewEndpoint(remote.Config().URLs[0])
+	if err != nil {
+		return err
+	}
+	refspecs := []config.RefSpec{}
+	for _, branch := range branches {
+		refspecs = append(refspecs, config.RefSpec(fmt.Sprintf("+%s:%s", plumbing.NewBranchReferenceName(branch), plumbing.NewRemoteReferenceName(remote.Config().Name, branch))))
+	}
+	log.ForContext(ctx).WithField("refspec", refspecs).Debugf("fetching change branches")
+	err = remote.Fetch(&git.FetchOptions{
+		RemoteName: remote.Config().Name,
+		RefSpecs:   refspecs,
+		Auth:       r.gitAuth(endpoint),
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		log.ForContext(ctx).WithError(err).Error("failed to fetch change branches")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package maiao
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adevinta/maiao/pkg/api"
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownload(t *testing.T) {
+	colleague, remote, colleagueRepo := newTestStack(t,
+		"First change\n\nChange-Id: I1111111111111111111111111111111111111111",
+		"Second change\n\nChange-Id: I2222222222222222222222222222222222222222",
+		"Third change\n\nChange-Id: I3333333333333333333333333333333333333333",
+	)
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local"}
+	_, err := Review(context.Background(), colleagueRepo, options)
+	require.NoError(t, err)
+
+	work := cloneTestRemote(t, remote, "reviewer")
+	// the local backend keeps pull requests in the git directory, share them as a forge would
+	store, err := os.ReadFile(filepath.Join(colleague, ".git", api.LocalStorePath))
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(work, ".git", "maiao"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(work, ".git", api.LocalStorePath), store, 0o644))
+	repo, err := git.PlainOpen(work)
+	require.NoError(t, err)
+
+	require.NoError(t, Download(context.Background(), repo, options, "#3", ""))
+	assert.Equal(t, "review/3", gitCommand(t, work, "rev-parse", "--abbrev-ref", "HEAD"))
+	assert.Equal(t, gitCommand(t, colleague, "rev-parse", "HEAD"), gitCommand(t, work, "rev-parse", "HEAD"))
+	assert.Equal(t, "origin/main", gitCommand(t, work, "rev-parse", "--abbrev-ref", "review/3@{upstream}"))
+
+	t.Run("changes are downloaded by Change-Id in the requested branch", func(t *testing.T) {
+		require.NoError(t, Download(context.Background(), repo, options, "I2222222222222222222222222222222222222222", "second"))
+		assert.Equal(t, "second", gitCommand(t, work, "rev-parse", "--abbrev-ref", "HEAD"))
+		assert.Equal(t, gitCommand(t, colleague, "rev-parse", "HEAD~1"), gitCommand(t, work, "rev-parse", "HEAD"))
+		assert.Equal(t, "2", gitCommand(t, work, "rev-list", "--count", "origin/main..HEAD"))
+	})
+
+	t.Run("existing branches are not overwritten", func(t *testing.T) {
+		assert.Error(t, Download(context.Background(), repo, options, "3", ""))
+	})
+
+	t.Run("unknown changes are reported", func(t *testing.T) {
+		assert.Error(t, Download(context.Background(), repo, options, "I4444444444444444444444444444444444444444", ""))
+		assert.Error(t, Download(context.Background(), repo, options, "HEAD", ""))
+	})
+}
@@ -0,0 +1,176 @@
+package maiao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adevinta/maiao/pkg/api"
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sirupsen/logrus"
+)
+
+// GCOptions configures the pruning of stale change branches
+type GCOptions struct {
+	// OlderThan keeps the branches whose last commit is more recent than this duration
+	OlderThan time.Duration
+}
+
+// GC deletes the maiao.* branches of the push remote whose pull request is merged, closed or missing.
+// Only the branches whose last commit is authored by the current user are considered.
+// With options.DryRun, the stale branches are only listed
+func (r *Reviewer) GC(ctx context.Context, repo lgit.Repository, options ReviewOptions, gcOptions GCOptions) error {
+	defaultRemoteOption(ctx, repo, &options)
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context":   "pruning change branches",
+		"remote":    options.Remote,
+		"dryRun":    options.DryRun,
+		"olderThan": gcOptions.OlderThan,
+	})
+
+	remote, prAPI, err := r.newPullRequester(ctx, repo, &options)
+	if err != nil {
+		return err
+	}
+	forkOwner := ""
+	if options.PushRemote != "" && options.PushRemote != options.Remote {
+		forkOwner, _, err = forkRemote(repo, options.PushRemote)
+		if err != nil {
+			return err
+		}
+		remote, err = repo.Remote(options.PushRemote)
+		if err != nil {
+			return err
+		}
+	}
+	// fetching provides the commits of the branches, to check their author and age
+	err = r.fetchRemote(ctx, remote)
+	if err != nil {
+		return err
+	}
+	branches, err := r.changeBranches(ctx, repo, prAPI, remote)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Debug("failed to load git config")
+	}
+	email := currentUserEmail(cfg)
+	if email == "" {
+		return errors.New("unable to find the current user email, set the user.email git configuration")
+	}
+
+	stale := []string{}
+	for _, branch := range sortedKeys(branches) {
+		ctx := log.WithContextFields(ctx, logrus.Fields{"branch": branch})
+		commits, err := repo.Log(&git.LogOptions{From: branches[branch]})
+		if err != nil {
+			return err
+		}
+		commit, err := commits.Next()
+		commits.Close()
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(commit.Author.Email, email) {
+			log.ForContext(ctx).WithField("author", commit.Author.Email).Debug("skipping branch authored by someone else")
+			continue
+		}
+		if time.Since(commit.Committer.When) < gcOptions.OlderThan {
+			log.ForContext(ctx).WithField("committedAt", commit.Committer.When).Debug("skipping recent branch")
+			continue
+		}
+		head := branch
+		if forkOwner != "" {
+			head = forkOwner + ":" + head
+		}
+		status, err := prAPI.Find(ctx, head)
+		if err != nil {
+			return err
+		}
+		reason := "no PR"
+		if status != nil {
+			if status.State != api.PullRequestStateMerged && status.State != api.PullRequestStateClosed {
+				continue
+			}
+			reason = fmt.Sprintf("PR %s %s", status.URL, status.State)
+		}
+		if options.DryRun {
+			fmt.Fprintln(r.output(), fmt.Sprintf("would delete branch %s (%s)", branch, reason))
+			continue
+		}
+		log.ForContext(ctx).WithField("reason", reason).Debug("branch is stale")
+		stale = append(stale, branch)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	return r.deleteRemoteBranches(ctx, repo, prAPI, options, stale...)
+}
+
+// changeBranches lists the maiao.* branches of remote, with the commit they point to.
+// With backends keeping the branches of the changes local, the remote tracking branches of remote are listed instead
+func (r *Reviewer) changeBranches(ctx context.Context, repo lgit.Repository, prAPI api.PullRequester, remote *git.Remote) (map[string]plumbing.Hash, error) {
+	if keeper, ok := prAPI.(api.BranchKeeper); ok && keeper.KeepsBranchesLocal() {
+		tracking, err := lgit.RemoteTrackingBranches(repo, remote.Config().Name)
+		if err != nil {
+			return nil, err
+		}
+		branches := map[string]plumbing.Hash{}
+		for branch, hash := range tracking {
+			if strings.HasPrefix(branch, "maiao.") {
+				branches[branch] = hash
+			}
+		}
+		return branches, nil
+	}
+	if len(remote.Config().URLs) != 1 {
+		return nil, errors.New("multiple URLs not supported")
+	}
+	endpoint, err := transport.NewEndpoint(remote.Config().URLs[0])
+	if err != nil {
+		return nil, err
+	}
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth: r.gitAuth(endpoint),
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to list remote branches")
+		return nil, err
+	}
+	branches := map[string]plumbing.Hash{}
+	for _, ref := range refs {
+		if ref.Name().IsBranch() && strings.HasPrefix(ref.Name().Short(), "maiao.") {
+			branches[ref.Name().Short()] = ref.Hash()
+		}
+	}
+	return branches, nil
+}
+
+// currentUserEmail returns the email commits are authored with, honouring the GIT_AUTHOR_EMAIL environment variable like git does
+func currentUserEmail(cfg *config.Config) string {
+	if email := os.Getenv("GIT_AUTHOR_EMAIL"); email != "" {
+		return email
+	}
+	return lgit.ConfigOption(cfg, "user", "", "email")
+}
+
+func sortedKeys(m map[string]plumbing.Hash) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,53 @@
+package maiao
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adevinta/maiao/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGC(t *testing.T) {
+	t.Setenv("GIT_AUTHOR_EMAIL", "john.doe@example.com")
+	work, remote, repo := newTestStack(t,
+		"First change\n\nChange-Id: I1111111111111111111111111111111111111111",
+		"Second change\n\nChange-Id: I2222222222222222222222222222222222222222",
+	)
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local"}
+	_, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+	local := &api.Local{Path: filepath.Join(work, ".git", api.LocalStorePath)}
+	require.NoError(t, local.Close(context.Background(), &api.PullRequest{ID: "1"}, ""))
+
+	gitCommand(t, work, "push", "origin", "HEAD~1:refs/heads/maiao.I3333333333333333333333333333333333333333")
+	gitCommand(t, work, "commit", "--allow-empty", "--author", "Jane Doe <jane.doe@example.com>", "-m", "Someone else's change")
+	gitCommand(t, work, "push", "origin", "HEAD:refs/heads/maiao.I4444444444444444444444444444444444444444")
+
+	remoteBranches := func() string {
+		return gitCommand(t, remote, "branch", "--list", "maiao.*")
+	}
+	all := remoteBranches()
+
+	t.Run("dry runs do not delete anything", func(t *testing.T) {
+		dryRun := options
+		dryRun.DryRun = true
+		require.NoError(t, GC(context.Background(), repo, dryRun, GCOptions{}))
+		assert.Equal(t, all, remoteBranches())
+	})
+
+	t.Run("recent branches are kept", func(t *testing.T) {
+		require.NoError(t, GC(context.Background(), repo, options, GCOptions{OlderThan: 24 * time.Hour}))
+		assert.Equal(t, all, remoteBranches())
+	})
+
+	require.NoError(t, GC(context.Background(), repo, options, GCOptions{}))
+	branches := remoteBranches()
+	assert.NotContains(t, branches, "maiao.I1111111111111111111111111111111111111111", "branches of closed pull requests are deleted")
+	assert.Contains(t, branches, "maiao.I2222222222222222222222222222222222222222", "branches of open pull requests are kept")
+	assert.NotContains(t, branches, "maiao.I3333333333333333333333333333333333333333", "branches without pull request are deleted")
+	assert.Contains(t, branches, "maiao.I4444444444444444444444444444444444444444", "branches of other authors are kept")
+}
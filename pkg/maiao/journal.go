@@ -0,0 +1,239 @@
+package maiao
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/adevinta/maiao/pkg/api"
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/adevinta/maiao/pkg/system"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	// journalDir is where the journals of the reviews are kept, in the git directory
+	journalDir = "maiao/journal"
+	// backupRefPrefix prefixes the references keeping the local HEAD of the reviews before they ran
+	backupRefPrefix = "refs/maiao/backup/"
+	// journalLimit is the number of journals kept, the oldest ones are removed along with their backup references
+	journalLimit = 10
+)
+
+// reviewJournal records what a review changed, for Undo to restore it
+type reviewJournal struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Branch is the branch checked out when the review started, empty when HEAD was detached
+	Branch string `json:"branch,omitempty"`
+	// Head is the commit HEAD pointed to when the review started, also kept in the backup reference of the review
+	Head string `json:"head"`
+	// Options are the options of the review, without Confirm
+	Options ReviewOptions `json:"options"`
+	// Branches are the remote branches pushed by the review
+	Branches []journalBranch `json:"branches,omitempty"`
+	// PullRequests are the pull requests created or updated by the review
+	PullRequests []journalPullRequest `json:"pullRequests,omitempty"`
+
+	saved bool
+}
+
+// journalBranch records a branch of the push remote before it was pushed
+type journalBranch struct {
+	Name string `json:"name"`
+	// Previous is the commit the branch pointed to, empty when the review created it
+	Previous string `json:"previous,omitempty"`
+}
+
+// journalPullRequest records a pull request before it was updated
+type journalPullRequest struct {
+	api.PullRequest
+	// Head is the head of the pull request, <owner>:<branch> when it lives in a fork
+	Head string `json:"head"`
+	// Created tells the review opened the pull request, in which case it has no previous base, title and body
+	Created bool   `json:"created,omitempty"`
+	Base    string `json:"base,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Body    string `json:"body,omitempty"`
+}
+
+// newJournal starts the journal of a review run from head. Nothing is written until the review changes something.
+// It returns nil when the git directory of repo can't be found, in which case nothing is recorded
+func newJournal(repo lgit.Repository, head *plumbing.Reference, options ReviewOptions) *reviewJournal {
+	if repositoryGitDir(repo) == "" {
+		return nil
+	}
+	now := time.Now().UTC()
+	j := &reviewJournal{
+		ID:      now.Format("20060102T150405.000000Z"),
+		Time:    now,
+		Head:    head.Hash().String(),
+		Options: options,
+	}
+	if head.Name().IsBranch() {
+		j.Branch = head.Name().String()
+	}
+	return j
+}
+
+// backupRef returns the reference keeping the local HEAD from before the review
+func (j *reviewJournal) backupRef() plumbing.ReferenceName {
+	return plumbing.ReferenceName(backupRefPrefix + j.ID)
+}
+
+// recordBranch records the commit a branch of the push remote points to before the review pushes it, zero when missing.
+// Only the first state of the branch is kept
+func (j *reviewJournal) recordBranch(name string, previous plumbing.Hash) {
+	if j == nil {
+		return
+	}
+	for _, branch := range j.Branches {
+		if branch.Name == name {
+			return
+		}
+	}
+	branch := journalBranch{Name: name}
+	if !previous.IsZero() {
+		branch.Previous = previous.String()
+	}
+	j.Branches = append(j.Branches, branch)
+}
+
+// recordPullRequest records a pull request before the review updates it, or once it created it when status is nil.
+// Only the first state of the pull request is kept
+func (j *reviewJournal) recordPullRequest(pr api.PullRequest, head string, status *api.PullRequestStatus) {
+	if j == nil {
+		return
+	}
+	for _, recorded := range j.PullRequests {
+		if recorded.ID == pr.ID {
+			return
+		}
+	}
+	recorded := journalPullRequest{PullRequest: pr, Head: head, Created: status == nil}
+	if status != nil {
+		recorded.Base = status.Base
+		recorded.Title = status.Title
+		recorded.Body = status.Body
+	}
+	j.PullRequests = append(j.PullRequests, recorded)
+}
+
+// save writes the journal, creating its backup reference and removing the oldest journals the first time.
+// It is called before each change made by the review, and does nothing on nil journals
+func (j *reviewJournal) save(repo lgit.Repository) error {
+	if j == nil {
+		return nil
+	}
+	dir := filepath.Join(repositoryGitDir(repo), journalDir)
+	if !j.saved {
+		err := lgit.SetReference(repo, j.backupRef(), plumbing.NewHash(j.Head))
+		if err != nil {
+			return err
+		}
+		err = pruneJournals(repo, dir)
+		if err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = system.DefaultFileSystem.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	err = afero.WriteFile(system.DefaultFileSystem, filepath.Join(dir, j.ID+".json"), data, 0644)
+	if err != nil {
+		return err
+	}
+	j.saved = true
+	return nil
+}
+
+// remove deletes the journal and its backup reference
+func (j *reviewJournal) remove(repo lgit.Repository) error {
+	err := lgit.RemoveReference(repo, j.backupRef())
+	if err != nil {
+		return err
+	}
+	err = system.DefaultFileSystem.Remove(filepath.Join(repositoryGitDir(repo), journalDir, j.ID+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// journalIDs lists the IDs of the journals of dir, oldest first
+func journalIDs(dir string) ([]string, error) {
+	entries, err := afero.ReadDir(system.DefaultFileSystem, dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(entry.Name(), ".json"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// pruneJournals removes the oldest journals of dir, leaving room for a new one
+func pruneJournals(repo lgit.Repository, dir string) error {
+	ids, err := journalIDs(dir)
+	if err != nil {
+		return err
+	}
+	for len(ids) >= journalLimit {
+		err = (&reviewJournal{ID: ids[0]}).remove(repo)
+		if err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// loadJournal reads the journal identified by id, the most recent one when id is empty
+func loadJournal(repo lgit.Repository, id string) (*reviewJournal, error) {
+	gitDir := repositoryGitDir(repo)
+	if gitDir == "" {
+		return nil, fmt.Errorf("unable to find the git directory")
+	}
+	dir := filepath.Join(gitDir, journalDir)
+	if id == "" {
+		ids, err := journalIDs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("no review to undo")
+		}
+		id = ids[len(ids)-1]
+	}
+	data, err := afero.ReadFile(system.DefaultFileSystem, filepath.Join(dir, filepath.Base(id)+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no review %s to undo", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	j := &reviewJournal{saved: true}
+	err = json.Unmarshal(data, j)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
@@ -0,0 +1,267 @@
+package maiao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/adevinta/maiao/pkg/api"
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sirupsen/logrus"
+)
+
+// LandOptions configures the landing of changes
+type LandOptions struct {
+	// Method is the method pull requests are merged with, one of the api.MergeMethod* values.
+	// When empty, the maiao.mergeMethod git configuration is used, defaulting to api.MergeMethodMerge
+	Method string
+	// All keeps landing the next changes of the stack as long as their pull requests are approved and their checks pass
+	All bool
+}
+
+// Land merges the pull request of the lowest change of the stack, once it is approved and its checks pass.
+// The pull requests stacked on top of it are retargeted to the target branch, and the remaining local changes
+// are rebased on the updated target branch and pushed
+func (r *Reviewer) Land(ctx context.Context, repo lgit.Repository, options ReviewOptions, landOptions LandOptions) error {
+	if options.DryRun {
+		return errors.New("dry runs are not supported when landing changes")
+	}
+	defaultRemoteOption(ctx, repo, &options)
+	remote, prAPI, err := r.newPullRequester(ctx, repo, &options)
+	if err != nil {
+		return err
+	}
+	return r.land(ctx, repo, remote, prAPI, options, landOptions)
+}
+
+func (r *Reviewer) land(ctx context.Context, repo lgit.Repository, remote *git.Remote, prAPI api.PullRequester, options ReviewOptions, landOptions LandOptions) error {
+	merger, ok := prAPI.(api.PullRequestMerger)
+	if !ok {
+		return errors.New("the pull request backend does not support landing changes")
+	}
+	manager, ok := prAPI.(api.PullRequestManager)
+	if !ok {
+		return errors.New("the pull request backend does not support landing changes")
+	}
+	if landOptions.Method == "" {
+		cfg, err := repo.Config()
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Debug("failed to load git config")
+		}
+		landOptions.Method = lgit.ConfigOption(cfg, "maiao", "", "mergeMethod")
+	}
+	if landOptions.Method == "" {
+		landOptions.Method = api.MergeMethodMerge
+	}
+	err := validateMergeMethod(landOptions.Method)
+	if err != nil {
+		return err
+	}
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context": "landing changes",
+		"remote":  options.Remote,
+		"branch":  options.Branch,
+		"method":  landOptions.Method,
+	})
+
+	pushRemote := options.Remote
+	forkOwner := ""
+	if options.PushRemote != "" && options.PushRemote != options.Remote {
+		pushRemote = options.PushRemote
+		forkOwner, _, err = forkRemote(repo, pushRemote)
+		if err != nil {
+			return err
+		}
+	}
+	_, pushesChanges := prAPI.(api.ChangePusher)
+	remoteRef := plumbing.Revision(fmt.Sprintf("%s/%s", options.Remote, options.Branch))
+	start, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	// the retargeted pull requests and the restacked branches are recorded for git review undo, merges are not
+	journal := newJournal(repo, start, options)
+
+	for landed := 0; ; landed++ {
+		err := r.fetchRemote(ctx, remote)
+		if err != nil {
+			return err
+		}
+		if pushRemote != options.Remote {
+			fork, err := repo.Remote(pushRemote)
+			if err != nil {
+				return err
+			}
+			err = r.fetchRemote(ctx, fork)
+			if err != nil {
+				return err
+			}
+		}
+		head, err := repo.Head()
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to retrieve git HEAD")
+			return err
+		}
+		base, err := r.git().MergeBase(ctx, repo, remoteRef, plumbing.Revision(plumbing.HEAD))
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Errorf("unable to find common ancestor")
+			return err
+		}
+		changes, err := extractChanges(ctx, repo, base, head.Hash())
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			if landed == 0 {
+				fmt.Fprintln(r.output(), "nothing to land")
+			}
+			return nil
+		}
+
+		bottom := changes[0]
+		if bottom.changeID == "" {
+			return fmt.Errorf("commit %s does not have any Change-Id, run git review first", bottom.head.Hash.String())
+		}
+		prHead := bottom.branch
+		if forkOwner != "" {
+			prHead = forkOwner + ":" + bottom.branch
+		}
+		status, err := prAPI.Find(ctx, prHead)
+		if err != nil {
+			return err
+		}
+		if status == nil {
+			return fmt.Errorf("no pull request found for change %s, run git review first", bottom.changeID)
+		}
+		if !pushesChanges {
+			remoteHash, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewRemoteReferenceName(pushRemote, bottom.branch)))
+			if err != nil || *remoteHash != bottom.head.Hash {
+				return fmt.Errorf("PR %s does not match the local change %s, run git review first", status.URL, bottom.changeID)
+			}
+		}
+		if reason := landBlocker(status); reason != "" {
+			if landed == 0 {
+				return fmt.Errorf("PR %s is not ready to land: %s", status.URL, reason)
+			}
+			fmt.Fprintln(r.output(), fmt.Sprintf("stopped landing at PR %s: %s", status.URL, reason))
+			return nil
+		}
+		// merging into a stale parent branch would not bring anything to the target branch
+		if status.Base != options.Branch {
+			return fmt.Errorf("PR %s targets %s instead of %s, run git review first to retarget it", status.URL, status.Base, options.Branch)
+		}
+
+		ctx := log.WithContextFields(ctx, logrus.Fields{
+			"prID": status.ID,
+			"head": status.Head,
+		})
+		err = merger.Merge(ctx, &status.PullRequest, landOptions.Method)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(r.output(), fmt.Sprintf("merged PR %s", status.URL))
+		// the next changes are retargeted right away, so they stay open if the forge deletes the merged branch
+		children, err := manager.ListOpen(ctx, status.Head)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			journal.recordPullRequest(child.PullRequest, child.Head, child)
+			err = journal.save(repo)
+			if err != nil {
+				return err
+			}
+			err = manager.Retarget(ctx, &child.PullRequest, status.Base)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(r.output(), fmt.Sprintf("retargeted PR %s to %s", child.URL, status.Base))
+		}
+		if len(changes) == 1 {
+			return nil
+		}
+
+		err = r.restack(ctx, repo, remote, prAPI, options, journal, bottom, head.Hash())
+		if err != nil {
+			return err
+		}
+		if !landOptions.All {
+			return nil
+		}
+	}
+}
+
+func validateMergeMethod(method string) error {
+	switch method {
+	case api.MergeMethodMerge, api.MergeMethodSquash, api.MergeMethodRebase:
+		return nil
+	}
+	return fmt.Errorf("unsupported merge method %s, expecting %s, %s or %s", method, api.MergeMethodMerge, api.MergeMethodSquash, api.MergeMethodRebase)
+}
+
+// landBlocker returns why the pull request can't be landed, or an empty string when it is ready.
+// Review decisions and checks not reported by the forge do not block landing
+func landBlocker(status *api.PullRequestStatus) string {
+	switch status.State {
+	case api.PullRequestStateDraft:
+		return "it is a draft"
+	case api.PullRequestStateMerged:
+		return "it is already merged"
+	case api.PullRequestStateClosed:
+		return "it is closed"
+	}
+	switch status.ReviewDecision {
+	case api.ReviewDecisionChangesRequested:
+		return "changes are requested"
+	case api.ReviewDecisionRequired:
+		return "it is not approved yet"
+	}
+	switch status.Checks {
+	case api.ChecksFailure:
+		return "checks are failing"
+	case api.ChecksPending:
+		return "checks are pending"
+	}
+	return ""
+}
+
+// restack submits the changes stacked on top of the landed one again, after rebasing them on the updated target branch
+// when the landed commits are not part of it as is, like when they are squashed or rebased.
+// The pushed branches and updated pull requests are recorded in journal
+func (r *Reviewer) restack(ctx context.Context, repo lgit.Repository, remote *git.Remote, prAPI api.PullRequester, options ReviewOptions, journal *reviewJournal, landed *change, head plumbing.Hash) error {
+	err := r.fetchRemote(ctx, remote)
+	if err != nil {
+		return err
+	}
+	remoteHead, err := repo.ResolveRevision(plumbing.Revision(fmt.Sprintf("%s/%s", options.Remote, options.Branch)))
+	if err != nil {
+		return err
+	}
+	merged, err := r.git().MergeBase(ctx, repo, plumbing.Revision(remoteHead.String()), plumbing.Revision(landed.head.Hash.String()))
+	if err != nil {
+		return err
+	}
+	if merged == landed.head.Hash {
+		log.ForContext(ctx).Debug("landed change is part of the target branch, no rebase needed")
+		_, err = r.sendPrs(ctx, repo, prAPI, options, journal, landed.head.Hash, head)
+		return err
+	}
+	base, err := r.git().MergeBase(ctx, repo, plumbing.Revision(remoteHead.String()), plumbing.Revision(head.String()))
+	if err != nil {
+		return err
+	}
+	log.ForContext(ctx).WithField("remoteSha", remoteHead.String()).Debug("rebasing the remaining changes on the target branch")
+	rebased, resumed, err := r.rebaseCommits(ctx, repo, options, journal, base, *remoteHead, head, landed.changeID)
+	if err != nil || !rebased || resumed {
+		return err
+	}
+	rebasedHead, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	_, err = r.sendPrs(ctx, repo, prAPI, options, journal, *remoteHead, rebasedHead.Hash())
+	return err
+}
@@ -0,0 +1,188 @@
+package maiao
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adevinta/maiao/pkg/api"
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fastForwardMerger mimics a forge merging pull requests by fast-forwarding their base branch in the remote repository
+type fastForwardMerger struct {
+	*api.Local
+	t      *testing.T
+	remote string
+}
+
+func (m *fastForwardMerger) Merge(ctx context.Context, pr *api.PullRequest, method string) error {
+	status, err := m.Get(ctx, pr.ID)
+	if err != nil {
+		return err
+	}
+	gitCommand(m.t, m.remote, "update-ref", "refs/heads/"+status.Base, "refs/heads/"+status.Head)
+	return m.Local.Merge(ctx, pr, method)
+}
+
+func TestLand(t *testing.T) {
+	work, remote, repo := newTestStack(t,
+		"First change\n\nChange-Id: I1111111111111111111111111111111111111111",
+		"Second change\n\nChange-Id: I2222222222222222222222222222222222222222",
+		"Third change\n\nChange-Id: I3333333333333333333333333333333333333333",
+	)
+	first := gitCommand(t, work, "rev-parse", "HEAD~2")
+	second := gitCommand(t, work, "rev-parse", "HEAD~1")
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local"}
+	_, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+	local := &api.Local{Path: filepath.Join(work, ".git", api.LocalStorePath), PushBranches: true}
+	merger := &fastForwardMerger{Local: local, t: t, remote: remote}
+	reviewer := &Reviewer{
+		NewPullRequester: func(ctx context.Context, remote *git.Remote, options api.BackendOptions) (api.PullRequester, error) {
+			return merger, nil
+		},
+	}
+
+	t.Run("unknown merge methods are rejected", func(t *testing.T) {
+		err := reviewer.Land(context.Background(), repo, options, LandOptions{Method: "octopus"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unsupported merge method")
+	})
+
+	t.Run("the lowest change is merged and the next one retargeted", func(t *testing.T) {
+		require.NoError(t, reviewer.Land(context.Background(), repo, options, LandOptions{}))
+		assert.Equal(t, first, gitCommand(t, remote, "rev-parse", "main"))
+		prs, err := local.PullRequests()
+		require.NoError(t, err)
+		require.Len(t, prs, 3)
+		assert.True(t, prs[0].Merged)
+		assert.False(t, prs[1].Merged)
+		assert.Equal(t, "main", prs[1].Base)
+		assert.Equal(t, "maiao.I2222222222222222222222222222222222222222", prs[2].Base)
+		assert.NotContains(t, prs[1].Body, "I1111111111111111111111111111111111111111", "the landed change must be dropped from the stack")
+	})
+
+	// pull requests are marked as draft on the forge, the store is edited directly
+	b, err := os.ReadFile(local.Path)
+	require.NoError(t, err)
+	store := struct {
+		LastID       int                     `json:"lastID"`
+		PullRequests []*api.LocalPullRequest `json:"pullRequests"`
+	}{}
+	require.NoError(t, json.Unmarshal(b, &store))
+	store.PullRequests[2].Draft = true
+	b, err = json.Marshal(store)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(local.Path, b, 0o644))
+
+	t.Run("landing all changes stops at the first one not ready", func(t *testing.T) {
+		require.NoError(t, reviewer.Land(context.Background(), repo, options, LandOptions{Method: api.MergeMethodSquash, All: true}))
+		assert.Equal(t, second, gitCommand(t, remote, "rev-parse", "main"))
+		prs, err := local.PullRequests()
+		require.NoError(t, err)
+		assert.True(t, prs[1].Merged)
+		assert.False(t, prs[2].Merged)
+		assert.Equal(t, "main", prs[2].Base)
+	})
+
+	t.Run("changes not ready are not landed", func(t *testing.T) {
+		err := reviewer.Land(context.Background(), repo, options, LandOptions{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is not ready to land: it is a draft")
+	})
+}
+
+func TestLandRefusesPullRequestsNotTargetingTheBranch(t *testing.T) {
+	work, remote, repo := newTestStack(t,
+		"First change\n\nChange-Id: I1111111111111111111111111111111111111111",
+		"Second change\n\nChange-Id: I2222222222222222222222222222222222222222",
+	)
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local"}
+	_, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+	initial := gitCommand(t, remote, "rev-parse", "main")
+	local := &api.Local{Path: filepath.Join(work, ".git", api.LocalStorePath)}
+	status, err := local.Find(context.Background(), "maiao.I1111111111111111111111111111111111111111")
+	require.NoError(t, err)
+	// the pull request was retargeted by hand on the forge
+	require.NoError(t, local.Retarget(context.Background(), &status.PullRequest, "maiao.I0000000000000000000000000000000000000000"))
+	merger := &fastForwardMerger{Local: local, t: t, remote: remote}
+	reviewer := &Reviewer{
+		NewPullRequester: func(ctx context.Context, remote *git.Remote, options api.BackendOptions) (api.PullRequester, error) {
+			return merger, nil
+		},
+	}
+
+	err = reviewer.Land(context.Background(), repo, options, LandOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "targets maiao.I0000000000000000000000000000000000000000 instead of main")
+	prs, err := local.PullRequests()
+	require.NoError(t, err)
+	assert.False(t, prs[0].Merged)
+	assert.Equal(t, initial, gitCommand(t, remote, "rev-parse", "main"))
+}
+
+// squashMerger mimics a forge squashing pull requests into a new commit of their base branch in the remote repository
+type squashMerger struct {
+	*api.Local
+	t      *testing.T
+	remote string
+}
+
+func (m *squashMerger) Merge(ctx context.Context, pr *api.PullRequest, method string) error {
+	status, err := m.Get(ctx, pr.ID)
+	if err != nil {
+		return err
+	}
+	squashed := gitCommand(m.t, m.remote, "-c", "user.name=Forge", "-c", "user.email=forge@example.com", "commit-tree", status.Head+"^{tree}", "-p", status.Base, "-m", "Squashed")
+	gitCommand(m.t, m.remote, "update-ref", "refs/heads/"+status.Base, squashed)
+	return m.Local.Merge(ctx, pr, method)
+}
+
+func TestUndoLand(t *testing.T) {
+	work, remote, repo := newTestStack(t,
+		"First change\n\nChange-Id: I1111111111111111111111111111111111111111",
+		"Second change\n\nChange-Id: I2222222222222222222222222222222222222222",
+	)
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local"}
+	_, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+	head := gitCommand(t, work, "rev-parse", "HEAD")
+	second := gitCommand(t, remote, "rev-parse", "maiao.I2222222222222222222222222222222222222222")
+	local := &api.Local{Path: filepath.Join(work, ".git", api.LocalStorePath), PushBranches: true}
+	merger := &squashMerger{Local: local, t: t, remote: remote}
+	reviewer := &Reviewer{
+		NewPullRequester: func(ctx context.Context, remote *git.Remote, options api.BackendOptions) (api.PullRequester, error) {
+			return merger, nil
+		},
+	}
+
+	// the landed change is squashed, the second one is rebased on the squashed commit
+	require.NoError(t, reviewer.Land(context.Background(), repo, options, LandOptions{}))
+	require.NotEqual(t, second, gitCommand(t, remote, "rev-parse", "maiao.I2222222222222222222222222222222222222222"))
+	ids, err := journalIDs(filepath.Join(work, ".git", journalDir))
+	require.NoError(t, err)
+	require.Len(t, ids, 2, "the land must be recorded next to the review")
+
+	require.NoError(t, Undo(context.Background(), repo, UndoOptions{Remote: true}))
+	assert.Equal(t, head, gitCommand(t, work, "rev-parse", "HEAD"))
+	assert.Equal(t, second, gitCommand(t, remote, "rev-parse", "maiao.I2222222222222222222222222222222222222222"))
+	prs, err := local.PullRequests()
+	require.NoError(t, err)
+	require.Len(t, prs, 2)
+	assert.True(t, prs[0].Merged, "merges are not undone")
+	assert.Equal(t, "maiao.I1111111111111111111111111111111111111111", prs[1].Base)
+}
+
+func TestLandBlocker(t *testing.T) {
+	assert.Empty(t, landBlocker(&api.PullRequestStatus{State: api.PullRequestStateOpen}))
+	assert.Empty(t, landBlocker(&api.PullRequestStatus{State: api.PullRequestStateOpen, ReviewDecision: api.ReviewDecisionApproved, Checks: api.ChecksSuccess}))
+	assert.Equal(t, "it is a draft", landBlocker(&api.PullRequestStatus{State: api.PullRequestStateDraft}))
+	assert.Equal(t, "it is not approved yet", landBlocker(&api.PullRequestStatus{State: api.PullRequestStateOpen, ReviewDecision: api.ReviewDecisionRequired}))
+	assert.Equal(t, "checks are failing", landBlocker(&api.PullRequestStatus{State: api.PullRequestStateOpen, Checks: api.ChecksFailure}))
+}
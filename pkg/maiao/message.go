@@ -3,12 +3,16 @@ package maiao
 import (
 	"crypto/sha1"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/adevinta/maiao/pkg/api"
 	lgit "github.com/adevinta/maiao/pkg/git"
 )
 
+// stackMarkerRegex matches the hidden marker listing the Change-Ids of the stack a pull request was submitted with
+var stackMarkerRegex = regexp.MustCompile(`<!-- maiao-stack: ([^>]*) -->`)
+
 func details(body []string, summary string) []string {
 	r := []string{"<details>"}
 	if summary != "" {
@@ -54,6 +58,27 @@ func changeDetails(changes []*change) []string {
 	return r
 }
 
+// stackMarker returns a marker, hidden when rendering the pull request description, listing the Change-Ids of the stack.
+// It allows to find changes removed from the stack on the next review
+func stackMarker(changes []*change) string {
+	changeIDs := []string{}
+	for _, change := range changes {
+		if change.changeID != "" {
+			changeIDs = append(changeIDs, change.changeID)
+		}
+	}
+	return fmt.Sprintf("<!-- maiao-stack: %s -->", strings.Join(changeIDs, " "))
+}
+
+// parseStackMarker returns the Change-Ids listed in the stack marker of body, if any
+func parseStackMarker(body string) []string {
+	match := stackMarkerRegex.FindStringSubmatch(body)
+	if match == nil {
+		return nil
+	}
+	return strings.Fields(match[1])
+}
+
 func relatedChanges(parents, futures []*change) []string {
 	if len(parents) == 0 && len(futures) == 0 {
 		return []string{}
@@ -91,8 +116,11 @@ func prOptions(repo lgit.Repository, prAPI api.PullRequester, options ReviewOpti
 	if options.Topic != "" {
 		additions = append(additions, topicDetails(prAPI, options.Topic)...)
 	}
+	// cap the parents to copy them instead of overwriting the changes that follow in the underlying array
+	stack := append(append(parents[:len(parents):len(parents)], change), futures...)
+	additions = append(additions, stackMarker(stack))
 
-	return api.PullRequestOptions{
+	opts := api.PullRequestOptions{
 		Base:  base,
 		Head:  change.branch,
 		Title: title,
@@ -100,4 +128,9 @@ func prOptions(repo lgit.Repository, prAPI api.PullRequester, options ReviewOpti
 		Ready: options.Ready,
 		WIP:   options.WorkInProgress,
 	}
+	// pull requests stacked on other changes would be merged in their parent branch, not in the target branch
+	if base == options.Branch || options.AutoMergeStacked {
+		opts.AutoMerge = options.AutoMerge
+	}
+	return opts
 }
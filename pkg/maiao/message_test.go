@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/adevinta/maiao/pkg/api"
+	lgit "github.com/adevinta/maiao/pkg/git"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -71,3 +72,22 @@ type linkedTopicIssuesFunc struct {
 func (l linkedTopicIssuesFunc) LinkedTopicIssues(topicSearchString string) string {
 	return l.linkedTopicIssuesFunc(topicSearchString)
 }
+
+func TestStackMarker(t *testing.T) {
+	marker := stackMarker([]*change{{changeID: "I1"}, {}, {changeID: "I2"}})
+	assert.Equal(t, "<!-- maiao-stack: I1 I2 -->", marker)
+	assert.Equal(t, []string{"I1", "I2"}, parseStackMarker("description\n"+marker+"\n"))
+	assert.Nil(t, parseStackMarker("description without marker"))
+}
+
+func TestPrOptionsAutoMerge(t *testing.T) {
+	parent := &change{changeID: "I1", branch: "maiao.I1", message: &lgit.Message{Title: "first"}}
+	child := &change{changeID: "I2", branch: "maiao.I2", message: &lgit.Message{Title: "second"}, parent: parent}
+	options := ReviewOptions{Branch: "main", AutoMerge: api.MergeMethodSquash}
+
+	assert.Equal(t, api.MergeMethodSquash, prOptions(&testRepository{}, nil, options, parent, nil, []*change{child}).AutoMerge)
+	assert.Empty(t, prOptions(&testRepository{}, nil, options, child, []*change{parent}, nil).AutoMerge, "stacked pull requests must not be merged in their parent branch")
+
+	options.AutoMergeStacked = true
+	assert.Equal(t, api.MergeMethodSquash, prOptions(&testRepository{}, nil, options, child, []*change{parent}, nil).AutoMerge)
+}
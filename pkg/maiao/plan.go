@@ -0,0 +1,127 @@
+package maiao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adevinta/maiao/pkg/api"
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// planReview prints the actions Review would take to submit the changes between base and head,
+// rebasing them on remoteHead first when rebase is set.
+// Nothing is changed on the remote nor in the local references, pull requests are only looked up
+func (r *Reviewer) planReview(ctx context.Context, repo lgit.Repository, prAPI api.PullRequester, options ReviewOptions, base, remoteHead, head plumbing.Hash, rebase bool) error {
+	changes, err := extractChanges(ctx, repo, base, head)
+	if err != nil {
+		return err
+	}
+	if rebase {
+		fmt.Fprintln(r.output(), fmt.Sprintf("would rebase the stack on %s/%s (%s)", options.Remote, options.Branch, remoteHead.String()))
+		knownChangeIDs, err := extractChangeIDs(ctx, repo, base, remoteHead)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if _, ok := knownChangeIDs[change.changeID]; ok && change.changeID != "" {
+				fmt.Fprintln(r.output(), fmt.Sprintf("would drop change %s (%s), already merged", change.changeID, change.message.GetTitle()))
+			}
+		}
+		changes = removeMergedChangeIDs(changes, knownChangeIDs)
+	}
+	for _, change := range changes {
+		if change.changeID == "" {
+			fmt.Fprintln(r.output(), fmt.Sprintf("would add a Change-Id to commit %s (%s)", change.commits[0].Hash.String(), change.message.GetTitle()))
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(r.output(), "nothing to review")
+		return nil
+	}
+
+	if pusher, ok := prAPI.(api.ChangePusher); ok {
+		fmt.Fprintln(r.output(), fmt.Sprintf("would push %d changes to %s", len(changes), pusher.PushRef(api.PushOptions{
+			Branch: options.Branch,
+			Topic:  options.Topic,
+			WIP:    options.WorkInProgress,
+			Ready:  options.Ready,
+		})))
+		for _, change := range changes {
+			if change.changeID == "" {
+				fmt.Fprintln(r.output(), fmt.Sprintf("would create a change for %s", change.message.GetTitle()))
+				continue
+			}
+			pr, err := pusher.FindChange(ctx, change.changeID, options.Branch)
+			if err != nil {
+				return err
+			}
+			if pr == nil {
+				fmt.Fprintln(r.output(), fmt.Sprintf("would create a change for %s", change.message.GetTitle()))
+			} else {
+				fmt.Fprintln(r.output(), fmt.Sprintf("would update change %s", pr.URL))
+			}
+		}
+		return nil
+	}
+
+	pushRemote := options.Remote
+	forkOwner := ""
+	if options.PushRemote != "" && options.PushRemote != options.Remote {
+		pushRemote = options.PushRemote
+		forkOwner, _, err = forkRemote(repo, pushRemote)
+		if err != nil {
+			return err
+		}
+	}
+
+	statuses, err := findPullRequests(ctx, prAPI, changes, forkOwner)
+	if err != nil {
+		return err
+	}
+	var parent *change
+	for i, change := range changes {
+		change.parent = parent
+		parent = change
+		if change.branch == "" {
+			// the branch is named after the Change-Id to be added
+			fmt.Fprintln(r.output(), fmt.Sprintf("would push a new branch for %s", change.message.GetTitle()))
+			fmt.Fprintln(r.output(), fmt.Sprintf("would create a PR for %s", change.message.GetTitle()))
+			continue
+		}
+		remoteHash, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewRemoteReferenceName(pushRemote, change.branch)))
+		switch {
+		case err != nil:
+			fmt.Fprintln(r.output(), fmt.Sprintf("would create branch %s on %s", change.branch, pushRemote))
+		case rebase:
+			fmt.Fprintln(r.output(), fmt.Sprintf("would push branch %s once rebased", change.branch))
+		case *remoteHash == change.head.Hash:
+			fmt.Fprintln(r.output(), fmt.Sprintf("branch %s is up to date", change.branch))
+		default:
+			fmt.Fprintln(r.output(), fmt.Sprintf("would push branch %s (%s -> %s)", change.branch, remoteHash.String(), change.head.Hash.String()))
+		}
+
+		if status, ok := statuses[change.branch]; ok && (status.State == api.PullRequestStateOpen || status.State == api.PullRequestStateDraft) {
+			change.pr = &status.PullRequest
+		}
+		opts := forkPROptions(prOptions(repo, prAPI, options, change, changes[:i], changes[i+1:]), options, forkOwner)
+		autoMerge := ""
+		if opts.AutoMerge != "" {
+			autoMerge = fmt.Sprintf(", with %s auto-merge", opts.AutoMerge)
+		}
+		if change.pr == nil {
+			fmt.Fprintln(r.output(), fmt.Sprintf("would create a PR from %s to %s titled %q%s", opts.Head, opts.Base, opts.Title, autoMerge))
+		} else {
+			fmt.Fprintln(r.output(), fmt.Sprintf("would update PR %s to %s titled %q%s", change.pr.URL, opts.Base, opts.Title, autoMerge))
+		}
+	}
+
+	for _, changeID := range previousStack(changes, statuses) {
+		if options.CloseRemoved {
+			fmt.Fprintln(r.output(), fmt.Sprintf("would close the PR of change %s, removed from the stack", changeID))
+		} else {
+			fmt.Fprintln(r.output(), fmt.Sprintf("would ask to close the PR of change %s, removed from the stack", changeID))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package maiao
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adevinta/maiao/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReviewDryRun(t *testing.T) {
+	work, remote, repo := newTestStack(t,
+		"First change\n\nChange-Id: I1111111111111111111111111111111111111111",
+		"Second change\n\nChange-Id: I2222222222222222222222222222222222222222",
+	)
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local", DryRun: true}
+	local := &api.Local{Path: filepath.Join(work, ".git", api.LocalStorePath)}
+
+	t.Run("new changes are neither pushed nor submitted", func(t *testing.T) {
+		_, err := Review(context.Background(), repo, options)
+		require.NoError(t, err)
+		assert.Empty(t, gitCommand(t, remote, "branch", "--list", "maiao.*"))
+		_, err = os.Stat(local.Path)
+		assert.True(t, os.IsNotExist(err), "no pull request must be recorded")
+	})
+
+	options.DryRun = false
+	_, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+	options.DryRun = true
+	branches := gitCommand(t, remote, "branch", "-v", "--list", "maiao.*")
+	prs, err := local.PullRequests()
+	require.NoError(t, err)
+
+	t.Run("submitted changes are not updated", func(t *testing.T) {
+		gitCommand(t, work, "commit", "--allow-empty", "-m", "Third change", "-m", "Change-Id: I3333333333333333333333333333333333333333")
+		head := gitCommand(t, work, "rev-parse", "HEAD")
+		_, err := Review(context.Background(), repo, options)
+		require.NoError(t, err)
+		assert.Equal(t, branches, gitCommand(t, remote, "branch", "-v", "--list", "maiao.*"))
+		updated, err := local.PullRequests()
+		require.NoError(t, err)
+		assert.Equal(t, prs, updated)
+		assert.Equal(t, head, gitCommand(t, work, "rev-parse", "HEAD"))
+	})
+
+	t.Run("stacks needing a rebase are neither fetched nor rebased", func(t *testing.T) {
+		other := cloneTestRemote(t, remote, "other")
+		gitCommand(t, other, "commit", "--allow-empty", "-m", "Someone else's change")
+		gitCommand(t, other, "push", "origin", "main")
+		gitCommand(t, work, "fetch", "origin")
+		gitCommand(t, work, "commit", "--allow-empty", "-m", "Fourth change")
+		head := gitCommand(t, work, "rev-parse", "HEAD")
+		tracking := gitCommand(t, work, "rev-parse", "origin/main")
+		_, err := Review(context.Background(), repo, options)
+		require.NoError(t, err)
+		assert.Equal(t, head, gitCommand(t, work, "rev-parse", "HEAD"))
+		assert.Equal(t, tracking, gitCommand(t, work, "rev-parse", "origin/main"))
+		assert.Equal(t, branches, gitCommand(t, remote, "branch", "-v", "--list", "maiao.*"))
+	})
+}
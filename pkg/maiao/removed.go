@@ -0,0 +1,111 @@
+package maiao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adevinta/maiao/pkg/api"
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+// removedChangeComment is the comment left on pull requests closed because their change was removed from the stack
+const removedChangeComment = "This change was removed from the stack it was submitted with."
+
+// findPullRequests returns the most recent pull request of each change with a branch, indexed by branch
+func findPullRequests(ctx context.Context, prAPI api.PullRequester, changes []*change, forkOwner string) (map[string]*api.PullRequestStatus, error) {
+	statuses := map[string]*api.PullRequestStatus{}
+	for _, change := range changes {
+		if change.branch == "" {
+			continue
+		}
+		status, err := prAPI.Find(ctx, prHead(change.branch, forkOwner))
+		if err != nil {
+			return nil, err
+		}
+		if status != nil {
+			statuses[change.branch] = status
+		}
+	}
+	return statuses, nil
+}
+
+// prHead returns the head of the pull request of branch, prefixed with the owner of the fork it lives in if any
+func prHead(branch, forkOwner string) string {
+	if forkOwner != "" {
+		return forkOwner + ":" + branch
+	}
+	return branch
+}
+
+// previousStack returns the Change-Ids of the stack the changes were last submitted with,
+// according to the stack markers of their pull requests, the current changes excluded
+func previousStack(changes []*change, statuses map[string]*api.PullRequestStatus) []string {
+	current := map[string]struct{}{}
+	for _, change := range changes {
+		current[change.changeID] = struct{}{}
+	}
+	removed := []string{}
+	for _, change := range changes {
+		status, ok := statuses[change.branch]
+		if !ok {
+			continue
+		}
+		for _, changeID := range parseStackMarker(status.Body) {
+			if _, ok := current[changeID]; !ok {
+				current[changeID] = struct{}{}
+				removed = append(removed, changeID)
+			}
+		}
+	}
+	return removed
+}
+
+// closeRemovedChanges closes the pull requests still open for the removed changes, and deletes their branches.
+// Unless options.CloseRemoved is set, options.Confirm is asked for a confirmation for each of them
+func (r *Reviewer) closeRemovedChanges(ctx context.Context, repo lgit.Repository, prAPI api.PullRequester, options ReviewOptions, removed []string, forkOwner string) error {
+	if len(removed) == 0 {
+		return nil
+	}
+	manager, ok := prAPI.(api.PullRequestManager)
+	if !ok {
+		log.ForContext(ctx).WithField("changeIDs", removed).Warn("the pull request backend can't close the pull requests of changes removed from the stack")
+		return nil
+	}
+	for _, changeID := range removed {
+		ctx := log.WithContextFields(ctx, logrus.Fields{
+			"context":  "closing removed change",
+			"changeID": changeID,
+		})
+		head := "maiao." + changeID
+		if forkOwner != "" {
+			head = forkOwner + ":" + head
+		}
+		status, err := prAPI.Find(ctx, head)
+		if err != nil {
+			return err
+		}
+		if status == nil || (status.State != api.PullRequestStateOpen && status.State != api.PullRequestStateDraft) {
+			log.ForContext(ctx).Debug("removed change has no open pull request")
+			continue
+		}
+		switch {
+		case options.CloseRemoved:
+		case options.Confirm != nil && options.Confirm(fmt.Sprintf("change %s was removed from the stack, close its PR %s", changeID, status.URL)):
+		default:
+			fmt.Fprintln(r.output(), fmt.Sprintf("change %s was removed from the stack, its PR %s is still open", changeID, status.URL))
+			continue
+		}
+		err = manager.Close(ctx, &status.PullRequest, removedChangeComment)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(r.output(), fmt.Sprintf("closed PR %s", status.URL))
+		err = r.deleteRemoteBranches(ctx, repo, prAPI, options, "maiao."+changeID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package maiao
+
+import (
+	"github.com/adevinta/maiao/pkg/api"
+)
+
+// Actions taken on a change during a review, as reported in ChangeResult
+const (
+	ChangeCreated   = "created"
+	ChangeUpdated   = "updated"
+	ChangeUnchanged = "unchanged"
+)
+
+// ReviewResult describes what a review did
+type ReviewResult struct {
+	// Rebased tells whether the stack was rebased before being submitted
+	Rebased bool `json:"rebased"`
+	// Resumed tells the rebase resumes the review of the rebased changes by itself, like lgit.CLIExecutor does,
+	// in which case Changes is empty
+	Resumed bool `json:"-"`
+	// Changes are the submitted changes, oldest first
+	Changes []ChangeResult `json:"changes"`
+}
+
+// ChangeResult describes a submitted change and its pull request
+type ChangeResult struct {
+	ChangeID string `json:"changeId"`
+	// Commits are the SHAs of the commits of the change, its fixups included, oldest first
+	Commits []string `json:"commits"`
+	// Branch is the branch the change is pushed to, empty for backends pushing changes for review
+	Branch string `json:"branch,omitempty"`
+	// Base is the branch the pull request targets
+	Base string `json:"base"`
+	// PR is the number of the pull request
+	PR  string `json:"pr"`
+	URL string `json:"url"`
+	// Action is one of ChangeCreated, ChangeUpdated or ChangeUnchanged.
+	// Changes are unchanged when their branch and their base were already up to date, their description may still be updated
+	Action string `json:"action"`
+	Draft  bool   `json:"draft"`
+}
+
+// newChangeResult describes change once its pull request was submitted to base
+func newChangeResult(change *change, base, action string, draft bool) ChangeResult {
+	commits := []string{}
+	for _, commit := range change.commits {
+		commits = append(commits, commit.Hash.String())
+	}
+	result := ChangeResult{
+		ChangeID: change.changeID,
+		Commits:  commits,
+		Branch:   change.branch,
+		Base:     base,
+		Action:   action,
+		Draft:    draft,
+	}
+	if change.pr != nil {
+		result.PR = change.pr.ID
+		result.URL = change.pr.URL
+	}
+	return result
+}
+
+// existingDraft tells whether the pull request is a draft after being updated with options
+func existingDraft(status *api.PullRequestStatus, options ReviewOptions) bool {
+	return status != nil && status.State == api.PullRequestStateDraft && !options.Ready
+}
@@ -0,0 +1,243 @@
+package maiao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Actions resuming a rebase run with git rebase -i
+const (
+	rebaseContinue = "continue"
+	rebaseSkip     = "skip"
+	rebaseAbort    = "abort"
+)
+
+// errNoStoppedReview is returned when continuing, skipping or aborting while no review is stopped on conflicts
+var errNoStoppedReview = errors.New("no review is stopped on a conflicting rebase")
+
+// checkStoppedReview fails when a review is stopped on conflicts, to be continued, skipped or aborted first.
+// The state of a review rebased with git rebase -i is dropped by the review resumed by the exec step of the rebase
+// once it is continued with git itself, as this review takes over
+func checkStoppedReview(ctx context.Context, repo lgit.Repository, options ReviewOptions) error {
+	state, err := loadReviewState(repo)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return nil
+	}
+	if !state.GitRebase || !options.ResumedAfterRebase {
+		return errReviewInProgress
+	}
+	log.ForContext(ctx).Debug("forgetting the review stopped in git rebase")
+	return removeReviewState(repo)
+}
+
+// stopRebase records the review whose rebase failed with rebaseErr, when it stopped on conflicts, and returns rebaseErr.
+// The conflicts of in-process rebases are checked out for them to be resolved like with git rebase.
+// changes are the changes being rebased, state describes the review before the rebase
+func (r *Reviewer) stopRebase(ctx context.Context, repo lgit.Repository, state *reviewState, changes []*change, rebaseErr error) error {
+	var conflict *lgit.ConflictError
+	if !errors.As(rebaseErr, &conflict) {
+		inProgress, err := lgit.RebaseInProgress(repo)
+		if err != nil || !inProgress {
+			return rebaseErr
+		}
+		state.GitRebase = true
+		err = saveReviewState(repo, state)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to record the stopped review")
+			return rebaseErr
+		}
+		fmt.Fprintln(r.output(), "resolve the conflicts and stage them with git add, then run git review --continue. Run git review --skip to drop the commit, or git review --abort to restore the stack")
+		return rebaseErr
+	}
+	commits := []string{}
+	for _, change := range changes {
+		for _, commit := range change.commits {
+			commits = append(commits, commit.Hash.String())
+		}
+	}
+	err := r.checkoutConflict(ctx, repo, state, commits, conflict)
+	if err != nil {
+		return err
+	}
+	return rebaseErr
+}
+
+// checkoutConflict records the review stopped on conflict while applying commits, and checks out the conflicting files
+func (r *Reviewer) checkoutConflict(ctx context.Context, repo lgit.Repository, state *reviewState, commits []string, conflict *lgit.ConflictError) error {
+	for i, commit := range commits {
+		if commit != conflict.Commit.String() {
+			continue
+		}
+		state.Current = commit
+		state.Pending = commits[i+1:]
+		state.Conflicts = []string{}
+		for _, c := range conflict.Conflicts {
+			state.Conflicts = append(state.Conflicts, c.Path)
+		}
+		err := saveReviewState(repo, state)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to record the stopped review")
+			return err
+		}
+		err = lgit.CheckoutConflict(repo, conflict)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Error("failed to check out the conflicts")
+			return errors.Join(err, removeReviewState(repo))
+		}
+		for _, c := range conflict.Conflicts {
+			fmt.Fprintln(r.output(), fmt.Sprintf("conflict (%s) in %s", c.Reason, c.Path))
+		}
+		fmt.Fprintln(r.output(), fmt.Sprintf("could not apply %s, resolve the conflicts and stage them with git add, then run git review --continue. Run git review --skip to drop the commit, or git review --abort to restore the stack", commit[:7]))
+		return nil
+	}
+	return fmt.Errorf("unexpected conflict on %s, which is not part of the stack", conflict.Commit.String())
+}
+
+// Continue resumes the review stopped on a conflicting rebase, once the conflicts are resolved and staged.
+// The resolution is committed, the remaining commits are rebased and the stack is submitted with the options of
+// the stopped review, except for Confirm: pull requests needing a confirmation to be closed are left untouched.
+// When the stack is rebased with git rebase -i, git rebase --continue is run and resumes the review by itself
+func (r *Reviewer) Continue(ctx context.Context, repo lgit.Repository) (*ReviewResult, error) {
+	state, err := loadReviewState(repo)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, errNoStoppedReview
+	}
+	if state.GitRebase {
+		return r.resumeGitRebase(ctx, repo, state, rebaseContinue)
+	}
+	head, err := lgit.CommitResolution(repo, plumbing.NewHash(state.Current), state.Conflicts)
+	if err != nil {
+		return nil, err
+	}
+	return r.finishRebase(ctx, repo, state, head)
+}
+
+// Skip resumes the review stopped on a conflicting rebase without the conflicting commit, like git rebase --skip
+func (r *Reviewer) Skip(ctx context.Context, repo lgit.Repository) (*ReviewResult, error) {
+	state, err := loadReviewState(repo)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, errNoStoppedReview
+	}
+	if state.GitRebase {
+		return r.resumeGitRebase(ctx, repo, state, rebaseSkip)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	err = lgit.CheckoutBranch(repo, "", head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(r.output(), fmt.Sprintf("skipped %s", state.Current[:7]))
+	return r.finishRebase(ctx, repo, state, head.Hash())
+}
+
+// Abort stops the review stopped on a conflicting rebase, restoring the branch as it was before the rebase
+func (r *Reviewer) Abort(ctx context.Context, repo lgit.Repository) error {
+	state, err := loadReviewState(repo)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return errNoStoppedReview
+	}
+	if state.GitRebase {
+		_, err = r.resumeGitRebase(ctx, repo, state, rebaseAbort)
+		return err
+	}
+	err = lgit.CheckoutBranch(repo, plumbing.ReferenceName(state.Branch), plumbing.NewHash(state.OriginalHead))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(r.output(), fmt.Sprintf("restored the stack at %s", state.OriginalHead[:7]))
+	return removeReviewState(repo)
+}
+
+// finishRebase applies the pending commits of the stopped review on top of head, checks out the rebased stack
+// and submits it, or stops again on the next conflict
+func (r *Reviewer) finishRebase(ctx context.Context, repo lgit.Repository, state *reviewState, head plumbing.Hash) (*ReviewResult, error) {
+	pending := []plumbing.Hash{}
+	for _, commit := range state.Pending {
+		pending = append(pending, plumbing.NewHash(commit))
+	}
+	rebased, err := lgit.Rebase(repo, head, pending)
+	var conflict *lgit.ConflictError
+	if errors.As(err, &conflict) {
+		stopErr := r.checkoutConflict(ctx, repo, state, state.Pending, conflict)
+		if stopErr != nil {
+			return nil, stopErr
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = lgit.CheckoutBranch(repo, plumbing.ReferenceName(state.Branch), rebased)
+	if err != nil {
+		return nil, err
+	}
+	err = removeReviewState(repo)
+	if err != nil {
+		return nil, err
+	}
+	options := state.Options
+	fmt.Fprintln(r.output(), fmt.Sprintf("rebased the stack on %s/%s", options.Remote, options.Branch))
+
+	_, prAPI, err := r.newPullRequester(ctx, repo, &options)
+	if err != nil {
+		return nil, err
+	}
+	target := plumbing.NewHash(state.Target)
+	if rebased == target {
+		fmt.Fprintln(r.output(), "nothing to review")
+		return &ReviewResult{Rebased: true, Changes: []ChangeResult{}}, nil
+	}
+	var journal *reviewJournal
+	if state.Journal != "" {
+		journal, err = loadJournal(repo, state.Journal)
+		if err != nil {
+			log.ForContext(ctx).WithError(err).Warn("failed to load the journal of the review, its remote changes won't be recorded")
+		}
+	}
+	changes, err := r.sendPrs(ctx, repo, prAPI, options, journal, target, rebased)
+	if err != nil {
+		return nil, err
+	}
+	return &ReviewResult{Rebased: true, Changes: changes}, nil
+}
+
+// resumeGitRebase runs git rebase with action to resume the stopped review rebased with git rebase -i.
+// The state is dropped for the review resumed by the rebase to run, and recorded again when the rebase stops again
+func (r *Reviewer) resumeGitRebase(ctx context.Context, repo lgit.Repository, state *reviewState, action string) (*ReviewResult, error) {
+	err := removeReviewState(repo)
+	if err != nil {
+		return nil, err
+	}
+	err = lgit.CLIExecutor{}.ResumeRebase(ctx, repo, action)
+	if err != nil {
+		inProgress, stateErr := lgit.RebaseInProgress(repo)
+		if stateErr == nil && inProgress {
+			stateErr = saveReviewState(repo, state)
+		}
+		if stateErr != nil {
+			log.ForContext(ctx).WithError(stateErr).Error("failed to record the stopped review")
+		}
+		return nil, err
+	}
+	return &ReviewResult{Rebased: action != rebaseAbort, Resumed: action != rebaseAbort, Changes: []ChangeResult{}}, nil
+}
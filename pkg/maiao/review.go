@@ -7,9 +7,7 @@ import (
 	"strings"
 
 	"github.com/adevinta/maiao/pkg/api"
-	"github.com/adevinta/maiao/pkg/credentials"
 	lgit "github.com/adevinta/maiao/pkg/git"
-	gh "github.com/adevinta/maiao/pkg/github"
 	"github.com/adevinta/maiao/pkg/log"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
@@ -31,6 +29,29 @@ type ReviewOptions struct {
 	Topic          string
 	WorkInProgress bool
 	Ready          bool
+	// Backend forces the pull request backend to use instead of detecting it from the remote
+	Backend string
+	// PushRemote is the remote branches are pushed to, when different from the reviewed remote.
+	// It is typically a fork of the reviewed repository, in which case pull requests are opened
+	// from <fork-owner>:<branch> heads
+	PushRemote string
+	// CloseRemoved closes, without asking for confirmation, the pull requests of changes
+	// submitted previously and removed from the stack since
+	CloseRemoved bool
+	// Confirm asks the user to confirm question, like closing the pull requests of removed changes.
+	// When nil, nothing requiring a confirmation is done
+	Confirm func(question string) bool `json:"-"`
+	// AutoMerge enables auto-merge with this merge method on the pull requests targeting the review branch,
+	// for the forges supporting it. It is left empty to leave auto-merge untouched
+	AutoMerge string
+	// AutoMergeStacked enables auto-merge on the pull requests stacked on other changes as well
+	AutoMergeStacked bool
+	// DryRun only prints the actions that would be taken. Nothing is changed on the remote nor in the local references,
+	// the remote is not even fetched and its tracking branches are used as is
+	DryRun bool
+	// ResumedAfterRebase tells the review runs as the exec step ending the git rebase -i started by a previous review,
+	// whose stopped state it replaces. Other reviews fail while a review is stopped on conflicts
+	ResumedAfterRebase bool `json:"-"`
 }
 
 type change struct {
@@ -44,12 +65,26 @@ type change struct {
 	parent   *change
 }
 
-func Review(ctx context.Context, repo lgit.Repository, options ReviewOptions) error {
+// Review submits the changes between the target branch and HEAD for review, rebasing them first when needed.
+// When the rebase resumes the review by itself, like lgit.CLIExecutor does, the returned result only reports the rebase.
+// When the rebase stops on conflicts, they are left to resolve in the working tree and the review is continued with
+// Continue, Skip or Abort. No other review can start in the meantime
+func (r *Reviewer) Review(ctx context.Context, repo lgit.Repository, options ReviewOptions) (*ReviewResult, error) {
 	defaultRemoteOption(ctx, repo, &options)
+	err := checkStoppedReview(ctx, repo, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.AutoMerge != "" {
+		err := validateMergeMethod(options.AutoMerge)
+		if err != nil {
+			return nil, err
+		}
+	}
 	head, err := repo.Head()
 	if err != nil {
 		log.ForContext(ctx).WithError(err).Error("failed to retrieve git HEAD")
-		return err
+		return nil, err
 	}
 
 	ctx = log.WithContextFields(ctx, logrus.Fields{
@@ -61,41 +96,23 @@ func Review(ctx context.Context, repo lgit.Repository, options ReviewOptions) er
 		"headSHA":    head.Hash().String(),
 	})
 
-	log.ForContext(ctx).Debugf("finding remote")
-	remote, err := repo.Remote(options.Remote)
-	if err != nil {
-		log.ForContext(ctx).WithError(err).Error("failed to find remote")
-		return err
-	}
-
-	prAPI, err := api.NewPullRequester(ctx, remote)
+	remote, prAPI, err := r.newPullRequester(ctx, repo, &options)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defaultBranchOption(ctx, repo, prAPI, &options)
 
 	remoteRef := plumbing.Revision(fmt.Sprintf("%s/%s", options.Remote, options.Branch))
 	ctx = log.WithContextFields(ctx, logrus.Fields{
 		"remoteRef": remoteRef,
 	})
 
-	if len(remote.Config().URLs) != 1 {
-		return errors.New("multiple URLs not supported")
-	}
-
-	endpoint, err := transport.NewEndpoint(remote.Config().URLs[0])
-	if err != nil {
-		return err
-	}
-
-	log.ForContext(ctx).Debugf("fetching remote")
-	err = remote.Fetch(&git.FetchOptions{
-		RemoteName: options.Remote,
-		Auth:       &credentials.GitAuth{Credentials: gh.DefaultCredentialGetter, Endpoint: endpoint},
-	})
-	if err != nil && err != git.NoErrAlreadyUpToDate {
-		log.ForContext(ctx).WithError(err).Error("failed to update git repository")
-		return err
+	if options.DryRun {
+		fmt.Fprintln(r.output(), fmt.Sprintf("dry run: using %s as last fetched, nothing will be changed", remoteRef))
+	} else {
+		err = r.fetchRemote(ctx, remote)
+		if err != nil {
+			return nil, err
+		}
 	}
 	headRef := plumbing.Revision(plumbing.HEAD)
 	ctx = log.WithContextFields(ctx, logrus.Fields{
@@ -103,14 +120,28 @@ func Review(ctx context.Context, repo lgit.Repository, options ReviewOptions) er
 		"headRef":   headRef,
 	})
 	log.ForContext(ctx).Debugf("finding first common ancestor")
-	b, err := lgit.MergeBase(ctx, repo, remoteRef, headRef)
+	b, err := r.git().MergeBase(ctx, repo, remoteRef, headRef)
 	if err != nil {
 		log.ForContext(ctx).WithError(err).Errorf("unable to find common ancestor")
-		return err
+		return nil, err
 	}
 	remoteCommit, err := repo.ResolveRevision(plumbing.Revision(remoteRef))
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// the journal of the review records the local and remote state before they are changed, for Undo to restore it
+	var journal *reviewJournal
+	if !options.DryRun {
+		journal = newJournal(repo, head, options)
+	}
+
+	// changes can't be submitted without a Change-Id, they are added even when the rebase is skipped
+	if !options.DryRun {
+		head, err = r.addChangeIDs(ctx, repo, journal, b, head)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	needRebase := remoteCommit.String() != b.String()
@@ -119,39 +150,166 @@ func Review(ctx context.Context, repo lgit.Repository, options ReviewOptions) er
 		// we also need to rebase if some changeIDs are missing
 		changes, err := extractChanges(ctx, repo, b, head.Hash())
 		if err != nil {
-			return err
+			return nil, err
 		}
 		needRebase = changesNeedRebase(ctx, changes)
 	}
 
+	if options.DryRun {
+		rebase := !options.SkipRebase && needRebase
+		if !rebase && b == head.Hash() {
+			fmt.Fprintln(r.output(), "nothing to review")
+			return &ReviewResult{Changes: []ChangeResult{}}, nil
+		}
+		err = r.planReview(ctx, repo, prAPI, options, b, *remoteCommit, head.Hash(), rebase)
+		if err != nil {
+			return nil, err
+		}
+		return &ReviewResult{Changes: []ChangeResult{}}, nil
+	}
+
+	rebased := false
 	if !options.SkipRebase && needRebase {
 		ctx := log.WithContextFields(ctx, logrus.Fields{
 			"remoteSha": remoteCommit.String(),
 			"baseSha":   b.String(),
 		})
 		log.ForContext(ctx).Debug("local branch is not up to date, needs rebasing")
-		err := rebaseCommits(ctx, repo, options, b, *remoteCommit, head.Hash())
+		var resumed bool
+		rebased, resumed, err = r.rebaseCommits(ctx, repo, options, journal, b, *remoteCommit, head.Hash())
 		if err != nil {
-			return err
+			return nil, err
 		}
-		return nil
+		if !rebased || resumed {
+			return &ReviewResult{Rebased: rebased, Resumed: resumed, Changes: []ChangeResult{}}, nil
+		}
+		head, err = repo.Head()
+		if err != nil {
+			return nil, err
+		}
+		b = *remoteCommit
 	} else {
 		log.ForContext(ctx).WithField("mergeSha", remoteCommit.String()).WithField("baseSha", b.String()).Debug("no rebase needed")
 	}
 
 	if b == head.Hash() {
-		fmt.Println("nothing to review")
-		return nil
+		fmt.Fprintln(r.output(), "nothing to review")
+		return &ReviewResult{Changes: []ChangeResult{}}, nil
+	}
+
+	changes, err := r.sendPrs(ctx, repo, prAPI, options, journal, b, head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReviewResult{Rebased: rebased, Changes: changes}, nil
+}
+
+// newPullRequester finds the reviewed remote and instanciates its pull request backend.
+// The push remote and the target branch are defaulted from the git configuration and the backend
+func (r *Reviewer) newPullRequester(ctx context.Context, repo lgit.Repository, options *ReviewOptions) (*git.Remote, api.PullRequester, error) {
+	log.ForContext(ctx).Debugf("finding remote")
+	remote, err := repo.Remote(options.Remote)
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to find remote")
+		return nil, nil, err
 	}
 
-	err = sendPrs(ctx, repo, options, b, head.Hash())
+	cfg, err := repo.Config()
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Debug("failed to load git config")
+	}
+	defaultPushRemoteOption(ctx, cfg, options)
+	factory := r.NewPullRequester
+	if factory == nil {
+		factory = api.NewPullRequester
+	}
+	prAPI, err := factory(ctx, remote, api.BackendOptions{
+		Config:      cfg,
+		Backend:     options.Backend,
+		GitDir:      repositoryGitDir(repo),
+		Credentials: r.Credentials,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	err = checkBackendOptions(prAPI, *options)
+	if err != nil {
+		return nil, nil, err
+	}
+	defaultBranchOption(ctx, repo, prAPI, options)
+	return remote, prAPI, nil
+}
+
+// checkBackendOptions fails when options require features prAPI does not provide, instead of silently ignoring them
+func checkBackendOptions(prAPI api.PullRequester, options ReviewOptions) error {
+	if options.PushRemote != "" && options.PushRemote != options.Remote {
+		forkSupporter, ok := prAPI.(api.ForkSupporter)
+		if !ok || !forkSupporter.SupportsForks() {
+			return fmt.Errorf("pushing to %s is not supported by the pull request backend of %s, which can't open pull requests from forks", options.PushRemote, options.Remote)
+		}
+	}
+	if options.AutoMerge != "" {
+		autoMerger, ok := prAPI.(api.AutoMerger)
+		if !ok || !autoMerger.SupportsAutoMerge() {
+			return fmt.Errorf("auto-merge is not supported by the pull request backend of %s", options.Remote)
+		}
+	}
+	return nil
+}
+
+// fetchRemote updates the remote tracking branches of remote
+func (r *Reviewer) fetchRemote(ctx context.Context, remote *git.Remote) error {
+	if len(remote.Config().URLs) != 1 {
+		return errors.New("multiple URLs not supported")
+	}
+
+	endpoint, err := transport.NewEndpoint(remote.Config().URLs[0])
 	if err != nil {
 		return err
 	}
 
+	log.ForContext(ctx).Debugf("fetching remote")
+	err = remote.Fetch(&git.FetchOptions{
+		RemoteName: remote.Config().Name,
+		Auth:       r.gitAuth(endpoint),
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		log.ForContext(ctx).WithError(err).Error("failed to update git repository")
+		return err
+	}
 	return nil
 }
 
+// pushBranches pushes refspecs, setting branches to commits or deleting them, to pushRemote. With backends keeping
+// the branches of the changes local, nothing is written to the remote and only its remote tracking branches are updated
+func (r *Reviewer) pushBranches(ctx context.Context, repo lgit.Repository, prAPI api.PullRequester, pushRemote string, endpoint *transport.Endpoint, refspecs []config.RefSpec, force bool) error {
+	if keeper, ok := prAPI.(api.BranchKeeper); ok && keeper.KeepsBranchesLocal() {
+		log.ForContext(ctx).WithField("pushRemote", pushRemote).Debugf("the backend keeps branches local, only updating remote tracking branches")
+		for _, refspec := range refspecs {
+			name := plumbing.NewRemoteReferenceName(pushRemote, refspec.Dst("").Short())
+			if refspec.IsDelete() {
+				err := lgit.RemoveReference(repo, name)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err := lgit.SetReference(repo, name, plumbing.NewHash(refspec.Src()))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return repo.Push(&git.PushOptions{
+		RemoteName: pushRemote,
+		RefSpecs:   refspecs,
+		Auth:       r.gitAuth(endpoint),
+		Force:      force,
+	})
+}
+
 func changesNeedRebase(ctx context.Context, changes []*change) bool {
 	var parent *object.Commit
 	for _, change := range changes {
@@ -192,102 +350,327 @@ func changesNeedRebase(ctx context.Context, changes []*change) bool {
 	return false
 }
 
-func rebaseCommits(ctx context.Context, repo lgit.Repository, options ReviewOptions, base, remoteHead, head plumbing.Hash) error {
+// addChangeIDs rewrites the commits between base and head to add a Change-Id to the changes missing one, and moves
+// HEAD to the rewritten commits, once recorded in journal. The returned reference is the updated HEAD
+func (r *Reviewer) addChangeIDs(ctx context.Context, repo lgit.Repository, journal *reviewJournal, base plumbing.Hash, head *plumbing.Reference) (*plumbing.Reference, error) {
+	changes, err := extractChanges(ctx, repo, base, head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	missing := map[plumbing.Hash]struct{}{}
+	for _, change := range changes {
+		if change.changeID == "" {
+			missing[change.commits[0].Hash] = struct{}{}
+		}
+	}
+	if len(missing) == 0 {
+		return head, nil
+	}
+	rewritten, err := lgit.RewriteCommits(repo, base, head.Hash(), func(commit *object.Commit) string {
+		if _, ok := missing[commit.Hash]; !ok {
+			return commit.Message
+		}
+		changeID := lgit.NewChangeID(commit.Committer, commit.ParentHashes[0], commit.Message)
+		fmt.Fprintln(r.output(), fmt.Sprintf("added Change-Id %s to %s", changeID, lgit.Parse(commit.Message).GetTitle()))
+		return lgit.AddChangeID(commit.Message, changeID)
+	})
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to add Change-Ids")
+		return nil, err
+	}
+	err = journal.save(repo)
+	if err != nil {
+		return nil, err
+	}
+	err = lgit.UpdateHead(repo, head.Hash(), rewritten)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Head()
+}
+
+// rebaseCommits rebases the changes between base and head onto remoteHead, dropping the ones already merged in remoteHead.
+// mergedChangeIDs are dropped as well, for changes merged without their Change-Id, like squashed pull requests.
+// It returns whether the changes were rebased, they are not when all of them are merged already, and whether the
+// rebase resumes the review by itself. The local HEAD is recorded in journal, when not nil, before being rebased
+func (r *Reviewer) rebaseCommits(ctx context.Context, repo lgit.Repository, options ReviewOptions, journal *reviewJournal, base, remoteHead, head plumbing.Hash, mergedChangeIDs ...string) (bool, bool, error) {
 
 	changes, err := extractChanges(ctx, repo, base, head)
 	if err != nil {
-		return err
+		return false, false, err
 	}
 	knownChangeIDs, err := extractChangeIDs(ctx, repo, base, remoteHead)
 	if err != nil {
-		return err
+		return false, false, err
+	}
+	for _, changeID := range mergedChangeIDs {
+		knownChangeIDs[changeID] = struct{}{}
 	}
 	changes = removeMergedChangeIDs(changes, knownChangeIDs)
 
 	if len(changes) == 0 {
-		fmt.Println("nothing to review")
-		return nil
+		fmt.Fprintln(r.output(), "nothing to review")
+		return false, false, nil
 	}
 
-	err = lgit.RebaseCommits(ctx, repo, base, remoteHead, rebaseTODO(changes))
+	headRef, err := repo.Head()
 	if err != nil {
-		return nil
+		return false, false, err
 	}
-	return nil
+	err = journal.save(repo)
+	if err != nil {
+		return false, false, err
+	}
+	resumed, err := r.git().RebaseCommits(ctx, repo, base, remoteHead, rebaseTODO(changes))
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to rebase changes")
+		state := &reviewState{OriginalHead: head.String(), Target: remoteHead.String(), Options: options}
+		if journal != nil {
+			state.Journal = journal.ID
+		}
+		if headRef.Name().IsBranch() {
+			state.Branch = headRef.Name().String()
+		}
+		return false, false, r.stopRebase(ctx, repo, state, changes, err)
+	}
+	if !resumed {
+		fmt.Fprintln(r.output(), fmt.Sprintf("rebased %d changes on %s/%s", len(changes), options.Remote, options.Branch))
+	}
+	return true, resumed, nil
 }
 
-func sendPrs(ctx context.Context, repo lgit.Repository, options ReviewOptions, base, head plumbing.Hash) error {
+// sendPrs pushes the changes between base and head and creates or updates their pull requests.
+// The remote branches and pull requests are recorded in journal, when not nil, before being changed
+func (r *Reviewer) sendPrs(ctx context.Context, repo lgit.Repository, prAPI api.PullRequester, options ReviewOptions, journal *reviewJournal, base, head plumbing.Hash) ([]ChangeResult, error) {
 
 	remote, err := repo.Remote(options.Remote)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	changes, err := extractChanges(ctx, repo, base, head)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	refspecs := []config.RefSpec{}
 	for _, change := range changes {
 		if len(change.commits) == 0 {
-			return errors.New("empty change")
+			return nil, errors.New("empty change")
 		}
 		refspecs = append(refspecs, config.RefSpec(change.head.Hash.String()+":refs/heads/"+change.branch))
 	}
 
 	if len(remote.Config().URLs) != 1 {
-		return errors.New("multiple URLs not supported")
+		return nil, errors.New("multiple URLs not supported")
 	}
 
 	endpoint, err := transport.NewEndpoint(remote.Config().URLs[0])
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.ForContext(ctx).WithField("refspec", refspecs).Debugf("pushing PR changes")
-	err = repo.Push(&git.PushOptions{
-		RemoteName: options.Remote,
-		RefSpecs:   refspecs,
-		Auth:       &credentials.GitAuth{Credentials: gh.DefaultCredentialGetter, Endpoint: endpoint},
-		Force:      true,
-	})
-	if err != nil && err != git.NoErrAlreadyUpToDate {
-		return err
+	if pusher, ok := prAPI.(api.ChangePusher); ok {
+		return r.pushChanges(ctx, repo, pusher, options, endpoint, changes)
 	}
 
-	prAPI, err := api.NewPullRequester(ctx, remote)
+	pushRemote := options.Remote
+	forkOwner := ""
+	if options.PushRemote != "" && options.PushRemote != options.Remote {
+		pushRemote = options.PushRemote
+		forkOwner, endpoint, err = forkRemote(repo, pushRemote)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// stack markers and remote branches must be read before updating them
+	statuses, err := findPullRequests(ctx, prAPI, changes, forkOwner)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	removed := previousStack(changes, statuses)
+	pushed := map[string]plumbing.Hash{}
+	for _, change := range changes {
+		hash, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewRemoteReferenceName(pushRemote, change.branch)))
+		if err == nil {
+			pushed[change.branch] = *hash
+		}
+	}
+	if journal != nil {
+		for _, change := range changes {
+			journal.recordBranch(change.branch, pushed[change.branch])
+			if status := statuses[change.branch]; status != nil && status.State != api.PullRequestStateMerged && status.State != api.PullRequestStateClosed {
+				journal.recordPullRequest(status.PullRequest, prHead(change.branch, forkOwner), status)
+			}
+		}
+		err = journal.save(repo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.ForContext(ctx).WithField("refspec", refspecs).WithField("pushRemote", pushRemote).Debugf("pushing PR changes")
+	err = r.pushBranches(ctx, repo, prAPI, pushRemote, endpoint, refspecs, true)
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, err
 	}
 
 	var parent *change
 	for i, change := range changes {
 		change.parent = parent
-		opts := prOptions(repo, prAPI, options, change, changes[:i], changes[i+1:])
+		opts := forkPROptions(prOptions(repo, prAPI, options, change, changes[:i], changes[i+1:]), options, forkOwner)
 		pr, created, err := prAPI.Ensure(ctx, opts)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if created {
-			fmt.Println(fmt.Sprintf("created PR %s", pr.URL))
+			fmt.Fprintln(r.output(), fmt.Sprintf("created PR %s", pr.URL))
+			journal.recordPullRequest(*pr, opts.Head, nil)
 		}
 		change.pr = pr
 		change.created = created
 		parent = change
 	}
+	err = journal.save(repo)
+	if err != nil {
+		return nil, err
+	}
+	results := []ChangeResult{}
 	for i, change := range changes {
-		opts := prOptions(repo, prAPI, options, change, changes[:i], changes[i+1:])
+		opts := forkPROptions(prOptions(repo, prAPI, options, change, changes[:i], changes[i+1:]), options, forkOwner)
 		_, err := prAPI.Update(ctx, change.pr, opts)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if !change.created {
-			fmt.Println(fmt.Sprintf("updated PR %s", change.pr.URL))
+			fmt.Fprintln(r.output(), fmt.Sprintf("updated PR %s", change.pr.URL))
 		}
 		log.ForContext(ctx).WithFields(logrus.Fields{"prOptions": opts, "change": change}).Trace("PR has been updated with parent ")
+
+		status := statuses[change.branch]
+		switch {
+		case change.created:
+			results = append(results, newChangeResult(change, opts.Base, ChangeCreated, options.WorkInProgress && !options.Ready))
+		case pushed[change.branch] == change.head.Hash && status != nil && status.Base == opts.Base:
+			results = append(results, newChangeResult(change, opts.Base, ChangeUnchanged, existingDraft(status, options)))
+		default:
+			results = append(results, newChangeResult(change, opts.Base, ChangeUpdated, existingDraft(status, options)))
+		}
 	}
-	return nil
+	// removed changes are closed once the remaining pull requests no longer target their branches
+	err = r.closeRemovedChanges(ctx, repo, prAPI, options, removed, forkOwner)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// forkRemote returns the owner and the endpoint of the fork a push remote points to
+func forkRemote(repo lgit.Repository, name string) (string, *transport.Endpoint, error) {
+	remote, err := repo.Remote(name)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(remote.Config().URLs) != 1 {
+		return "", nil, errors.New("multiple URLs not supported")
+	}
+	endpoint, err := transport.NewEndpoint(remote.Config().URLs[0])
+	if err != nil {
+		return "", nil, err
+	}
+	fork, err := api.NewRepoFromGitRemote(remote.Config().URLs[0])
+	if err != nil {
+		return "", nil, err
+	}
+	segments := strings.Split(fork.Repository, "/")
+	owner := ""
+	if len(segments) >= 2 {
+		owner = segments[len(segments)-2]
+	}
+	if owner == "" {
+		return "", nil, fmt.Errorf("unable to find the owner of the %s push remote", name)
+	}
+	return owner, endpoint, nil
+}
+
+// forkPROptions adapts pull request options for branches living in the fork of forkOwner.
+// The head is prefixed with the fork owner and, as parent branches only exist in the fork,
+// all pull requests target the review branch. Parents are still referenced in titles and descriptions
+func forkPROptions(opts api.PullRequestOptions, options ReviewOptions, forkOwner string) api.PullRequestOptions {
+	if forkOwner == "" {
+		return opts
+	}
+	opts.Head = forkOwner + ":" + opts.Head
+	opts.Base = options.Branch
+	return opts
+}
+
+// pushChanges submits the whole stack for review in a single push to the magic ref of review systems
+// like Gerrit, where each pushed commit becomes a change of its own
+func (r *Reviewer) pushChanges(ctx context.Context, repo lgit.Repository, pusher api.ChangePusher, options ReviewOptions, endpoint *transport.Endpoint, changes []*change) ([]ChangeResult, error) {
+	existing := map[string]bool{}
+	for _, change := range changes {
+		if len(change.commits) > 1 {
+			return nil, fmt.Errorf("fixup commits are not supported when pushing changes for review, squash the fixups of %s first", change.changeID)
+		}
+		pr, err := pusher.FindChange(ctx, change.changeID, options.Branch)
+		if err != nil {
+			return nil, err
+		}
+		existing[change.changeID] = pr != nil
+	}
+
+	refspec := config.RefSpec(changes[len(changes)-1].head.Hash.String() + ":" + pusher.PushRef(api.PushOptions{
+		Branch: options.Branch,
+		Topic:  options.Topic,
+		WIP:    options.WorkInProgress,
+		Ready:  options.Ready,
+	}))
+	log.ForContext(ctx).WithField("refspec", refspec).Debugf("pushing changes for review")
+	err := repo.Push(&git.PushOptions{
+		RemoteName: options.Remote,
+		RefSpecs:   []config.RefSpec{refspec},
+		Auth:       r.gitAuth(endpoint),
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, err
+	}
+
+	results := []ChangeResult{}
+	for _, change := range changes {
+		pr, err := pusher.FindChange(ctx, change.changeID, options.Branch)
+		if err != nil {
+			return nil, err
+		}
+		if pr == nil {
+			return nil, fmt.Errorf("change %s was not found after being pushed", change.changeID)
+		}
+		change.pr = pr
+		change.created = !existing[change.changeID]
+		action := ChangeUpdated
+		if change.created {
+			action = ChangeCreated
+			fmt.Fprintln(r.output(), fmt.Sprintf("created change %s", pr.URL))
+		} else {
+			fmt.Fprintln(r.output(), fmt.Sprintf("updated change %s", pr.URL))
+		}
+		results = append(results, newChangeResult(change, options.Branch, action, options.WorkInProgress))
+	}
+	return results, nil
+}
+
+// repositoryGitDir returns the git directory of the repository, or an empty string when it can't be found
+func repositoryGitDir(repo lgit.Repository) string {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return ""
+	}
+	gitDir, err := lgit.FindGitDir(wt.Filesystem.Root())
+	if err != nil {
+		return ""
+	}
+	return gitDir
 }
 
 func defaultBranchOption(ctx context.Context, repo lgit.Repository, prAPI api.PullRequester, options *ReviewOptions) {
@@ -313,6 +696,15 @@ func defaultBranchOption(ctx context.Context, repo lgit.Repository, prAPI api.Pu
 	}
 }
 
+func defaultPushRemoteOption(ctx context.Context, cfg *config.Config, options *ReviewOptions) {
+	if options.PushRemote == "" {
+		options.PushRemote = lgit.ConfigOption(cfg, "maiao", "", "pushRemote")
+		if options.PushRemote != "" {
+			log.ForContext(ctx).WithField("pushRemote", options.PushRemote).Debugf("using push remote from git configuration")
+		}
+	}
+}
+
 func defaultRemoteOption(ctx context.Context, repo lgit.Repository, options *ReviewOptions) {
 	if options.Remote == "" {
 		log.ForContext(ctx).Debugf("finding relevant remote")
@@ -336,12 +728,9 @@ func rebaseTODO(changes []*change) string {
 	lines := []string{}
 
 	for _, change := range changes {
-		for i, commit := range change.commits {
-			action := "pick"
-			if i == 0 && change.changeID == "" {
-				action = "reword"
-			}
-			lines = append(lines, fmt.Sprint(action, " ", commit.Hash.String(), " ", strings.Split(commit.Message, "\n")[0]))
+		for _, commit := range change.commits {
+			// Change-Ids are added in process before rebasing, commits are never reworded by git
+			lines = append(lines, fmt.Sprint("pick ", commit.Hash.String(), " ", strings.Split(commit.Message, "\n")[0]))
 		}
 	}
 	return strings.Join(lines, "\n")
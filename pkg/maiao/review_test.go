@@ -9,6 +9,8 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -72,6 +74,10 @@ func (r *testRepository) Config() (*config.Config, error) {
 	return r.config()
 }
 
+func (r *testRepository) SetConfig(cfg *config.Config) error {
+	return errors.New("not implemented")
+}
+
 func (r *testRepository) Fetch(o *git.FetchOptions) error {
 	if r.fetch == nil {
 		return errors.New("not implemented")
@@ -105,10 +111,12 @@ type testAPI struct {
 	EnsureFunc              func(context.Context, api.PullRequestOptions) (*api.PullRequest, bool, error)
 	LinkedTopicIssuesFunc   func(topic string) string
 	DefaultBranchFunc       func(context.Context) string
+	FindFunc                func(context.Context, string) (*api.PullRequestStatus, error)
 	UpdateCalled            int
 	EnsureCalled            int
 	LinkedTopicIssuesCalled int
 	DefaultBranchCalled     int
+	FindCalled              int
 }
 
 // Update defines the interface to create or update a pull request to match options
@@ -142,6 +150,30 @@ func (a *testAPI) DefaultBranch(ctx context.Context) string {
 	}
 	return "DefaultBranch not implemented"
 }
+func (a *testAPI) Find(ctx context.Context, head string) (*api.PullRequestStatus, error) {
+	a.FindCalled++
+	if a.FindFunc != nil {
+		return a.FindFunc(ctx, head)
+	}
+	return nil, errors.New("Find not implemented")
+}
+
+// autoMergeAPI is a backend supporting auto-merge
+type autoMergeAPI struct {
+	testAPI
+}
+
+func (*autoMergeAPI) SupportsAutoMerge() bool {
+	return true
+}
+
+func TestCheckBackendOptions(t *testing.T) {
+	assert.NoError(t, checkBackendOptions(&testAPI{}, ReviewOptions{Remote: "origin"}))
+	assert.EqualError(t, checkBackendOptions(&testAPI{}, ReviewOptions{Remote: "origin", AutoMerge: api.MergeMethodSquash}), "auto-merge is not supported by the pull request backend of origin")
+	assert.NoError(t, checkBackendOptions(&autoMergeAPI{}, ReviewOptions{Remote: "origin", AutoMerge: api.MergeMethodSquash}))
+	assert.NoError(t, checkBackendOptions(&testAPI{}, ReviewOptions{Remote: "origin", PushRemote: "origin"}))
+	assert.EqualError(t, checkBackendOptions(&testAPI{}, ReviewOptions{Remote: "origin", PushRemote: "fork"}), "pushing to fork is not supported by the pull request backend of origin, which can't open pull requests from forks")
+}
 
 func TestDefaultOptionsUsesGitDefaults(t *testing.T) {
 	opts := ReviewOptions{}
@@ -259,7 +291,7 @@ func TestRebaseTODO(t *testing.T) {
 	assert.Equal(
 		t,
 		strings.Join([]string{
-			"reword b34ccd81a342e155b8382992cddb116c56bee95c other-change",
+			"pick b34ccd81a342e155b8382992cddb116c56bee95c other-change",
 			"pick c30a2f070b4f3d00c26679186345ea506e664056 fixup! other-change",
 			"pick 943c8d8469c2800e361cea0f37a3e38cc7e90fd6 add hello world",
 		}, "\n"),
@@ -337,6 +369,42 @@ func gitCommand(t *testing.T, path string, args ...string) string {
 	return strings.TrimSpace(string(out))
 }
 
+// newTestStack clones a bare remote whose main branch holds an empty initial commit, and commits one empty change
+// per message of changes on top of it. HOME points to an empty directory for no user configuration to leak in
+func newTestStack(t *testing.T, changes ...string) (work, remote string, repo *git.Repository) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	d := t.TempDir()
+	remote = filepath.Join(d, "remote.git")
+	work = filepath.Join(d, "work")
+	gitCommand(t, d, "init", "--bare", "-b", "main", remote)
+	gitCommand(t, d, "clone", remote, work)
+	gitCommand(t, work, "config", "user.email", "john.doe@example.com")
+	gitCommand(t, work, "config", "user.name", "John Doe")
+	// the local backend stands in for a forge, the branches of the changes are pushed to the test remote
+	gitCommand(t, work, "config", "maiao.localPush", "true")
+	gitCommand(t, work, "checkout", "-b", "main")
+	gitCommand(t, work, "commit", "--allow-empty", "-m", "Initial commit")
+	gitCommand(t, work, "push", "origin", "main")
+	for _, message := range changes {
+		gitCommand(t, work, "commit", "--allow-empty", "-m", message)
+	}
+	repo, err := git.PlainOpen(work)
+	require.NoError(t, err)
+	return work, remote, repo
+}
+
+// cloneTestRemote clones remote next to it as someone else, to change it behind the back of the work repository
+func cloneTestRemote(t *testing.T, remote, name string) string {
+	t.Helper()
+	clone := filepath.Join(filepath.Dir(remote), name)
+	gitCommand(t, filepath.Dir(remote), "clone", remote, clone)
+	gitCommand(t, clone, "config", "user.email", "jane.doe@example.com")
+	gitCommand(t, clone, "config", "user.name", "Jane Doe")
+	gitCommand(t, clone, "config", "maiao.localPush", "true")
+	return clone
+}
+
 func TestExtractChanges(t *testing.T) {
 	d, err := os.MkdirTemp("", t.Name())
 	require.NoError(t, err)
@@ -543,3 +611,245 @@ func (n *nopWriteCloser) Close() error {
 func init() {
 	log.Logger.SetLevel(logrus.TraceLevel)
 }
+
+type testChangePusher struct {
+	changes map[string]*api.PullRequest
+	pushRef func(api.PushOptions) string
+}
+
+func (p *testChangePusher) PushRef(options api.PushOptions) string {
+	return p.pushRef(options)
+}
+
+func (p *testChangePusher) FindChange(ctx context.Context, changeID, branch string) (*api.PullRequest, error) {
+	return p.changes[changeID+"@"+branch], nil
+}
+
+func TestPushChanges(t *testing.T) {
+	storage := memory.NewStorage()
+	rootParent := "bdc945b1bc57b3938f7223c7adb8bc2db58b838f"
+	newTestCommit(t, storage, rootParent)
+	first := newTestCommit(t, storage, "fc73d3a47b5864a8668eb826d506deb2bb54c1b5", rootParent)
+	second := newTestCommit(t, storage, "2d885b9b60dd70bb5c9b66ac72d21da894787fd7", "fc73d3a47b5864a8668eb826d506deb2bb54c1b5")
+
+	pusher := &testChangePusher{
+		changes: map[string]*api.PullRequest{
+			"I1@main": {ID: "1", URL: "https://gerrit.example.com/c/project/+/1"},
+		},
+		pushRef: func(options api.PushOptions) string {
+			assert.Equal(t, api.PushOptions{Branch: "main", Topic: "my-topic", WIP: true}, options)
+			return "refs/for/main%topic=my-topic,wip"
+		},
+	}
+	pushed := []config.RefSpec{}
+	repo := &testRepository{
+		config: func() (*config.Config, error) { return config.NewConfig(), nil },
+		push: func(o *git.PushOptions) error {
+			assert.Equal(t, "origin", o.RemoteName)
+			pushed = append(pushed, o.RefSpecs...)
+			pusher.changes["I2@main"] = &api.PullRequest{ID: "2", URL: "https://gerrit.example.com/c/project/+/2"}
+			return nil
+		},
+	}
+	changes := []*change{
+		{changeID: "I1", head: first, commits: []*object.Commit{first}},
+		{changeID: "I2", head: second, commits: []*object.Commit{second}},
+	}
+
+	results, err := (&Reviewer{}).pushChanges(context.Background(), repo, pusher, ReviewOptions{Remote: "origin", Branch: "main", Topic: "my-topic", WorkInProgress: true}, nil, changes)
+	require.NoError(t, err)
+	assert.Equal(t, []config.RefSpec{"2d885b9b60dd70bb5c9b66ac72d21da894787fd7:refs/for/main%topic=my-topic,wip"}, pushed)
+	assert.False(t, changes[0].created)
+	assert.Equal(t, "1", changes[0].pr.ID)
+	assert.True(t, changes[1].created)
+	assert.Equal(t, "2", changes[1].pr.ID)
+	require.Len(t, results, 2)
+	assert.Equal(t, ChangeUpdated, results[0].Action)
+	assert.Equal(t, ChangeCreated, results[1].Action)
+	assert.Equal(t, "main", results[1].Base)
+	assert.Equal(t, []string{second.Hash.String()}, results[1].Commits)
+
+	t.Run("fixup commits are rejected", func(t *testing.T) {
+		pushed = []config.RefSpec{}
+		_, err := (&Reviewer{}).pushChanges(context.Background(), repo, pusher, ReviewOptions{Remote: "origin", Branch: "main"}, nil, []*change{
+			{changeID: "I1", head: second, commits: []*object.Commit{first, second}},
+		})
+		assert.Error(t, err)
+		assert.Empty(t, pushed)
+	})
+}
+
+func TestReviewWithLocalBackend(t *testing.T) {
+	work, remote, repo := newTestStack(t,
+		"First change\n\nChange-Id: I1111111111111111111111111111111111111111",
+		"Second change\n\nChange-Id: I2222222222222222222222222222222222222222",
+	)
+	gitCommand(t, work, "config", "--unset", "maiao.localPush")
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local", WorkInProgress: true}
+	result, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+	assert.False(t, result.Rebased)
+	require.Len(t, result.Changes, 2)
+	assert.Equal(t, ChangeResult{
+		ChangeID: "I1111111111111111111111111111111111111111",
+		Commits:  []string{gitCommand(t, work, "rev-parse", "HEAD~1")},
+		Branch:   "maiao.I1111111111111111111111111111111111111111",
+		Base:     "main",
+		PR:       "1",
+		URL:      result.Changes[0].URL,
+		Action:   ChangeCreated,
+		Draft:    true,
+	}, result.Changes[0])
+	assert.Equal(t, "maiao.I1111111111111111111111111111111111111111", result.Changes[1].Base)
+
+	local := &api.Local{Path: filepath.Join(work, ".git", api.LocalStorePath)}
+	prs, err := local.PullRequests()
+	require.NoError(t, err)
+	require.Len(t, prs, 2)
+	assert.Equal(t, "main", prs[0].Base)
+	assert.Equal(t, "maiao.I1111111111111111111111111111111111111111", prs[0].Head)
+	assert.Equal(t, "First change", prs[0].Title)
+	assert.True(t, prs[0].Draft)
+	assert.Equal(t, "maiao.I1111111111111111111111111111111111111111", prs[1].Base)
+	assert.Equal(t, "maiao.I2222222222222222222222222222222222222222", prs[1].Head)
+	assert.Empty(t, gitCommand(t, remote, "branch", "--list", "maiao.*"), "rehearsals must not write to the remote")
+	assert.Equal(t, gitCommand(t, work, "rev-parse", "HEAD"), gitCommand(t, work, "rev-parse", "origin/maiao.I2222222222222222222222222222222222222222"))
+
+	t.Run("submitting the stack again updates the recorded pull requests", func(t *testing.T) {
+		options.WorkInProgress = false
+		options.Ready = true
+		result, err := Review(context.Background(), repo, options)
+		require.NoError(t, err)
+		require.Len(t, result.Changes, 2)
+		assert.Equal(t, ChangeUnchanged, result.Changes[0].Action)
+		assert.False(t, result.Changes[0].Draft)
+		prs, err := local.PullRequests()
+		require.NoError(t, err)
+		require.Len(t, prs, 2)
+		assert.False(t, prs[0].Draft)
+	})
+
+	t.Run("abandoning a change only deletes its remote tracking branch", func(t *testing.T) {
+		require.NoError(t, Abandon(context.Background(), repo, options, "HEAD", ""))
+		assert.Empty(t, gitCommand(t, work, "branch", "--remotes", "--list", "origin/maiao.I2222222222222222222222222222222222222222"))
+		assert.NotEmpty(t, gitCommand(t, work, "branch", "--remotes", "--list", "origin/maiao.I1111111111111111111111111111111111111111"))
+		assert.Empty(t, gitCommand(t, remote, "branch", "--list", "maiao.*"))
+	})
+
+	t.Run("pruning stale changes only deletes their remote tracking branches", func(t *testing.T) {
+		require.NoError(t, local.Close(context.Background(), &api.PullRequest{ID: "1"}, ""))
+		require.NoError(t, GC(context.Background(), repo, options, GCOptions{}))
+		assert.Empty(t, gitCommand(t, work, "branch", "--remotes", "--list", "origin/maiao.*"))
+	})
+}
+
+// forkLocal is a local backend opening pull requests from forks, like GitHub
+type forkLocal struct {
+	*api.Local
+}
+
+func (*forkLocal) SupportsForks() bool {
+	return true
+}
+
+func TestReviewFromFork(t *testing.T) {
+	work, upstream, repo := newTestStack(t,
+		"First change\n\nChange-Id: I1111111111111111111111111111111111111111",
+		"Second change\n\nChange-Id: I2222222222222222222222222222222222222222",
+	)
+	fork := filepath.Join(filepath.Dir(upstream), "contributor", "repo.git")
+	gitCommand(t, work, "clone", "--bare", upstream, fork)
+	gitCommand(t, work, "remote", "add", "fork", fork)
+	gitCommand(t, work, "config", "maiao.pushRemote", "fork")
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local"}
+	local := &api.Local{Path: filepath.Join(work, ".git", api.LocalStorePath), PushBranches: true}
+
+	_, err := Review(context.Background(), repo, options)
+	assert.EqualError(t, err, "pushing to fork is not supported by the pull request backend of origin, which can't open pull requests from forks")
+	assert.Empty(t, gitCommand(t, fork, "branch", "--list", "maiao.*"), "nothing must be pushed for backends without fork support")
+
+	reviewer := &Reviewer{
+		NewPullRequester: func(ctx context.Context, remote *git.Remote, options api.BackendOptions) (api.PullRequester, error) {
+			return &forkLocal{Local: local}, nil
+		},
+	}
+	_, err = reviewer.Review(context.Background(), repo, options)
+	require.NoError(t, err)
+
+	prs, err := local.PullRequests()
+	require.NoError(t, err)
+	require.Len(t, prs, 2)
+	assert.Equal(t, "contributor:maiao.I1111111111111111111111111111111111111111", prs[0].Head)
+	assert.Equal(t, "main", prs[0].Base)
+	assert.Equal(t, "contributor:maiao.I2222222222222222222222222222222222222222", prs[1].Head)
+	assert.Equal(t, "main", prs[1].Base, "parent branches only exist in the fork")
+	assert.Equal(t, "[need #1] Second change", prs[1].Title)
+
+	assert.Equal(t, gitCommand(t, work, "rev-parse", "HEAD"), gitCommand(t, fork, "rev-parse", "maiao.I2222222222222222222222222222222222222222"))
+	assert.Empty(t, gitCommand(t, upstream, "branch", "--list", "maiao.*"))
+}
+
+func TestReviewClosesRemovedChanges(t *testing.T) {
+	work, remote, repo := newTestStack(t,
+		"First change\n\nChange-Id: I1111111111111111111111111111111111111111",
+		"Second change\n\nChange-Id: I2222222222222222222222222222222222222222",
+		"Third change\n\nChange-Id: I3333333333333333333333333333333333333333",
+	)
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local"}
+	_, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+	local := &api.Local{Path: filepath.Join(work, ".git", api.LocalStorePath)}
+	prs, err := local.PullRequests()
+	require.NoError(t, err)
+	require.Len(t, prs, 3)
+	assert.Contains(t, prs[0].Body, "<!-- maiao-stack: I1111111111111111111111111111111111111111 I2222222222222222222222222222222222222222 I3333333333333333333333333333333333333333 -->")
+
+	// drop the second change
+	third := gitCommand(t, work, "rev-parse", "HEAD")
+	gitCommand(t, work, "reset", "--hard", "HEAD~2")
+	gitCommand(t, work, "cherry-pick", "--allow-empty", third)
+
+	t.Run("removed changes are kept open when not confirmed", func(t *testing.T) {
+		questions := []string{}
+		options := options
+		options.Confirm = func(question string) bool {
+			questions = append(questions, question)
+			return false
+		}
+		_, err := Review(context.Background(), repo, options)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"change I2222222222222222222222222222222222222222 was removed from the stack, close its PR file://" + filepath.ToSlash(local.Path) + "#2"}, questions)
+		prs, err := local.PullRequests()
+		require.NoError(t, err)
+		assert.False(t, prs[1].Closed)
+		assert.Equal(t, "maiao.I1111111111111111111111111111111111111111", prs[2].Base)
+		assert.NotContains(t, prs[2].Body, "I2222222222222222222222222222222222222222")
+	})
+
+	t.Run("removed changes are closed", func(t *testing.T) {
+		// the stack markers no longer reference the removed change, restore them as the first submission left them
+		prs, err := local.PullRequests()
+		require.NoError(t, err)
+		for _, pr := range prs {
+			_, err := local.Update(context.Background(), &api.PullRequest{ID: strconv.Itoa(pr.ID)}, api.PullRequestOptions{
+				Base:  pr.Base,
+				Title: pr.Title,
+				Body:  "<!-- maiao-stack: I1111111111111111111111111111111111111111 I2222222222222222222222222222222222222222 I3333333333333333333333333333333333333333 -->",
+			})
+			require.NoError(t, err)
+		}
+
+		options := options
+		options.CloseRemoved = true
+		_, err = Review(context.Background(), repo, options)
+		require.NoError(t, err)
+		prs, err = local.PullRequests()
+		require.NoError(t, err)
+		require.Len(t, prs, 3)
+		assert.False(t, prs[0].Closed)
+		assert.True(t, prs[1].Closed)
+		assert.Equal(t, []string{removedChangeComment}, prs[1].Comments)
+		assert.False(t, prs[2].Closed)
+		assert.Empty(t, gitCommand(t, remote, "branch", "--list", "maiao.I2222222222222222222222222222222222222222"))
+	})
+}
@@ -0,0 +1,104 @@
+package maiao
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/adevinta/maiao/pkg/api"
+	"github.com/adevinta/maiao/pkg/credentials"
+	lgit "github.com/adevinta/maiao/pkg/git"
+	gh "github.com/adevinta/maiao/pkg/github"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Reviewer submits and manages stacks of changes, allowing other programs to drive reviews without the command line.
+// Its zero value behaves like git review: the pull request backend is detected from the remote, credentials are
+// taken from the default credential store, progress messages are printed on stdout and stacks are rebased in-process
+type Reviewer struct {
+	// NewPullRequester instanciates the pull request backend of remote, api.NewPullRequester when nil
+	NewPullRequester func(ctx context.Context, remote *git.Remote, options api.BackendOptions) (api.PullRequester, error)
+	// Credentials authenticate git fetches and pushes, and the built-in pull request backends.
+	// gh.DefaultCredentialGetter is used when nil
+	Credentials credentials.CredentialGetter
+	// Output receives the progress messages, os.Stdout when nil
+	Output io.Writer
+	// Git runs the merge base lookups and the rebases, lgit.NativeExecutor when nil.
+	// lgit.CLIExecutor rebases with git rebase -i instead, resuming the review with os.Args once rebased
+	Git lgit.Executor
+}
+
+func (r *Reviewer) output() io.Writer {
+	if r.Output == nil {
+		return os.Stdout
+	}
+	return r.Output
+}
+
+func (r *Reviewer) git() lgit.Executor {
+	if r.Git == nil {
+		return lgit.NativeExecutor{}
+	}
+	return r.Git
+}
+
+// gitAuth authenticates git operations on endpoint
+func (r *Reviewer) gitAuth(endpoint *transport.Endpoint) *credentials.GitAuth {
+	getter := r.Credentials
+	if getter == nil {
+		getter = gh.DefaultCredentialGetter
+	}
+	return &credentials.GitAuth{Credentials: getter, Endpoint: endpoint}
+}
+
+// Review submits the changes between the target branch and HEAD for review with the default Reviewer
+func Review(ctx context.Context, repo lgit.Repository, options ReviewOptions) (*ReviewResult, error) {
+	return (&Reviewer{}).Review(ctx, repo, options)
+}
+
+// Status reports the state of the changes between the target branch and HEAD with the default Reviewer
+func Status(ctx context.Context, repo lgit.Repository, options ReviewOptions) ([]*ChangeStatus, error) {
+	return (&Reviewer{}).Status(ctx, repo, options)
+}
+
+// Abandon closes the pull request of a change with the default Reviewer
+func Abandon(ctx context.Context, repo lgit.Repository, options ReviewOptions, target, comment string) error {
+	return (&Reviewer{}).Abandon(ctx, repo, options, target, comment)
+}
+
+// Download checks out the stack of a change with the default Reviewer
+func Download(ctx context.Context, repo lgit.Repository, options ReviewOptions, target, localBranch string) error {
+	return (&Reviewer{}).Download(ctx, repo, options, target, localBranch)
+}
+
+// GC deletes the stale change branches of the push remote with the default Reviewer
+func GC(ctx context.Context, repo lgit.Repository, options ReviewOptions, gcOptions GCOptions) error {
+	return (&Reviewer{}).GC(ctx, repo, options, gcOptions)
+}
+
+// Land merges the pull request of the lowest change of the stack with the default Reviewer
+func Land(ctx context.Context, repo lgit.Repository, options ReviewOptions, landOptions LandOptions) error {
+	return (&Reviewer{}).Land(ctx, repo, options, landOptions)
+}
+
+// Continue resumes the review stopped on a conflicting rebase with the default Reviewer
+func Continue(ctx context.Context, repo lgit.Repository) (*ReviewResult, error) {
+	return (&Reviewer{}).Continue(ctx, repo)
+}
+
+// Skip resumes the review stopped on a conflicting rebase without the conflicting commit with the default Reviewer
+func Skip(ctx context.Context, repo lgit.Repository) (*ReviewResult, error) {
+	return (&Reviewer{}).Skip(ctx, repo)
+}
+
+// Abort stops the review stopped on a conflicting rebase with the default Reviewer
+func Abort(ctx context.Context, repo lgit.Repository) error {
+	return (&Reviewer{}).Abort(ctx, repo)
+}
+
+// Undo restores the branch, and optionally the remote branches and pull requests, as they were before a review
+// with the default Reviewer
+func Undo(ctx context.Context, repo lgit.Repository, options UndoOptions) error {
+	return (&Reviewer{}).Undo(ctx, repo, options)
+}
@@ -0,0 +1,206 @@
+package maiao
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adevinta/maiao/pkg/api"
+	"github.com/adevinta/maiao/pkg/credentials"
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingExecutor records the rebases instead of running them, as if they resumed the review
+type recordingExecutor struct {
+	lgit.NativeExecutor
+	todos []string
+}
+
+func (e *recordingExecutor) RebaseCommits(ctx context.Context, repo lgit.Repository, base, onto plumbing.Hash, todo string) (bool, error) {
+	e.todos = append(e.todos, todo)
+	return true, nil
+}
+
+type staticCredentials struct{}
+
+func (staticCredentials) CredentialForHost(string) (*credentials.Credentials, error) {
+	return &credentials.Credentials{Username: "john", Password: "secret"}, nil
+}
+
+func TestReviewer(t *testing.T) {
+	work, remote, repo := newTestStack(t, "First change\n\nChange-Id: I1111111111111111111111111111111111111111")
+	local := &api.Local{Path: filepath.Join(work, ".git", api.LocalStorePath)}
+	output := &bytes.Buffer{}
+	executor := &recordingExecutor{}
+	reviewer := &Reviewer{
+		NewPullRequester: func(ctx context.Context, remote *git.Remote, options api.BackendOptions) (api.PullRequester, error) {
+			assert.Equal(t, staticCredentials{}, options.Credentials)
+			return local, nil
+		},
+		Credentials: staticCredentials{},
+		Output:      output,
+		Git:         executor,
+	}
+	options := ReviewOptions{Remote: "origin", Branch: "main"}
+
+	t.Run("changes are submitted with the injected backend and messages written to the output", func(t *testing.T) {
+		result, err := reviewer.Review(context.Background(), repo, options)
+		require.NoError(t, err)
+		require.Len(t, result.Changes, 1)
+		assert.Equal(t, ChangeCreated, result.Changes[0].Action)
+		assert.Contains(t, output.String(), "created PR "+result.Changes[0].URL)
+		prs, err := local.PullRequests()
+		require.NoError(t, err)
+		assert.Len(t, prs, 1)
+	})
+
+	t.Run("Change-Ids are added without rebasing", func(t *testing.T) {
+		gitCommand(t, work, "commit", "--allow-empty", "-m", "Second change")
+		result, err := reviewer.Review(context.Background(), repo, options)
+		require.NoError(t, err)
+		assert.False(t, result.Rebased)
+		assert.Empty(t, executor.todos)
+		require.Len(t, result.Changes, 2)
+		assert.Equal(t, ChangeCreated, result.Changes[1].Action)
+		assert.Regexp(t, "^I[0-9a-f]{40}$", result.Changes[1].ChangeID)
+		assert.Contains(t, gitCommand(t, work, "log", "-1", "--format=%B"), "Change-Id: "+result.Changes[1].ChangeID)
+		assert.Contains(t, output.String(), "added Change-Id "+result.Changes[1].ChangeID+" to Second change")
+	})
+
+	t.Run("rebases are run by the injected executor", func(t *testing.T) {
+		other := cloneTestRemote(t, remote, "other")
+		gitCommand(t, other, "commit", "--allow-empty", "-m", "Someone else's change")
+		gitCommand(t, other, "push", "origin", "main")
+		result, err := reviewer.Review(context.Background(), repo, options)
+		require.NoError(t, err)
+		assert.True(t, result.Rebased)
+		assert.Empty(t, result.Changes)
+		require.Len(t, executor.todos, 1)
+		assert.NotContains(t, executor.todos[0], "reword")
+		assert.Contains(t, executor.todos[0], "pick")
+		assert.Contains(t, executor.todos[0], "Second change")
+	})
+}
+
+func TestReviewRebasesInProcess(t *testing.T) {
+	work, remote, repo := newTestStack(t)
+	require.NoError(t, os.WriteFile(filepath.Join(work, "file"), []byte("a\nb\nc\nd\ne\n"), 0644))
+	gitCommand(t, work, "add", "file")
+	gitCommand(t, work, "commit", "-m", "Add file")
+	gitCommand(t, work, "push", "origin", "main")
+	require.NoError(t, os.WriteFile(filepath.Join(work, "file"), []byte("A\nb\nc\nd\ne\n"), 0644))
+	gitCommand(t, work, "commit", "-a", "-m", "First change", "-m", "Change-Id: I1111111111111111111111111111111111111111")
+	gitCommand(t, work, "commit", "--allow-empty", "-m", "Second change", "-m", "Change-Id: I2222222222222222222222222222222222222222")
+
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local"}
+	_, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+
+	other := cloneTestRemote(t, remote, "other")
+	require.NoError(t, os.WriteFile(filepath.Join(other, "file"), []byte("a\nb\nc\nd\nE\n"), 0644))
+	gitCommand(t, other, "commit", "-a", "-m", "Someone else's change")
+	gitCommand(t, other, "push", "origin", "main")
+
+	t.Run("the rebased stack is submitted in the same review", func(t *testing.T) {
+		result, err := Review(context.Background(), repo, options)
+		require.NoError(t, err)
+		assert.True(t, result.Rebased)
+		assert.False(t, result.Resumed)
+		require.Len(t, result.Changes, 2)
+		assert.Equal(t, ChangeUpdated, result.Changes[0].Action)
+		assert.Equal(t, gitCommand(t, work, "rev-parse", "HEAD"), result.Changes[1].Commits[0])
+		assert.Equal(t, gitCommand(t, remote, "rev-parse", "main"), gitCommand(t, work, "rev-parse", "HEAD~2"))
+		assert.Equal(t, gitCommand(t, work, "rev-parse", "HEAD"), gitCommand(t, remote, "rev-parse", "maiao.I2222222222222222222222222222222222222222"))
+		assert.Equal(t, "A\nb\nc\nd\nE", gitCommand(t, work, "show", "HEAD:file"))
+	})
+
+	t.Run("conflicts are checked out to be resolved", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(other, "file"), []byte("X\nb\nc\nd\nE\n"), 0644))
+		gitCommand(t, other, "commit", "-a", "-m", "Conflicting change")
+		gitCommand(t, other, "push", "origin", "main")
+		head := gitCommand(t, work, "rev-parse", "HEAD")
+		_, err := Review(context.Background(), repo, options)
+		var conflict *lgit.ConflictError
+		require.True(t, errors.As(err, &conflict), "unexpected error %v", err)
+		require.Len(t, conflict.Conflicts, 1)
+		assert.Equal(t, "file", conflict.Conflicts[0].Path)
+		assert.Equal(t, head, gitCommand(t, work, "rev-parse", "main"))
+		assert.Equal(t, gitCommand(t, remote, "rev-parse", "main"), gitCommand(t, work, "rev-parse", "HEAD"))
+		content, err := os.ReadFile(filepath.Join(work, "file"))
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "<<<<<<< HEAD\nX\n=======\nA\n>>>>>>> ")
+		assert.FileExists(t, filepath.Join(work, ".git", reviewStatePath))
+
+		_, err = Review(context.Background(), repo, options)
+		assert.Equal(t, errReviewInProgress, err)
+
+		t.Run("aborted reviews restore the stack", func(t *testing.T) {
+			require.NoError(t, Abort(context.Background(), repo))
+			assert.Equal(t, "refs/heads/main", gitCommand(t, work, "symbolic-ref", "HEAD"))
+			assert.Equal(t, head, gitCommand(t, work, "rev-parse", "HEAD"))
+			assert.Empty(t, gitCommand(t, work, "status", "--porcelain"))
+			assert.NoFileExists(t, filepath.Join(work, ".git", reviewStatePath))
+			assert.Equal(t, errNoStoppedReview, Abort(context.Background(), repo))
+		})
+	})
+
+	t.Run("continued reviews submit the resolved stack", func(t *testing.T) {
+		_, err := Review(context.Background(), repo, options)
+		require.Error(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(work, "file"), []byte("Y\nb\nc\nd\nE\n"), 0644))
+		_, err = Continue(context.Background(), repo)
+		assert.Error(t, err, "unstaged resolutions must not be committed")
+
+		gitCommand(t, work, "add", "file")
+		result, err := Continue(context.Background(), repo)
+		require.NoError(t, err)
+		assert.True(t, result.Rebased)
+		require.Len(t, result.Changes, 2)
+		assert.Equal(t, "refs/heads/main", gitCommand(t, work, "symbolic-ref", "HEAD"))
+		assert.Equal(t, gitCommand(t, remote, "rev-parse", "main"), gitCommand(t, work, "rev-parse", "HEAD~2"))
+		assert.Equal(t, "Y\nb\nc\nd\nE", gitCommand(t, work, "show", "HEAD:file"))
+		assert.Equal(t, "First change", gitCommand(t, work, "log", "-1", "--format=%s", "HEAD~1"))
+		assert.Equal(t, gitCommand(t, work, "rev-parse", "HEAD"), gitCommand(t, remote, "rev-parse", "maiao.I2222222222222222222222222222222222222222"))
+		assert.Empty(t, gitCommand(t, work, "status", "--porcelain"))
+		assert.NoFileExists(t, filepath.Join(work, ".git", reviewStatePath))
+	})
+
+	t.Run("skipped commits are dropped from the stack", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(other, "file"), []byte("Z\nb\nc\nd\nE\n"), 0644))
+		gitCommand(t, other, "commit", "-a", "-m", "Another conflicting change")
+		gitCommand(t, other, "push", "origin", "main")
+		_, err := Review(context.Background(), repo, options)
+		require.Error(t, err)
+
+		result, err := Skip(context.Background(), repo)
+		require.NoError(t, err)
+		require.Len(t, result.Changes, 1)
+		assert.Equal(t, "I2222222222222222222222222222222222222222", result.Changes[0].ChangeID)
+		assert.Equal(t, gitCommand(t, remote, "rev-parse", "main"), gitCommand(t, work, "rev-parse", "HEAD~1"))
+		assert.Equal(t, "Z\nb\nc\nd\nE", gitCommand(t, work, "show", "HEAD:file"))
+		assert.Empty(t, gitCommand(t, work, "status", "--porcelain"))
+	})
+}
+
+func TestCheckStoppedReview(t *testing.T) {
+	work, _, repo := newTestStack(t, "First change")
+	require.NoError(t, saveReviewState(repo, &reviewState{GitRebase: true}))
+
+	assert.Equal(t, errReviewInProgress, checkStoppedReview(context.Background(), repo, ReviewOptions{}))
+	assert.Equal(t, errReviewInProgress, checkStoppedReview(context.Background(), repo, ReviewOptions{DryRun: true}))
+	assert.FileExists(t, filepath.Join(work, ".git", reviewStatePath))
+
+	require.NoError(t, checkStoppedReview(context.Background(), repo, ReviewOptions{ResumedAfterRebase: true}))
+	assert.NoFileExists(t, filepath.Join(work, ".git", reviewStatePath))
+	require.NoError(t, checkStoppedReview(context.Background(), repo, ReviewOptions{ResumedAfterRebase: true}))
+
+	require.NoError(t, saveReviewState(repo, &reviewState{}))
+	assert.Equal(t, errReviewInProgress, checkStoppedReview(context.Background(), repo, ReviewOptions{ResumedAfterRebase: true}), "reviews rebased in process are continued with git review")
+}
@@ -0,0 +1,102 @@
+package maiao
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/adevinta/maiao/pkg/system"
+)
+
+// reviewStatePath is where the state of a review stopped on a conflicting rebase is kept, in the git directory
+const reviewStatePath = "maiao/review-state.json"
+
+// errReviewInProgress is returned when a review starts while another one is stopped on conflicts
+var errReviewInProgress = errors.New("a review is stopped on a conflicting rebase, run git review --continue once the conflicts are resolved, or git review --skip or git review --abort")
+
+// reviewState records a review stopped on a conflicting rebase, for it to be continued, skipped or aborted
+type reviewState struct {
+	// Branch is the branch checked out when the review started, empty when HEAD was detached
+	Branch string `json:"branch,omitempty"`
+	// OriginalHead is the commit HEAD pointed to before the rebase, restored when the review is aborted
+	OriginalHead string `json:"originalHead"`
+	// Target is the commit of the target branch the stack is rebased on
+	Target string `json:"target"`
+	// Options are the options of the review, without Confirm
+	Options ReviewOptions `json:"options"`
+	// GitRebase tells the rebase is run by git rebase -i, that resolves the conflicts and resumes the review
+	GitRebase bool `json:"gitRebase,omitempty"`
+	// Current is the commit whose conflicts are being resolved
+	Current string `json:"current,omitempty"`
+	// Conflicts are the conflicting paths of Current
+	Conflicts []string `json:"conflicts,omitempty"`
+	// Pending are the commits to apply once Current is resolved, oldest first
+	Pending []string `json:"pending,omitempty"`
+	// Journal is the ID of the journal of the review, recording what it changes
+	Journal string `json:"journal,omitempty"`
+}
+
+// reviewStateFile returns the path of the review state of repo
+func reviewStateFile(repo lgit.Repository) (string, error) {
+	gitDir := repositoryGitDir(repo)
+	if gitDir == "" {
+		return "", errors.New("unable to find the git directory")
+	}
+	return filepath.Join(gitDir, reviewStatePath), nil
+}
+
+// loadReviewState returns the state of the review stopped on conflicts, nil when there is none
+func loadReviewState(repo lgit.Repository) (*reviewState, error) {
+	path, err := reviewStateFile(repo)
+	if err != nil {
+		// without a git directory, no review could be stopped
+		return nil, nil
+	}
+	data, err := afero.ReadFile(system.DefaultFileSystem, path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &reviewState{}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// saveReviewState records the review stopped on conflicts
+func saveReviewState(repo lgit.Repository, state *reviewState) error {
+	path, err := reviewStateFile(repo)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = system.DefaultFileSystem.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(system.DefaultFileSystem, path, data, 0644)
+}
+
+// removeReviewState forgets the review stopped on conflicts, once it is continued or aborted
+func removeReviewState(repo lgit.Repository) error {
+	path, err := reviewStateFile(repo)
+	if err != nil {
+		return err
+	}
+	err = system.DefaultFileSystem.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package maiao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adevinta/maiao/pkg/api"
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sirupsen/logrus"
+)
+
+// ChangeStatus describes how a local change maps to its remote branch and pull request
+type ChangeStatus struct {
+	// ChangeID is the Change-Id of the change, empty when the commit has none yet
+	ChangeID string `json:"changeId"`
+	Title    string `json:"title"`
+	// SHA is the local commit of the change, including its fixups
+	SHA string `json:"sha"`
+	// Branch is the remote branch the change is pushed to
+	Branch string `json:"branch,omitempty"`
+	// RemoteSHA is the commit the remote branch points to, empty when the change was never pushed
+	RemoteSHA string `json:"remoteSha,omitempty"`
+	// PullRequest is the pull request opened for the change, if any
+	PullRequest *api.PullRequestStatus `json:"pullRequest,omitempty"`
+}
+
+// InSync reports whether the remote branch points to the local change
+func (s *ChangeStatus) InSync() bool {
+	return s.RemoteSHA != "" && s.RemoteSHA == s.SHA
+}
+
+// Status returns the status of the changes between the target branch and HEAD, from the oldest to the newest.
+// It only fetches the remotes and looks up pull requests, nothing is pushed or updated
+func (r *Reviewer) Status(ctx context.Context, repo lgit.Repository, options ReviewOptions) ([]*ChangeStatus, error) {
+	defaultRemoteOption(ctx, repo, &options)
+	head, err := repo.Head()
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to retrieve git HEAD")
+		return nil, err
+	}
+	ctx = log.WithContextFields(ctx, logrus.Fields{
+		"context": "reviewing stack status",
+		"remote":  options.Remote,
+		"branch":  options.Branch,
+		"headSHA": head.Hash().String(),
+	})
+
+	remote, prAPI, err := r.newPullRequester(ctx, repo, &options)
+	if err != nil {
+		return nil, err
+	}
+	err = r.fetchRemote(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	pushRemote := options.Remote
+	forkOwner := ""
+	if options.PushRemote != "" && options.PushRemote != options.Remote {
+		pushRemote = options.PushRemote
+		forkOwner, _, err = forkRemote(repo, pushRemote)
+		if err != nil {
+			return nil, err
+		}
+		fork, err := repo.Remote(pushRemote)
+		if err != nil {
+			return nil, err
+		}
+		err = r.fetchRemote(ctx, fork)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	remoteRef := plumbing.Revision(fmt.Sprintf("%s/%s", options.Remote, options.Branch))
+	base, err := r.git().MergeBase(ctx, repo, remoteRef, plumbing.Revision(plumbing.HEAD))
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Errorf("unable to find common ancestor")
+		return nil, err
+	}
+	changes, err := extractChanges(ctx, repo, base, head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []*ChangeStatus{}
+	for _, change := range changes {
+		status := &ChangeStatus{
+			ChangeID: change.changeID,
+			Title:    change.message.GetTitle(),
+			SHA:      change.head.Hash.String(),
+			Branch:   change.branch,
+		}
+		statuses = append(statuses, status)
+		if change.branch == "" {
+			continue
+		}
+		remoteHash, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewRemoteReferenceName(pushRemote, change.branch)))
+		if err == nil {
+			status.RemoteSHA = remoteHash.String()
+		}
+		prHead := change.branch
+		if forkOwner != "" {
+			prHead = forkOwner + ":" + change.branch
+		}
+		status.PullRequest, err = prAPI.Find(ctx, prHead)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return statuses, nil
+}
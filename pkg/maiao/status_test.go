@@ -0,0 +1,53 @@
+package maiao
+
+import (
+	"context"
+	"testing"
+
+	"github.com/adevinta/maiao/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatus(t *testing.T) {
+	work, remote, repo := newTestStack(t,
+		"First change\n\nChange-Id: I1111111111111111111111111111111111111111",
+		"Second change\n\nChange-Id: I2222222222222222222222222222222222222222",
+	)
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local", WorkInProgress: true}
+	_, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+
+	gitCommand(t, work, "commit", "--amend", "--allow-empty", "-m", "Second change updated", "-m", "Change-Id: I2222222222222222222222222222222222222222")
+	gitCommand(t, work, "commit", "--allow-empty", "-m", "Third change", "-m", "Change-Id: I3333333333333333333333333333333333333333")
+	gitCommand(t, work, "commit", "--allow-empty", "-m", "Work in progress")
+
+	statuses, err := Status(context.Background(), repo, ReviewOptions{Remote: "origin", Branch: "main", Backend: "local"})
+	require.NoError(t, err)
+	require.Len(t, statuses, 4)
+
+	assert.Equal(t, "I1111111111111111111111111111111111111111", statuses[0].ChangeID)
+	assert.Equal(t, "First change", statuses[0].Title)
+	assert.Equal(t, gitCommand(t, work, "rev-parse", "HEAD~3"), statuses[0].SHA)
+	assert.True(t, statuses[0].InSync())
+	require.NotNil(t, statuses[0].PullRequest)
+	assert.Equal(t, "1", statuses[0].PullRequest.ID)
+	assert.Equal(t, api.PullRequestStateDraft, statuses[0].PullRequest.State)
+	assert.Equal(t, "main", statuses[0].PullRequest.Base)
+
+	assert.Equal(t, "Second change updated", statuses[1].Title)
+	assert.False(t, statuses[1].InSync())
+	assert.NotEmpty(t, statuses[1].RemoteSHA)
+	require.NotNil(t, statuses[1].PullRequest)
+	assert.Equal(t, "2", statuses[1].PullRequest.ID)
+
+	assert.Equal(t, "I3333333333333333333333333333333333333333", statuses[2].ChangeID)
+	assert.Empty(t, statuses[2].RemoteSHA)
+	assert.Nil(t, statuses[2].PullRequest)
+
+	assert.Empty(t, statuses[3].ChangeID)
+	assert.Empty(t, statuses[3].Branch)
+	assert.Nil(t, statuses[3].PullRequest)
+
+	assert.Empty(t, gitCommand(t, remote, "branch", "--list", "maiao.I3333333333333333333333333333333333333333"), "status must not push anything")
+}
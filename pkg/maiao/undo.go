@@ -0,0 +1,140 @@
+package maiao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/adevinta/maiao/pkg/api"
+	lgit "github.com/adevinta/maiao/pkg/git"
+	"github.com/adevinta/maiao/pkg/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// undoComment is left on the pull requests closed when undoing the review that created them
+const undoComment = "Closed by git review undo"
+
+// UndoOptions configures the undoing of a review
+type UndoOptions struct {
+	// ID identifies the review to undo, as found in the refs/maiao/backup/<ID> references.
+	// The most recent review is undone when empty
+	ID string
+	// Remote restores the remote branches pushed by the review and the pull requests it updated as well,
+	// and closes the pull requests it created. Only the local branch is restored otherwise
+	Remote bool
+}
+
+// Undo restores the branch checked out when the review started, as it was before the review, from its backup reference.
+// The working tree must not have uncommitted changes. Once undone, the journal of the review is removed, so the next
+// Undo restores the review before. The pull requests closed by the review are not reopened
+func (r *Reviewer) Undo(ctx context.Context, repo lgit.Repository, options UndoOptions) error {
+	state, err := loadReviewState(repo)
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		return errReviewInProgress
+	}
+	journal, err := loadJournal(repo, options.ID)
+	if err != nil {
+		return err
+	}
+	err = lgit.CheckClean(repo)
+	if err != nil {
+		return err
+	}
+	backup, err := repo.ResolveRevision(plumbing.Revision(journal.backupRef()))
+	if err != nil {
+		log.ForContext(ctx).WithError(err).Error("failed to find the backup reference")
+		return fmt.Errorf("unable to find the %s backup of the review %s", journal.backupRef(), journal.ID)
+	}
+	if options.Remote {
+		err = r.undoRemote(ctx, repo, journal)
+		if err != nil {
+			return err
+		}
+	}
+	err = lgit.CheckoutBranch(repo, plumbing.ReferenceName(journal.Branch), *backup)
+	if err != nil {
+		return err
+	}
+	name := "HEAD"
+	if journal.Branch != "" {
+		name = plumbing.ReferenceName(journal.Branch).Short()
+	}
+	fmt.Fprintln(r.output(), fmt.Sprintf("restored %s at %s, as before the review of %s", name, backup.String()[:7], journal.Time.Local().Format("2006-01-02 15:04:05")))
+	return journal.remove(repo)
+}
+
+// undoRemote restores the pull requests and the remote branches recorded in journal
+func (r *Reviewer) undoRemote(ctx context.Context, repo lgit.Repository, journal *reviewJournal) error {
+	options := journal.Options
+	remote, prAPI, err := r.newPullRequester(ctx, repo, &options)
+	if err != nil {
+		return err
+	}
+	// pull requests are restored first, for none of them to be closed by the forge when a branch they target is deleted
+	for i := range journal.PullRequests {
+		pr := journal.PullRequests[i]
+		if pr.Created {
+			manager, ok := prAPI.(api.PullRequestManager)
+			if !ok {
+				fmt.Fprintln(r.output(), fmt.Sprintf("unable to close PR %s, the pull request backend does not support it", pr.URL))
+				continue
+			}
+			err = manager.Close(ctx, &pr.PullRequest, undoComment)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(r.output(), fmt.Sprintf("closed PR %s", pr.URL))
+			continue
+		}
+		_, err = prAPI.Update(ctx, &pr.PullRequest, api.PullRequestOptions{
+			Base:  pr.Base,
+			Head:  pr.Head,
+			Title: pr.Title,
+			Body:  pr.Body,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(r.output(), fmt.Sprintf("restored PR %s", pr.URL))
+	}
+	if len(journal.Branches) == 0 {
+		return nil
+	}
+
+	refspecs := []config.RefSpec{}
+	for _, branch := range journal.Branches {
+		if branch.Previous == "" {
+			refspecs = append(refspecs, config.RefSpec(":"+plumbing.NewBranchReferenceName(branch.Name).String()))
+		} else {
+			refspecs = append(refspecs, config.RefSpec("+"+branch.Previous+":"+plumbing.NewBranchReferenceName(branch.Name).String()))
+		}
+	}
+	if len(remote.Config().URLs) != 1 {
+		return errors.New("multiple URLs not supported")
+	}
+	endpoint, err := transport.NewEndpoint(remote.Config().URLs[0])
+	if err != nil {
+		return err
+	}
+	pushRemote := options.Remote
+	if options.PushRemote != "" && options.PushRemote != options.Remote {
+		pushRemote = options.PushRemote
+		_, endpoint, err = forkRemote(repo, pushRemote)
+		if err != nil {
+			return err
+		}
+	}
+	log.ForContext(ctx).WithField("refspec", refspecs).WithField("pushRemote", pushRemote).Debugf("restoring remote branches")
+	err = r.pushBranches(ctx, repo, prAPI, pushRemote, endpoint, refspecs, true)
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	fmt.Fprintln(r.output(), fmt.Sprintf("restored %d branches on %s", len(refspecs), pushRemote))
+	return nil
+}
@@ -0,0 +1,130 @@
+package maiao
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adevinta/maiao/pkg/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndo(t *testing.T) {
+	work, remote, repo := newTestStack(t)
+	require.NoError(t, os.WriteFile(filepath.Join(work, "file"), []byte("a\nb\nc\n"), 0644))
+	gitCommand(t, work, "add", "file")
+	gitCommand(t, work, "commit", "-m", "Add file")
+	gitCommand(t, work, "push", "origin", "main")
+	gitCommand(t, work, "commit", "--allow-empty", "-m", "First change", "-m", "Change-Id: I1111111111111111111111111111111111111111")
+	gitCommand(t, work, "commit", "--allow-empty", "-m", "Second change")
+	initial := gitCommand(t, work, "rev-parse", "HEAD")
+
+	local := &api.Local{Path: filepath.Join(work, ".git", api.LocalStorePath)}
+	options := ReviewOptions{Remote: "origin", Branch: "main", Backend: "local"}
+	journals := func() []string {
+		ids, err := journalIDs(filepath.Join(work, ".git", journalDir))
+		require.NoError(t, err)
+		return ids
+	}
+	titles := func() []string {
+		prs, err := local.PullRequests()
+		require.NoError(t, err)
+		titles := []string{}
+		for _, pr := range prs {
+			if !pr.Closed {
+				titles = append(titles, pr.Title)
+			}
+		}
+		return titles
+	}
+
+	_, err := Review(context.Background(), repo, ReviewOptions{Remote: "origin", Branch: "main", Backend: "local", DryRun: true})
+	require.NoError(t, err)
+	assert.Empty(t, journals(), "dry runs must not be recorded")
+
+	first, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+	require.Len(t, first.Changes, 2)
+	second := "maiao." + first.Changes[1].ChangeID
+	require.Len(t, journals(), 1)
+	reviewed := gitCommand(t, work, "rev-parse", "HEAD")
+	remoteFirst := gitCommand(t, remote, "rev-parse", "maiao.I1111111111111111111111111111111111111111")
+	remoteSecond := gitCommand(t, remote, "rev-parse", second)
+
+	other := cloneTestRemote(t, remote, "other")
+	require.NoError(t, os.WriteFile(filepath.Join(other, "file"), []byte("a\nb\nC\n"), 0644))
+	gitCommand(t, other, "commit", "-a", "-m", "Someone else's change")
+	gitCommand(t, other, "push", "origin", "main")
+	gitCommand(t, work, "commit", "--amend", "--allow-empty", "-m", "Second change, reworded", "-m", "Change-Id: "+first.Changes[1].ChangeID)
+	gitCommand(t, work, "commit", "--allow-empty", "-m", "Third change")
+	amended := gitCommand(t, work, "rev-parse", "HEAD")
+
+	result, err := Review(context.Background(), repo, options)
+	require.NoError(t, err)
+	require.True(t, result.Rebased)
+	require.Len(t, result.Changes, 3)
+	third := "maiao." + result.Changes[2].ChangeID
+	assert.Equal(t, []string{"First change", "[need #1] Second change, reworded", "[need #2] Third change"}, titles())
+
+	ids := journals()
+	require.Len(t, ids, 2)
+	assert.Equal(t, amended, gitCommand(t, work, "rev-parse", backupRefPrefix+ids[1]))
+	assert.Equal(t, initial, gitCommand(t, work, "rev-parse", backupRefPrefix+ids[0]))
+
+	t.Run("undo refuses to overwrite uncommitted changes", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(work, "file"), []byte("dirty\n"), 0644))
+		defer gitCommand(t, work, "checkout", "file")
+		assert.Error(t, Undo(context.Background(), repo, UndoOptions{}))
+		assert.Len(t, journals(), 2)
+	})
+
+	t.Run("undo restores the remote branches and pull requests when asked to", func(t *testing.T) {
+		require.NoError(t, Undo(context.Background(), repo, UndoOptions{Remote: true}))
+		assert.Equal(t, "refs/heads/main", gitCommand(t, work, "symbolic-ref", "HEAD"))
+		assert.Equal(t, amended, gitCommand(t, work, "rev-parse", "HEAD"))
+		assert.Empty(t, gitCommand(t, work, "status", "--porcelain"))
+		assert.Equal(t, remoteFirst, gitCommand(t, remote, "rev-parse", "maiao.I1111111111111111111111111111111111111111"))
+		assert.Equal(t, remoteSecond, gitCommand(t, remote, "rev-parse", second))
+		assert.NotContains(t, gitCommand(t, remote, "branch", "--list", "maiao.*"), strings.TrimPrefix(third, "maiao."))
+		assert.Equal(t, []string{"First change", "[need #1] Second change"}, titles())
+		assert.Equal(t, []string{ids[0]}, journals())
+		assert.Empty(t, gitCommand(t, work, "for-each-ref", backupRefPrefix+ids[1]))
+	})
+
+	t.Run("undo only restores the local branch by default", func(t *testing.T) {
+		require.NoError(t, Undo(context.Background(), repo, UndoOptions{}))
+		assert.Equal(t, initial, gitCommand(t, work, "rev-parse", "HEAD"))
+		assert.NotEqual(t, reviewed, initial)
+		assert.Equal(t, remoteSecond, gitCommand(t, remote, "rev-parse", second))
+		assert.Equal(t, []string{"First change", "[need #1] Second change"}, titles())
+		assert.Empty(t, journals())
+		assert.Empty(t, gitCommand(t, work, "for-each-ref", "refs/maiao/backup"))
+	})
+
+	t.Run("undo fails when no review is recorded", func(t *testing.T) {
+		assert.EqualError(t, Undo(context.Background(), repo, UndoOptions{}), "no review to undo")
+		assert.EqualError(t, Undo(context.Background(), repo, UndoOptions{ID: "unknown"}), "no review unknown to undo")
+	})
+}
+
+func TestPruneJournals(t *testing.T) {
+	work, _, repo := newTestStack(t)
+	head, err := repo.Head()
+	require.NoError(t, err)
+
+	ids := []string{}
+	for i := 0; i < journalLimit+2; i++ {
+		j := newJournal(repo, head, ReviewOptions{})
+		j.ID = j.ID + string(rune('a'+i))
+		require.NoError(t, j.save(repo))
+		ids = append(ids, j.ID)
+	}
+	kept, err := journalIDs(filepath.Join(work, ".git", journalDir))
+	require.NoError(t, err)
+	assert.Equal(t, ids[2:], kept)
+	assert.Len(t, strings.Split(gitCommand(t, work, "for-each-ref", "--format=%(refname)", "refs/maiao/backup"), "\n"), journalLimit)
+	assert.Empty(t, gitCommand(t, work, "for-each-ref", backupRefPrefix+ids[0]))
+}